@@ -0,0 +1,144 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/grpc-dump/dump"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpformat"
+	"github.com/bradleyjkemp/grpc-tools/internal/har"
+	"github.com/bradleyjkemp/grpc-tools/internal/otlp"
+	"github.com/bradleyjkemp/grpc-tools/internal/pcap"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/jhump/protoreflect/desc/protoprint"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+)
+
+// Run converts captured RPCs from one format into another.
+// Messages read from a packet capture are decoded using the proto roots/descriptors
+// (falling back to heuristic decoding) and TLS connections are decrypted using the key log.
+// If an OTLP endpoint is given then spans are sent to it rather than written to output.
+// Inferred .proto files are written to outputDir.
+func Run(input io.Reader, output io.Writer, from, to, keyLogPath string, protoSources proto_decoder.ProtoSources, otlpEndpoint, outputDir string) error {
+	logger := logrus.New()
+
+	var rpcs []*internal.RPC
+	var err error
+	switch from {
+	case "json", "text", "binary":
+		// the format of dumps is detected automatically
+		rpcs, err = dumpformat.Read(input)
+	case "pcap":
+		rpcs, err = readPcap(logger, input, keyLogPath, protoSources)
+	default:
+		return fmt.Errorf("unknown input format %s", from)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch to {
+	case "json":
+		return writeDump(dump.NewJSONWriter(output), rpcs)
+	case "text":
+		return writeDump(dumpformat.NewTextWriter(output), rpcs)
+	case "binary":
+		return writeDump(dumpformat.NewBinaryWriter(output), rpcs)
+	case "har":
+		return writeHAR(output, rpcs)
+	case "otlp":
+		if otlpEndpoint != "" {
+			return otlp.Export(otlpEndpoint, otlp.FromRPCs(rpcs...))
+		}
+		return writeJSON(output, otlp.FromRPCs(rpcs...))
+	case "proto":
+		return writeProtos(logger, outputDir, rpcs)
+	default:
+		return fmt.Errorf("unknown output format %s", to)
+	}
+}
+
+func readPcap(logger logrus.FieldLogger, input io.Reader, keyLogPath string, protoSources proto_decoder.ProtoSources) ([]*internal.RPC, error) {
+	var keys pcap.KeyLog
+	if keyLogPath != "" {
+		keyLog, err := ioutil.ReadFile(keyLogPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key log: %v", err)
+		}
+		keys, err = pcap.ParseKeyLog(keyLog)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resolvers, err := proto_decoder.NewResolvers(protoSources)
+	if err != nil {
+		return nil, err
+	}
+	decoder := proto_decoder.NewDecoder(logger, resolvers...)
+
+	rpcs, err := pcap.ReadRPCs(logger, input, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, rpc := range rpcs {
+		for _, message := range rpc.Messages {
+			decoded, err := decoder.Decode(rpc.StreamName(), message)
+			if err != nil {
+				logger.WithError(err).Warn("Failed to decode message")
+			}
+			message.Message = decoded
+			message.UnknownFieldTypes = proto_decoder.UnknownFieldTypes(decoded)
+		}
+	}
+	return rpcs, nil
+}
+
+func writeDump(writer dump.Writer, rpcs []*internal.RPC) error {
+	for _, rpc := range rpcs {
+		if err := writer.Write(rpc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHAR(output io.Writer, rpcs []*internal.RPC) error {
+	var entries []*har.Entry
+	for _, rpc := range rpcs {
+		entry, err := har.FromRPC(rpc)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s: %v", rpc.StreamName(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return writeJSON(output, har.NewHAR(entries...))
+}
+
+// writeProtos infers the definitions of the services called from the raw messages
+// and writes a .proto file for each of them
+func writeProtos(logger logrus.FieldLogger, outputDir string, rpcs []*internal.RPC) error {
+	if outputDir == "" {
+		return fmt.Errorf("--output_dir must be set when using the proto format")
+	}
+	inferrer := proto_decoder.NewSchemaInferrer()
+	for _, rpc := range rpcs {
+		if err := inferrer.Observe(rpc); err != nil {
+			logger.WithError(err).Warn("Failed to infer message types")
+		}
+	}
+	files, err := inferrer.Files()
+	if err != nil {
+		return err
+	}
+	return (&protoprint.Printer{}).PrintProtosToFileSystem(files, outputDir)
+}
+
+func writeJSON(output io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/grpc-convert/convert"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	_ "github.com/bradleyjkemp/grpc-tools/internal/versionflag"
+	"io"
+	"os"
+)
+
+func main() {
+	var (
+		dumpPath         = flag.String("dump", "", "The gRPC dump (or packet capture) to convert. By default the input is read from stdin.")
+		from             = flag.String("from", "json", "Format of the input. Values are {json, text, binary, pcap}. The format of dumps is detected automatically. The pcap format reads pcap and pcapng packet captures.")
+		to               = flag.String("to", "har", "Format to convert the dump to. Values are {json, text, binary, har, otlp, proto}. The proto format writes inferred .proto files to --output_dir.")
+		outputDir        = flag.String("output_dir", "", "Directory to write .proto files to when using the proto format.")
+		keyLog           = flag.String("keylog", "", "A TLS key log file (e.g. written using SSLKEYLOGFILE) used to decrypt TLS connections in a packet capture.")
+		protoRoots       = flag.String("proto_roots", "", "A comma separated list of directories to search for gRPC service definitions (used to decode messages in a packet capture).")
+		protoDescriptors = flag.String("proto_descriptors", "", "A comma separated list of descriptor set files (e.g. written by protoc --descriptor_set_out --include_imports) to load gRPC service definitions from (used to decode messages in a packet capture).")
+		protoImportPaths = flag.String("proto_import_paths", "", "A comma separated list of directories to search for files imported by the .proto files in --proto_roots.")
+		protoStrict      = flag.Bool("proto_strict", false, "Fail if any .proto file in --proto_roots can't be parsed. By default such files are skipped.")
+		otlpEndpoint     = flag.String("otlp_endpoint", "", "An OTLP/HTTP collector endpoint (e.g. http://localhost:4318) to send spans to when using the otlp format. By default spans are written to stdout as OTLP/JSON.")
+	)
+
+	flag.Parse()
+	err := run(*dumpPath, *from, *to, *keyLog, proto_decoder.ProtoSources{Roots: *protoRoots, ImportPaths: *protoImportPaths, Descriptors: *protoDescriptors, Strict: *protoStrict}, *otlpEndpoint, *outputDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func run(dumpPath, from, to, keyLog string, protoSources proto_decoder.ProtoSources, otlpEndpoint, outputDir string) error {
+	var input io.Reader = os.Stdin
+	if dumpPath != "" {
+		dumpFile, err := os.Open(dumpPath)
+		if err != nil {
+			return err
+		}
+		defer dumpFile.Close()
+		input = dumpFile
+	}
+	return convert.Run(input, os.Stdout, from, to, keyLog, protoSources, otlpEndpoint, outputDir)
+}
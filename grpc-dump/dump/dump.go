@@ -5,32 +5,40 @@ import (
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
 	"github.com/sirupsen/logrus"
 	"io"
-	"strings"
+	"time"
 )
 
-func Run(output io.Writer, protoRoots, protoDescriptors string, proxyConfig ...grpc_proxy.Configurator) error {
-	var resolvers []proto_decoder.MessageResolver
-	if protoRoots != "" {
-		r, err := proto_decoder.NewFileResolver(strings.Split(protoRoots, ",")...)
-		if err != nil {
-			return err
-		}
-		resolvers = append(resolvers, r)
-	}
-	if protoDescriptors != "" {
-		r, err := proto_decoder.NewDescriptorResolver(strings.Split(protoRoots, ",")...)
-		if err != nil {
-			return err
-		}
-		resolvers = append(resolvers, r)
+// Logs are written to logOutput.
+// If filter is non-nil then only RPCs matching the filter are written to output.
+// If redactor is non-nil then sensitive metadata and message fields are redacted before being written to output.
+// If the protos are watched then RPCs that couldn't be decoded are updated in output (if any of its writers are Updaters) once they can be.
+func Run(output Writer, logOutput io.Writer, protoSources proto_decoder.ProtoSources, filter *Filter, redactor *Redactor, proxyConfig ...grpc_proxy.Configurator) error {
+	resolvers, err := proto_decoder.NewResolvers(protoSources)
+	if err != nil {
+		return err
 	}
 
 	// TODO: unify this logger with the one provided by grpc_proxy?
 	logger := logrus.New()
+	logger.SetOutput(logOutput)
+	decoder := proto_decoder.NewDecoder(logger, resolvers...)
+	var redecode *redecoder
+	if protoSources.Watch {
+		// redecoding keeps RPCs in memory so is only worth it if they can be shown again
+		if updater, ok := updaterFor(output); ok {
+			redecode = newRedecoder(logger, updater, decoder, resolvers, redactor)
+		}
+		proto_decoder.Watch(resolvers, time.Second, func() {
+			if redecode != nil {
+				redecode.redecode()
+			}
+		})
+	}
 	opts := append(
 		proxyConfig,
+		grpc_proxy.WithLogOutput(logOutput),
 		grpc_proxy.WithInterceptor(
-			dumpInterceptor(logger, output, proto_decoder.NewDecoder(logger, resolvers...))),
+			dumpInterceptor(logger, output, decoder, filter, redactor, redecode)),
 	)
 	proxy, err := grpc_proxy.New(
 		opts...,
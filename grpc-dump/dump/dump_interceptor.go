@@ -1,23 +1,29 @@
 package dump
 
 import (
-	"encoding/json"
-	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/internal"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
-	"io"
 	"strings"
+	"time"
 )
 
 // dump interceptor implements a gRPC.StreamingServerInterceptor that dumps all RPC details
-func dumpInterceptor(logger logrus.FieldLogger, output io.Writer, decoder proto_decoder.MessageDecoder) grpc.StreamServerInterceptor {
+// If redecode is non-nil then it's given the RPCs which can be decoded again once the protos are reloaded.
+func dumpInterceptor(logger logrus.FieldLogger, output Writer, decoder proto_decoder.MessageDecoder, filter *Filter, redactor *Redactor, redecode *redecoder) grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		dss := &recordedServerStream{ServerStream: ss}
+		start := time.Now()
+		upstream := &internal.Upstream{}
+		dss := &recordedServerStream{
+			ServerStream: ss,
+			ctx:          internal.WithUpstream(ss.Context(), upstream),
+		}
 		rpcErr := handler(srv, dss)
+		end := time.Now()
 		var rpcStatus *internal.Status
 		if rpcErr != nil {
 			grpcStatus, _ := status.FromError(rpcErr)
@@ -29,24 +35,50 @@ func dumpInterceptor(logger logrus.FieldLogger, output io.Writer, decoder proto_
 
 		fullMethod := strings.Split(info.FullMethod, "/")
 		md, _ := metadata.FromIncomingContext(ss.Context())
+		connection := internal.ConnectionFromContext(ss.Context())
+		if p, ok := peer.FromContext(ss.Context()); ok && p.Addr != nil {
+			connection.ClientAddress = p.Addr.String()
+		}
+		connection.UpstreamAddress = upstream.Address
 		rpc := internal.RPC{
 			Service:  fullMethod[1],
 			Method:   fullMethod[2],
 			Messages: dss.events,
 			Status:   rpcStatus,
 			Metadata: md,
+
+			ResponseHeaders:  dss.headers,
+			ResponseTrailers: dss.trailers,
+
+			Timing:     internal.NewTiming(start, end, upstream.Dial, dss.events),
+			Connection: connection,
+		}
+
+		if filter != nil && !filter.shouldRecord(&rpc) {
+			return rpcErr
 		}
 
-		var err error
 		for _, message := range rpc.Messages {
-			message.Message, err = decoder.Decode(info.FullMethod, message)
+			decoded, err := decoder.Decode(info.FullMethod, message)
 			if err != nil {
 				logger.WithError(err).Warn("Failed to decode message")
 			}
+			message.Message = decoded
+			message.UnknownFieldTypes = proto_decoder.UnknownFieldTypes(decoded)
 		}
 
-		dump, _ := json.Marshal(rpc)
-		fmt.Fprintln(output, string(dump))
+		if redactor != nil {
+			if err := redactor.redactRPC(&rpc); err != nil {
+				logger.WithError(err).Warn("Failed to redact RPC")
+			}
+		}
+
+		if err := output.Write(&rpc); err != nil {
+			logger.WithError(err).Warn("Failed to write RPC to dump")
+		}
+		if redecode != nil {
+			redecode.add(&rpc)
+		}
 		return rpcErr
 	}
 }
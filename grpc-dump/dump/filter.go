@@ -0,0 +1,133 @@
+package dump
+
+import (
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Filter decides which RPCs are recorded in the dump.
+// An RPC is recorded if it matches any include rule (or there are none)
+// and doesn't match any exclude rule. Of those RPCs, only 1 in every sample is recorded.
+type Filter struct {
+	include []filterRule
+	exclude []filterRule
+	sample  uint64
+
+	// count of RPCs that have passed the include/exclude rules
+	count uint64
+}
+
+// filterRule matches an attribute of an RPC against a glob pattern
+type filterRule struct {
+	attribute string
+	pattern   *regexp.Regexp
+}
+
+// NewFilter creates a Filter from comma separated lists of rules of the form attribute=pattern
+// where attribute is one of service, method, authority, status or metadata.<key>
+// and pattern is a glob e.g. "service=grpc.health.v1.*,metadata.user-agent=grpc-go*".
+// Returns nil if no rules are configured.
+func NewFilter(include, exclude string, sample int) (*Filter, error) {
+	if include == "" && exclude == "" && sample <= 1 {
+		return nil, nil
+	}
+
+	f := &Filter{
+		sample: 1,
+	}
+	if sample > 1 {
+		f.sample = uint64(sample)
+	}
+
+	var err error
+	if f.include, err = parseFilterRules(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseFilterRules(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseFilterRules(rules string) ([]filterRule, error) {
+	if rules == "" {
+		return nil, nil
+	}
+	var parsed []filterRule
+	for _, rule := range strings.Split(rules, ",") {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid filter %s: must be of the form attribute=pattern", rule)
+		}
+		attribute, pattern := parts[0], parts[1]
+		switch {
+		case attribute == "service", attribute == "method", attribute == "authority", attribute == "status":
+		case strings.HasPrefix(attribute, "metadata."):
+		default:
+			return nil, fmt.Errorf("invalid filter %s: unknown attribute %s", rule, attribute)
+		}
+		parsed = append(parsed, filterRule{attribute, globPattern(pattern)})
+	}
+	return parsed, nil
+}
+
+// shouldRecord must be called before the RPC's messages are decoded
+// so that no time is wasted decoding RPCs that won't be recorded.
+func (f *Filter) shouldRecord(rpc *internal.RPC) bool {
+	if len(f.include) > 0 && !matchesAny(f.include, rpc) {
+		return false
+	}
+	if matchesAny(f.exclude, rpc) {
+		return false
+	}
+	// record the first RPC and then every sample'th one after that
+	return (atomic.AddUint64(&f.count, 1)-1)%f.sample == 0
+}
+
+func matchesAny(rules []filterRule, rpc *internal.RPC) bool {
+	for _, rule := range rules {
+		if rule.matches(rpc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r filterRule) matches(rpc *internal.RPC) bool {
+	var values []string
+	switch r.attribute {
+	case "service":
+		values = []string{rpc.Service}
+	case "method":
+		values = []string{rpc.Method}
+	case "authority":
+		values = rpc.Metadata.Get(":authority")
+	case "status":
+		if rpc.Status == nil {
+			values = []string{"OK"}
+		} else {
+			values = []string{rpc.Status.Code}
+		}
+	default:
+		values = rpc.Metadata.Get(strings.TrimPrefix(r.attribute, "metadata."))
+	}
+
+	for _, value := range values {
+		if r.pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// globPattern converts a glob, where * matches any sequence of characters
+// and ? matches any single character, into a regular expression
+func globPattern(glob string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.Replace(pattern, `\*`, ".*", -1)
+	pattern = strings.Replace(pattern, `\?`, ".", -1)
+	return regexp.MustCompile("^" + pattern + "$")
+}
@@ -0,0 +1,106 @@
+package dump
+
+import (
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"google.golang.org/grpc/metadata"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	rpc := &internal.RPC{
+		Service:  "grpc.health.v1.Health",
+		Method:   "Check",
+		Metadata: metadata.Pairs(":authority", "example.com:443", "user-agent", "grpc-go/1.23.0"),
+	}
+	failedRPC := &internal.RPC{
+		Service: "mypackage.Orders",
+		Method:  "GetOrder",
+		Status:  &internal.Status{Code: "NotFound"},
+	}
+
+	cases := map[string]struct {
+		include string
+		exclude string
+		rpc     *internal.RPC
+		record  bool
+	}{
+		"no include rules": {
+			"",
+			"service=mypackage.*",
+			rpc,
+			true,
+		},
+		"excluded service": {
+			"",
+			"service=grpc.health.v1.*",
+			rpc,
+			false,
+		},
+		"included authority": {
+			"authority=*.com:443",
+			"",
+			rpc,
+			true,
+		},
+		"not included": {
+			"method=Get*",
+			"",
+			rpc,
+			false,
+		},
+		"included metadata": {
+			"metadata.user-agent=grpc-go*",
+			"",
+			rpc,
+			true,
+		},
+		"status of successful RPC": {
+			"",
+			"status=OK",
+			rpc,
+			false,
+		},
+		"status of failed RPC": {
+			"status=NotFound",
+			"",
+			failedRPC,
+			true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f, err := NewFilter(tc.include, tc.exclude, 1)
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if record := f.shouldRecord(tc.rpc); record != tc.record {
+				t.Fatalf("mismatch, expected %v but got %v", tc.record, record)
+			}
+		})
+	}
+}
+
+func TestFilter_Sample(t *testing.T) {
+	f, err := NewFilter("", "", 3)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	recorded := 0
+	for i := 0; i < 9; i++ {
+		if f.shouldRecord(&internal.RPC{}) {
+			recorded++
+		}
+	}
+	if recorded != 3 {
+		t.Fatalf("expected 3 RPCs to be recorded but got %d", recorded)
+	}
+}
+
+func TestFilter_Invalid(t *testing.T) {
+	for _, rule := range []string{"service", "unknown=value", "metadata"} {
+		if _, err := NewFilter(rule, "", 1); err == nil {
+			t.Fatalf("expected error for filter %s", rule)
+		}
+	}
+}
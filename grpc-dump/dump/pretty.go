@@ -0,0 +1,170 @@
+package dump
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ANSI escape codes used to colourise the pretty output
+const (
+	colourReset   = "\x1b[0m"
+	colourBold    = "\x1b[1m"
+	colourDim     = "\x1b[2m"
+	colourRed     = "\x1b[31m"
+	colourGreen   = "\x1b[32m"
+	colourYellow  = "\x1b[33m"
+	colourBlue    = "\x1b[34m"
+	colourMagenta = "\x1b[35m"
+	colourCyan    = "\x1b[36m"
+)
+
+type prettyWriter struct {
+	sync.Mutex
+	output io.Writer
+	colour bool
+}
+
+// NewPrettyWriter writes RPCs as human readable blocks of text with messages in the protobuf text format.
+// If colour is true then the output is colourised using ANSI escape codes.
+func NewPrettyWriter(output io.Writer, colour bool) Writer {
+	return &prettyWriter{output: output, colour: colour}
+}
+
+func (w *prettyWriter) Write(rpc *internal.RPC) error {
+	// RPCs finish concurrently so make sure that their blocks aren't interleaved
+	w.Lock()
+	defer w.Unlock()
+	_, err := fmt.Fprintln(w.output, FormatPretty(rpc, w.colour))
+	return err
+}
+
+// Summary formats the method, status and duration of an RPC on a single line
+func Summary(rpc *internal.RPC, colour bool) string {
+	p := printer{colour: colour}
+	summary := p.paint(colourBold+colourCyan, rpc.StreamName()) + " " + p.status(rpc)
+	if rpc.Timing != nil {
+		summary += p.paint(colourDim, fmt.Sprintf(" %.1fms", rpc.Timing.DurationMs))
+	}
+	return summary
+}
+
+// FormatPretty formats an RPC as an indented block of text
+func FormatPretty(rpc *internal.RPC, colour bool) string {
+	p := printer{colour: colour}
+	b := &strings.Builder{}
+	fmt.Fprintln(b, Summary(rpc, colour))
+	if rpc.Timing != nil {
+		fmt.Fprintln(b, p.paint(colourDim, "  started "+rpc.Timing.Start.Format("2006-01-02 15:04:05.000")))
+	}
+	if c := rpc.Connection; c != nil && c.ClientAddress != "" {
+		connection := fmt.Sprintf("  %s -> %s (%s over %s", c.ClientAddress, c.UpstreamAddress, c.Protocol, c.HTTPVersion)
+		if c.TLSVersion != "" {
+			connection += ", " + c.TLSVersion
+		}
+		fmt.Fprintln(b, p.paint(colourDim, connection+")"))
+	}
+	p.metadata(b, "metadata", rpc.Metadata)
+	p.metadata(b, "response headers", rpc.ResponseHeaders)
+
+	for _, message := range rpc.Messages {
+		heading := p.paint(colourBlue, "  > client message")
+		if message.MessageOrigin == internal.ServerMessage {
+			heading = p.paint(colourMagenta, "  < server message")
+		}
+		if !message.Timestamp.IsZero() {
+			heading += p.paint(colourDim, " at "+message.Timestamp.Format("15:04:05.000"))
+		}
+		fmt.Fprintln(b, heading)
+		for _, line := range strings.Split(messageText(message), "\n") {
+			fmt.Fprintln(b, "    "+line)
+		}
+		var paths []string
+		for path := range message.UnknownFieldTypes {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Fprintln(b, p.paint(colourDim, fmt.Sprintf("    unknown field %s: %s", path, message.UnknownFieldTypes[path])))
+		}
+	}
+
+	p.metadata(b, "response trailers", rpc.ResponseTrailers)
+	if rpc.Status != nil {
+		fmt.Fprintln(b, p.paint(colourRed, fmt.Sprintf("  error: %s: %s", rpc.Status.Code, rpc.Status.Message)))
+	}
+	return b.String()
+}
+
+type printer struct {
+	colour bool
+}
+
+func (p printer) paint(colour, text string) string {
+	if !p.colour {
+		return text
+	}
+	return colour + text + colourReset
+}
+
+func (p printer) status(rpc *internal.RPC) string {
+	if rpc.Status == nil {
+		return p.paint(colourGreen, "OK")
+	}
+	return p.paint(colourRed, rpc.Status.Code)
+}
+
+func (p printer) metadata(b *strings.Builder, heading string, md metadata.MD) {
+	if len(md) == 0 {
+		return
+	}
+	fmt.Fprintln(b, p.paint(colourYellow, "  "+heading+":"))
+	var keys []string
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "    %s: %s\n", key, strings.Join(md[key], ", "))
+	}
+}
+
+// messageText formats a message in the protobuf text format if it was decoded,
+// as JSON if it was loaded from a dump, and as hex otherwise
+func messageText(message *internal.Message) string {
+	var text string
+	switch decoded := message.Message.(type) {
+	case *dynamic.Message:
+		if decoded != nil {
+			b, err := decoded.MarshalTextIndent()
+			if err == nil {
+				text = string(b)
+			}
+		}
+	case proto.Message:
+		text = proto.MarshalTextString(decoded)
+	case nil:
+	default:
+		b, err := json.MarshalIndent(decoded, "", "  ")
+		if err == nil {
+			text = string(b)
+		}
+	}
+	text = strings.TrimRight(text, "\n")
+	switch {
+	case text != "":
+		return text
+	case len(message.RawMessage) > 0:
+		return "raw: " + hex.EncodeToString(message.RawMessage)
+	default:
+		return "{}"
+	}
+}
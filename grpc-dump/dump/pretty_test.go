@@ -0,0 +1,44 @@
+package dump
+
+import (
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"google.golang.org/grpc/metadata"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatPretty(t *testing.T) {
+	start := time.Date(2019, 6, 24, 19, 19, 46, 0, time.UTC)
+	rpc := &internal.RPC{
+		Service: "mypackage.Service",
+		Method:  "Method",
+		Messages: []*internal.Message{
+			{MessageOrigin: internal.ClientMessage, RawMessage: []byte{1, 2}, Timestamp: start},
+			{MessageOrigin: internal.ServerMessage, Message: map[string]string{"key": "value"}, Timestamp: start.Add(10 * time.Millisecond), UnknownFieldTypes: map[string]string{"5": "int64 (70% confidence): integer values"}},
+		},
+		Status:   &internal.Status{Code: "NotFound", Message: "no such thing"},
+		Metadata: metadata.Pairs("user-agent", "grpc-go/1.23.0"),
+		Timing:   internal.NewTiming(start, start.Add(12500*time.Microsecond), 0, nil),
+	}
+
+	expected := `/mypackage.Service/Method NotFound 12.5ms
+  started 2019-06-24 19:19:46.000
+  metadata:
+    user-agent: grpc-go/1.23.0
+  > client message at 19:19:46.000
+    raw: 0102
+  < server message at 19:19:46.010
+    {
+      "key": "value"
+    }
+    unknown field 5: int64 (70% confidence): integer values
+  error: NotFound: no such thing
+`
+	if actual := FormatPretty(rpc, false); actual != expected {
+		t.Errorf("unexpected output:\n%s", actual)
+	}
+	if coloured := FormatPretty(rpc, true); !strings.Contains(coloured, colourRed+"NotFound"+colourReset) {
+		t.Errorf("expected status to be coloured:\n%q", coloured)
+	}
+}
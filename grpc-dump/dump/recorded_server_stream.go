@@ -1,8 +1,10 @@
 package dump
 
 import (
+	"context"
 	"github.com/bradleyjkemp/grpc-tools/internal"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"sync"
 	"time"
 )
@@ -11,7 +13,35 @@ import (
 type recordedServerStream struct {
 	sync.Mutex
 	grpc.ServerStream
-	events []*internal.Message
+	ctx      context.Context
+	events   []*internal.Message
+	headers  metadata.MD
+	trailers metadata.MD
+}
+
+func (ss *recordedServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *recordedServerStream) SetHeader(md metadata.MD) error {
+	ss.Lock()
+	ss.headers = metadata.Join(ss.headers, md)
+	ss.Unlock()
+	return ss.ServerStream.SetHeader(md)
+}
+
+func (ss *recordedServerStream) SendHeader(md metadata.MD) error {
+	ss.Lock()
+	ss.headers = metadata.Join(ss.headers, md)
+	ss.Unlock()
+	return ss.ServerStream.SendHeader(md)
+}
+
+func (ss *recordedServerStream) SetTrailer(md metadata.MD) {
+	ss.Lock()
+	ss.trailers = metadata.Join(ss.trailers, md)
+	ss.Unlock()
+	ss.ServerStream.SetTrailer(md)
 }
 
 func (ss *recordedServerStream) SendMsg(m interface{}) error {
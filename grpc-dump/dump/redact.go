@@ -0,0 +1,384 @@
+package dump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	redactedPlaceholder = "REDACTED"
+	anyMessageName      = "google.protobuf.Any"
+)
+
+// Redactor removes sensitive values from RPCs before they are written to the dump.
+// Matching metadata values, and string and bytes fields, are replaced with a placeholder
+// (or a hash of the original value so that equal values can still be correlated).
+// Any other type of matching field is cleared.
+type Redactor struct {
+	metadataKeys []*regexp.Regexp
+	fields       []string
+	option       string
+	hash         bool
+
+	// caches (by fully qualified field name) whether fields are marked as sensitive by the custom option
+	sensitiveFields sync.Map
+}
+
+// NewRedactor creates a Redactor from comma separated lists of rules:
+//
+//	metadataKeys: regular expressions matched (case-insensitively) against metadata keys e.g. "authorization,cookie"
+//	fields: fully qualified field names, optionally with * wildcards e.g. "mypackage.LoginRequest.password,*.token"
+//	option: the fully qualified name of a custom bool field option that marks fields as sensitive e.g. "mypackage.sensitive"
+//	mode: either "placeholder" (the default) or "hash"
+//
+// Returns nil if no rules are configured.
+func NewRedactor(metadataKeys, fields, option, mode string) (*Redactor, error) {
+	if metadataKeys == "" && fields == "" && option == "" {
+		return nil, nil
+	}
+
+	r := &Redactor{
+		option: strings.TrimPrefix(option, "."),
+	}
+	switch mode {
+	case "", "placeholder":
+	case "hash":
+		r.hash = true
+	default:
+		return nil, fmt.Errorf("unknown redaction mode %s", mode)
+	}
+
+	if metadataKeys != "" {
+		for _, key := range strings.Split(metadataKeys, ",") {
+			pattern, err := regexp.Compile("(?i)^(" + key + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata redaction pattern %s: %v", key, err)
+			}
+			r.metadataKeys = append(r.metadataKeys, pattern)
+		}
+	}
+	if fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			if _, err := path.Match(field, ""); err != nil {
+				return nil, fmt.Errorf("invalid field redaction pattern %s: %v", field, err)
+			}
+			r.fields = append(r.fields, strings.TrimPrefix(field, "."))
+		}
+	}
+	return r, nil
+}
+
+// redactRPC must be called after the RPC's messages have been decoded
+func (r *Redactor) redactRPC(rpc *internal.RPC) error {
+	rpc.Metadata = r.redactMetadata(rpc.Metadata)
+	rpc.ResponseHeaders = r.redactMetadata(rpc.ResponseHeaders)
+	rpc.ResponseTrailers = r.redactMetadata(rpc.ResponseTrailers)
+	return r.redactMessages(rpc.Messages)
+}
+
+// redactMessages must be called after the messages have been decoded
+func (r *Redactor) redactMessages(messages []*internal.Message) error {
+	if len(r.fields) == 0 && r.option == "" {
+		return nil
+	}
+	for _, message := range messages {
+		decoded, ok := message.Message.(*dynamic.Message)
+		if !ok || decoded == nil {
+			// can't tell which parts of the raw message are sensitive so have to drop it entirely
+			message.RawMessage = nil
+			continue
+		}
+		redacted, complete := r.redactMessage(decoded, decoded.GetMessageDescriptor().GetFile())
+		if !complete {
+			// an Any payload couldn't be redacted so the raw message may include sensitive values
+			message.RawMessage = nil
+			continue
+		}
+		if !redacted {
+			continue
+		}
+		// re-encode so that the raw message doesn't leak the redacted values
+		raw, err := proto.Marshal(decoded)
+		if err != nil {
+			message.RawMessage = nil
+			return fmt.Errorf("failed to re-encode redacted message: %v", err)
+		}
+		message.RawMessage = raw
+	}
+	return nil
+}
+
+func (r *Redactor) redactMetadata(md map[string][]string) map[string][]string {
+	if len(md) == 0 {
+		return md
+	}
+	// copy so that we don't modify the metadata of the RPC being proxied
+	redacted := make(map[string][]string, len(md))
+	for key, values := range md {
+		redacted[key] = values
+		for _, pattern := range r.metadataKeys {
+			if pattern.MatchString(key) {
+				redacted[key] = make([]string, len(values))
+				for i, value := range values {
+					redacted[key][i] = r.redactString(value)
+				}
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// redactMessage recursively redacts all sensitive fields and reports whether any were found.
+// The payloads of Any fields are redacted too: their types are looked up in file and the files it imports
+// (where the decoder adds them). complete is false if a payload's type couldn't be found so its value was cleared.
+func (r *Redactor) redactMessage(msg *dynamic.Message, file *desc.FileDescriptor) (redacted, complete bool) {
+	if msg.GetMessageDescriptor().GetFullyQualifiedName() == anyMessageName {
+		return r.redactAny(msg, file)
+	}
+
+	complete = true
+	redactNested := func(value interface{}) {
+		var nestedRedacted, nestedComplete bool
+		switch value := value.(type) {
+		case *dynamic.Message:
+			nestedRedacted, nestedComplete = r.redactMessage(value, file)
+		case proto.Message:
+			// well-known types (e.g. Any) are decoded as generated messages
+			nested, err := dynamic.AsDynamicMessage(value)
+			if err != nil {
+				return
+			}
+			nestedRedacted, nestedComplete = r.redactMessage(nested, file)
+			if nestedRedacted {
+				if err := nested.ConvertTo(value); err != nil {
+					value.Reset()
+					nestedComplete = false
+				}
+			}
+		default:
+			return
+		}
+		redacted = nestedRedacted || redacted
+		complete = nestedComplete && complete
+	}
+	for _, field := range msg.GetKnownFields() {
+		if !msg.HasField(field) {
+			continue
+		}
+		if r.isSensitive(field) {
+			r.redactField(msg, field)
+			redacted = true
+			continue
+		}
+		if field.GetMessageType() == nil {
+			continue
+		}
+
+		value := msg.GetField(field)
+		switch {
+		case field.IsMap():
+			for _, v := range value.(map[interface{}]interface{}) {
+				redactNested(v)
+			}
+		case field.IsRepeated():
+			for _, v := range value.([]interface{}) {
+				redactNested(v)
+			}
+		default:
+			redactNested(value)
+		}
+	}
+	return redacted, complete
+}
+
+// redactAny redacts the payload of an Any message and encodes it again
+func (r *Redactor) redactAny(any *dynamic.Message, file *desc.FileDescriptor) (redacted, complete bool) {
+	typeURL, _ := any.GetFieldByName("type_url").(string)
+	value, _ := any.GetFieldByName("value").([]byte)
+	if typeURL == "" {
+		return false, true
+	}
+	payload, err := newAnyPayload(file, typeURL[strings.LastIndex(typeURL, "/")+1:])
+	if err == nil {
+		err = proto.Unmarshal(value, payload)
+	}
+	if err != nil {
+		// can't tell which parts of the payload are sensitive so have to drop it entirely
+		any.ClearFieldByName("value")
+		return true, false
+	}
+
+	redacted, complete = r.redactMessage(payload, file)
+	if !redacted {
+		return false, complete
+	}
+	encoded, err := proto.Marshal(payload)
+	if err != nil {
+		any.ClearFieldByName("value")
+		return true, false
+	}
+	any.SetFieldByName("value", encoded)
+	return true, complete
+}
+
+// newAnyPayload creates an empty message of an Any payload's type
+func newAnyPayload(file *desc.FileDescriptor, name string) (*dynamic.Message, error) {
+	if proto.MessageType(name) != nil {
+		// compiled in types (e.g. the well-known types) aren't necessarily imported
+		payloadType, err := desc.LoadMessageDescriptor(name)
+		if err != nil {
+			return nil, err
+		}
+		return dynamic.NewMessage(payloadType), nil
+	}
+	payloadType := findMessage(file, name, map[*desc.FileDescriptor]bool{})
+	if payloadType == nil {
+		return nil, fmt.Errorf("unknown Any payload type %s", name)
+	}
+	return dynamic.NewMessage(payloadType), nil
+}
+
+func (r *Redactor) redactField(msg *dynamic.Message, field *desc.FieldDescriptor) {
+	redactValue := func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case string:
+			return r.redactString(v)
+		case []byte:
+			if r.hash {
+				sum := sha256.Sum256(v)
+				return sum[:]
+			}
+			return []byte(redactedPlaceholder)
+		}
+		return nil
+	}
+
+	valueType := field
+	if field.IsMap() {
+		valueType = field.GetMapValueType()
+	}
+	switch valueType.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES:
+	default:
+		msg.ClearField(field)
+		return
+	}
+
+	value := msg.GetField(field)
+	switch {
+	case field.IsMap():
+		redacted := map[interface{}]interface{}{}
+		for k, v := range value.(map[interface{}]interface{}) {
+			redacted[k] = redactValue(v)
+		}
+		msg.SetField(field, redacted)
+	case field.IsRepeated():
+		var redacted []interface{}
+		for _, v := range value.([]interface{}) {
+			redacted = append(redacted, redactValue(v))
+		}
+		msg.SetField(field, redacted)
+	default:
+		msg.SetField(field, redactValue(value))
+	}
+}
+
+func (r *Redactor) redactString(value string) string {
+	if r.hash {
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return redactedPlaceholder
+}
+
+func (r *Redactor) isSensitive(field *desc.FieldDescriptor) bool {
+	for _, pattern := range r.fields {
+		if match, _ := path.Match(pattern, field.GetFullyQualifiedName()); match {
+			return true
+		}
+	}
+	if r.option == "" {
+		return false
+	}
+	if sensitive, ok := r.sensitiveFields.Load(field.GetFullyQualifiedName()); ok {
+		return sensitive.(bool)
+	}
+	sensitive := hasBoolOption(field, r.option)
+	r.sensitiveFields.Store(field.GetFullyQualifiedName(), sensitive)
+	return sensitive
+}
+
+// hasBoolOption reports whether a field has the named custom bool option set to true.
+// Custom options aren't known to the golang/protobuf registry so they are
+// stored as unrecognised fields of the field's options.
+func hasBoolOption(field *desc.FieldDescriptor, option string) bool {
+	if field.GetFieldOptions() == nil || field.GetFile() == nil {
+		return false
+	}
+	extension := findExtension(field.GetFile(), option, map[*desc.FileDescriptor]bool{})
+	if extension == nil || extension.GetType() != descriptor.FieldDescriptorProto_TYPE_BOOL {
+		return false
+	}
+
+	optionsBytes, err := proto.Marshal(field.GetFieldOptions())
+	if err != nil {
+		return false
+	}
+	optionsDescriptor, err := desc.LoadMessageDescriptorForMessage(&descriptor.FieldOptions{})
+	if err != nil {
+		return false
+	}
+	options := dynamic.NewMessage(optionsDescriptor)
+	if err := proto.Unmarshal(optionsBytes, options); err != nil {
+		return false
+	}
+	for _, value := range options.GetUnknownField(extension.GetNumber()) {
+		if value.Value != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func findExtension(file *desc.FileDescriptor, name string, visited map[*desc.FileDescriptor]bool) *desc.FieldDescriptor {
+	if visited[file] {
+		return nil
+	}
+	visited[file] = true
+	if extension := file.FindExtensionByName(name); extension != nil {
+		return extension
+	}
+	for _, dependency := range file.GetDependencies() {
+		if extension := findExtension(dependency, name, visited); extension != nil {
+			return extension
+		}
+	}
+	return nil
+}
+
+func findMessage(file *desc.FileDescriptor, name string, visited map[*desc.FileDescriptor]bool) *desc.MessageDescriptor {
+	if visited[file] {
+		return nil
+	}
+	visited[file] = true
+	if message := file.FindMessage(name); message != nil {
+		return message
+	}
+	for _, dependency := range file.GetDependencies() {
+		if message := findMessage(dependency, name, visited); message != nil {
+			return message
+		}
+	}
+	return nil
+}
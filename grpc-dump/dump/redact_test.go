@@ -0,0 +1,152 @@
+package dump
+
+import (
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_descriptor"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRedactionProto = `syntax = "proto3";
+package redact;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions {
+    bool sensitive = 50000;
+}
+
+message Login {
+    string username = 1;
+    string password = 2 [(sensitive) = true];
+    Session session = 3;
+    google.protobuf.Any details = 4;
+}
+
+message Session {
+    string token = 1;
+    int64 expiry = 2;
+}
+
+service Auth {
+    rpc Login(Login) returns (Login) {};
+}
+`
+
+func TestRedactor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "redact.proto"), []byte(testRedactionProto), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := proto_decoder.NewFileResolver(proto_descriptor.LoadOptions{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const fullMethod = "/redact.Auth/Login"
+	method, _ := resolver.MethodDescriptor(fullMethod)
+
+	original := dynamic.NewMessage(method.GetInputType())
+	original.SetFieldByName("username", "alice")
+	original.SetFieldByName("password", "hunter2")
+	session := dynamic.NewMessage(method.GetInputType().FindFieldByName("session").GetMessageType())
+	session.SetFieldByName("token", "secret-token")
+	session.SetFieldByName("expiry", int64(1234))
+	original.SetFieldByName("session", session)
+	payload := dynamic.NewMessage(session.GetMessageDescriptor())
+	payload.SetFieldByName("token", "payload-token")
+	details, err := ptypes.MarshalAny(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original.SetFieldByName("details", details)
+	raw, err := proto.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redactor, err := NewRedactor("authorization", "*.Session.token,redact.Session.expiry", "redact.sensitive", "placeholder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &internal.Message{MessageOrigin: internal.ClientMessage, RawMessage: raw}
+	message.Message, err = proto_decoder.NewDecoder(logrus.New(), resolver).Decode(fullMethod, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpc := &internal.RPC{
+		Messages: []*internal.Message{message},
+		Metadata: metadata.Pairs("Authorization", "Bearer abc", "user-agent", "test"),
+	}
+	if err := redactor.redactRPC(rpc); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rpc.Metadata.Get("authorization"); len(got) != 1 || got[0] != redactedPlaceholder {
+		t.Fatalf("authorization not redacted: %v", got)
+	}
+	if got := rpc.Metadata.Get("user-agent"); len(got) != 1 || got[0] != "test" {
+		t.Fatalf("user-agent unexpectedly redacted: %v", got)
+	}
+
+	// both the raw and decoded messages must be redacted
+	for _, encoded := range [][]byte{message.RawMessage, mustMarshalJSON(t, message.Message.(*dynamic.Message))} {
+		if strings.Contains(string(encoded), "hunter2") || strings.Contains(string(encoded), "secret-token") || strings.Contains(string(encoded), "payload-token") {
+			t.Fatalf("message not redacted: %s", encoded)
+		}
+		if !strings.Contains(string(encoded), "alice") {
+			t.Fatalf("unexpected redaction: %s", encoded)
+		}
+	}
+	if strings.Contains(string(mustMarshalJSON(t, message.Message.(*dynamic.Message))), "1234") {
+		t.Fatal("expiry not cleared")
+	}
+}
+
+func mustMarshalJSON(t *testing.T, msg *dynamic.Message) []byte {
+	b, err := msg.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRedactor_UnknownAnyPayload(t *testing.T) {
+	anyType, err := desc.LoadMessageDescriptor("google.protobuf.Any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the decoder couldn't add the payload's type so it's unknown to the redactor
+	decoded := dynamic.NewMessage(anyType)
+	decoded.SetFieldByName("type_url", "type.googleapis.com/other.Secret")
+	decoded.SetFieldByName("value", []byte("\n\x06secret"))
+	message := &internal.Message{MessageOrigin: internal.ClientMessage, RawMessage: []byte("raw secret"), Message: decoded}
+
+	redactor, err := NewRedactor("", "*.password", "", "placeholder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := redactor.redactMessages([]*internal.Message{message}); err != nil {
+		t.Fatal(err)
+	}
+	if message.RawMessage != nil {
+		t.Errorf("expected raw message to be dropped, got %q", message.RawMessage)
+	}
+	if value := decoded.GetFieldByName("value").([]byte); len(value) != 0 {
+		t.Errorf("expected payload to be dropped, got %q", value)
+	}
+}
@@ -0,0 +1,102 @@
+package dump
+
+import (
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// older RPCs are forgotten so that memory usage doesn't grow forever
+const maxUnresolvedRPCs = 1000
+
+// redecoder remembers the RPCs for methods that weren't in the loaded protos
+// so that they can be decoded again (and updated in the output) once the protos are reloaded.
+type redecoder struct {
+	sync.Mutex
+	logger    logrus.FieldLogger
+	output    Updater
+	decoder   proto_decoder.MessageDecoder
+	resolvers []proto_decoder.MessageResolver
+	redactor  *Redactor
+
+	unresolved []*internal.RPC
+}
+
+func newRedecoder(logger logrus.FieldLogger, output Updater, decoder proto_decoder.MessageDecoder, resolvers []proto_decoder.MessageResolver, redactor *Redactor) *redecoder {
+	return &redecoder{
+		logger:    logger,
+		output:    output,
+		decoder:   decoder,
+		resolvers: resolvers,
+		redactor:  redactor,
+	}
+}
+
+func (r *redecoder) knowsMethod(fullMethod string) bool {
+	for _, resolver := range r.resolvers {
+		if source, ok := resolver.(proto_decoder.MethodDescriptorSource); ok {
+			if _, ok := source.MethodDescriptor(fullMethod); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// add remembers the RPC if its method is unknown
+func (r *redecoder) add(rpc *internal.RPC) {
+	if r.knowsMethod(rpc.StreamName()) {
+		return
+	}
+	for _, message := range rpc.Messages {
+		if message.RawMessage == nil {
+			// can't be decoded again (e.g. because the redactor removed it)
+			return
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.unresolved = append(r.unresolved, rpc)
+	if len(r.unresolved) > maxUnresolvedRPCs {
+		r.unresolved = r.unresolved[1:]
+	}
+}
+
+// redecode decodes the messages of the remembered RPCs whose methods are now known
+func (r *redecoder) redecode() {
+	r.Lock()
+	defer r.Unlock()
+	var stillUnresolved []*internal.RPC
+	for _, rpc := range r.unresolved {
+		if !r.knowsMethod(rpc.StreamName()) {
+			stillUnresolved = append(stillUnresolved, rpc)
+			continue
+		}
+
+		// the previous RPC may still be in use by the output so update a copy
+		updated := *rpc
+		updated.Messages = nil
+		for _, message := range rpc.Messages {
+			decoded := *message
+			dynamicMessage, err := r.decoder.Decode(rpc.StreamName(), message)
+			if err != nil {
+				r.logger.WithError(err).Warn("Failed to decode message")
+			}
+			decoded.Message = dynamicMessage
+			decoded.UnknownFieldTypes = proto_decoder.UnknownFieldTypes(dynamicMessage)
+			updated.Messages = append(updated.Messages, &decoded)
+		}
+		if r.redactor != nil {
+			// the metadata has already been redacted
+			if err := r.redactor.redactMessages(updated.Messages); err != nil {
+				r.logger.WithError(err).Warn("Failed to redact RPC")
+			}
+		}
+		if err := r.output.Update(rpc, &updated); err != nil {
+			r.logger.WithError(err).Warn("Failed to update RPC in dump")
+		}
+	}
+	r.unresolved = stillUnresolved
+}
@@ -0,0 +1,126 @@
+package dump
+
+import (
+	"encoding/json"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type updates chan *internal.RPC
+
+func (u updates) Update(previous, updated *internal.RPC) error {
+	u <- updated
+	return nil
+}
+
+func TestRedecodeAfterReloadingProtos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redecode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeProto := func(name, contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeProto("other.proto", `syntax = "proto3";
+package other;
+
+service Other {
+    rpc Call(Empty) returns (Empty);
+}
+
+message Empty {}
+`)
+	resolvers, err := proto_decoder.NewResolvers(proto_decoder.ProtoSources{Roots: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := proto_decoder.NewDecoder(logrus.New(), resolvers...)
+	output := make(updates, 1)
+	redecode := newRedecoder(logrus.New(), output, decoder, resolvers, nil)
+
+	message := &internal.Message{MessageOrigin: internal.ClientMessage, RawMessage: []byte{10, 5, 'a', 'l', 'i', 'c', 'e'}}
+	message.Message, err = decoder.Decode("/greet.Greeter/Hello", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpc := &internal.RPC{Service: "greet.Greeter", Method: "Hello", Messages: []*internal.Message{message}}
+	redecode.add(rpc)
+
+	proto_decoder.Watch(resolvers, 10*time.Millisecond, redecode.redecode)
+	writeProto("greet.proto", `syntax = "proto3";
+package greet;
+
+service Greeter {
+    rpc Hello(Request) returns (Request);
+}
+
+message Request {
+    string name = 1;
+}
+`)
+	var updated *internal.RPC
+	select {
+	case updated = <-output:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RPC wasn't decoded again")
+	}
+	actual, _ := json.Marshal(updated.Messages[0].Message)
+	if expected := `{"name":"alice"}`; string(actual) != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+	redecode.Lock()
+	defer redecode.Unlock()
+	if len(redecode.unresolved) != 0 {
+		t.Errorf("expected no more unresolved RPCs, got %d", len(redecode.unresolved))
+	}
+}
+
+// updatingWriter is a Writer which can show updated RPCs (like the terminal UI)
+type updatingWriter struct {
+	updates
+}
+
+func (updatingWriter) Write(*internal.RPC) error {
+	return nil
+}
+
+func TestUpdaterFor(t *testing.T) {
+	file := NewJSONWriter(ioutil.Discard)
+	ui := updatingWriter{make(updates, 1)}
+	tests := []struct {
+		name   string
+		output Writer
+		ok     bool
+	}{
+		{"file", file, false},
+		{"file and exporter", NewMultiWriter(file, NewJSONWriter(ioutil.Discard)), false},
+		{"terminal UI", ui, true},
+		{"file and terminal UI", NewMultiWriter(file, ui), true},
+		{"nested terminal UI", NewMultiWriter(NewMultiWriter(file, ui), file), true},
+	}
+	for _, test := range tests {
+		updater, ok := updaterFor(test.output)
+		if ok != test.ok {
+			t.Errorf("%s: expected %v, got %v", test.name, test.ok, ok)
+		}
+		if !ok {
+			continue
+		}
+		rpc := &internal.RPC{Method: test.name}
+		if err := updater.Update(nil, rpc); err != nil {
+			t.Fatal(err)
+		}
+		if updated := <-ui.updates; updated != rpc {
+			t.Errorf("%s: expected update to reach the terminal UI", test.name)
+		}
+	}
+}
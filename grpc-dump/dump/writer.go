@@ -0,0 +1,83 @@
+package dump
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"io"
+)
+
+// Writer writes recorded RPCs to the dump output
+type Writer interface {
+	Write(rpc *internal.RPC) error
+}
+
+// Updater is implemented by writers that can replace RPCs they've already written (e.g. the terminal UI)
+type Updater interface {
+	Update(previous, updated *internal.RPC) error
+}
+
+type jsonWriter struct {
+	output io.Writer
+}
+
+// NewJSONWriter writes RPCs as a newline separated stream of JSON objects
+func NewJSONWriter(output io.Writer) Writer {
+	return jsonWriter{output}
+}
+
+func (w jsonWriter) Write(rpc *internal.RPC) error {
+	dump, err := json.Marshal(rpc)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w.output, string(dump))
+	return err
+}
+
+type multiWriter []Writer
+
+// NewMultiWriter writes RPCs to all of the given writers
+func NewMultiWriter(writers ...Writer) Writer {
+	return multiWriter(writers)
+}
+
+func (m multiWriter) Write(rpc *internal.RPC) error {
+	var firstErr error
+	for _, w := range m {
+		if err := w.Write(rpc); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Update updates the RPC in all of the writers that are Updaters
+func (m multiWriter) Update(previous, updated *internal.RPC) error {
+	var firstErr error
+	for _, w := range m {
+		if u, ok := w.(Updater); ok {
+			if err := u.Update(previous, updated); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// updaterFor returns an Updater for the writers in output that can replace RPCs.
+// It returns false if there are none (e.g. a multi writer of file and OTLP outputs).
+func updaterFor(output Writer) (Updater, bool) {
+	writers, ok := output.(multiWriter)
+	if !ok {
+		updater, ok := output.(Updater)
+		return updater, ok
+	}
+	var updaters multiWriter
+	for _, w := range writers {
+		if _, ok := updaterFor(w); ok {
+			updaters = append(updaters, w)
+		}
+	}
+	return updaters, len(updaters) > 0
+}
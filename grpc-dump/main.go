@@ -4,23 +4,192 @@ import (
 	"flag"
 	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/grpc-dump/dump"
+	"github.com/bradleyjkemp/grpc-tools/grpc-dump/tui"
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpformat"
+	"github.com/bradleyjkemp/grpc-tools/internal/har"
+	"github.com/bradleyjkemp/grpc-tools/internal/otlp"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/bradleyjkemp/grpc-tools/internal/rotatefile"
 	_ "github.com/bradleyjkemp/grpc-tools/internal/versionflag"
+	"io"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 	var (
 		protoRoots       = flag.String("proto_roots", "", "A comma separated list of directories to search for gRPC service definitions.")
-		protoDescriptors = flag.String("proto_descriptors", "", "A comma separated list of proto descriptors to load gRPC service definitions from.")
+		protoDescriptors = flag.String("proto_descriptors", "", "A comma separated list of descriptor set files (e.g. written by protoc --descriptor_set_out --include_imports) to load gRPC service definitions from.")
+		protoImportPaths = flag.String("proto_import_paths", "", "A comma separated list of directories to search for files imported by the .proto files in --proto_roots.")
+		protoStrict      = flag.Bool("proto_strict", false, "Fail if any .proto file in --proto_roots can't be parsed. By default such files are skipped.")
+		protoWatch       = flag.Bool("proto_watch", false, "Reload --proto_roots and --proto_descriptors whenever they change.")
+		include          = flag.String("include", "", "A comma separated list of filters (e.g. service=mypackage.*,metadata.user-agent=grpc-go*). If set, only RPCs matching at least one filter are recorded. Filters can match service, method, authority, status or metadata.<key>.")
+		exclude          = flag.String("exclude", "", "A comma separated list of filters (e.g. service=grpc.health.v1.Health,status=OK). RPCs matching any filter are not recorded.")
+		sample           = flag.Int("sample", 1, "Only record 1 in every N RPCs (after applying the include/exclude filters).")
+		format           = flag.String("format", "json", "Format to write the dump in. Values are {json, text, binary, pretty, har}. The har format requires --output to be set and can't be rotated or compressed.")
+		showTUI          = flag.Bool("tui", false, "Browse captured RPCs in an interactive terminal UI. The dump is only written if --output is set.")
+		outputPath       = flag.String("output", "", "File to write the dump to. By default the dump is written to stdout.")
+		rotateSize       = flag.Int64("rotate_size", 0, "Rotate the output file once it reaches this many megabytes.")
+		rotateInterval   = flag.Duration("rotate_interval", 0, "Rotate the output file at this interval (e.g. 1h). The output file is also rotated when grpc-dump receives SIGHUP.")
+		compress         = flag.String("compress", "none", "Compression to use for rotated output files. Values are {none, gzip}")
+		maxBackups       = flag.Int("max_backups", 0, "Maximum number of rotated output files to keep. By default all are kept.")
+		maxAge           = flag.Duration("max_age", 0, "Maximum age of rotated output files to keep (e.g. 72h). By default all are kept.")
+		redactMetadata   = flag.String("redact_metadata", "", "A comma separated list of regular expressions matching metadata keys (e.g. authorization,cookie) whose values should be redacted.")
+		redactFields     = flag.String("redact_fields", "", "A comma separated list of fully qualified message fields (e.g. mypackage.LoginRequest.password,*.token) whose values should be redacted.")
+		redactOption     = flag.String("redact_option", "", "The fully qualified name of a custom bool field option (e.g. mypackage.sensitive) marking fields whose values should be redacted.")
+		redactMode       = flag.String("redact_mode", "placeholder", "How to redact values. Values are {placeholder, hash}")
+		otlpEndpoint     = flag.String("otlp_endpoint", "", "An OTLP/HTTP collector endpoint (e.g. http://localhost:4318) to export RPCs to as OpenTelemetry spans.")
 	)
 
 	grpc_proxy.RegisterDefaultFlags()
 	flag.Parse()
-	err := dump.Run(os.Stdout, *protoRoots, *protoDescriptors, grpc_proxy.DefaultFlags())
+	protoSources := proto_decoder.ProtoSources{Roots: *protoRoots, ImportPaths: *protoImportPaths, Descriptors: *protoDescriptors, Strict: *protoStrict, Watch: *protoWatch}
+	filter, err := dump.NewFilter(*include, *exclude, *sample)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		flag.Usage()
 		os.Exit(1)
 	}
+	redactor, err := dump.NewRedactor(*redactMetadata, *redactFields, *redactOption, *redactMode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	var output dump.Writer = dump.NewMultiWriter()
+	if !*showTUI || *outputPath != "" {
+		output, err = newWriter(*format, *outputPath, rotatefile.Options{
+			MaxSize:     *rotateSize * 1024 * 1024,
+			Interval:    *rotateInterval,
+			Compression: *compress,
+			MaxBackups:  *maxBackups,
+			MaxAge:      *maxAge,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	var exporter *otlp.Exporter
+	if *otlpEndpoint != "" {
+		exporter, err = otlp.NewExporter(*otlpEndpoint)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		output = dump.NewMultiWriter(output, exporter)
+		closeOnSignal(exporter)
+	}
+	if *showTUI {
+		err = runTUI(output, func(output dump.Writer, logOutput io.Writer) error {
+			return dump.Run(output, logOutput, protoSources, filter, redactor, grpc_proxy.DefaultFlags())
+		})
+	} else {
+		err = dump.Run(output, os.Stderr, protoSources, filter, redactor, grpc_proxy.DefaultFlags())
+	}
+	if exporter != nil {
+		closeExporter(exporter)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+// closeOnSignal sends the spans that are still queued when grpc-dump is stopped by SIGINT or SIGTERM
+func closeOnSignal(exporter *otlp.Exporter) {
+	sigs := make(chan os.Signal, 1)
+	for _, sig := range []os.Signal{syscall.SIGINT, syscall.SIGTERM} {
+		// ignored signals (e.g. SIGINT for background jobs) must not stop grpc-dump
+		if !signal.Ignored(sig) {
+			signal.Notify(sigs, sig)
+		}
+	}
+	go func() {
+		sig := <-sigs
+		closeExporter(exporter)
+		// stop handling the signal and send it again so grpc-dump stops as it otherwise would have
+		// (with --system_proxy the proxy handles the signal too and restores the settings before exiting)
+		signal.Stop(sigs)
+		process, err := os.FindProcess(os.Getpid())
+		if err != nil || process.Signal(sig) != nil {
+			os.Exit(1)
+		}
+	}()
+}
+
+func closeExporter(exporter *otlp.Exporter) {
+	if err := exporter.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to export spans:", err)
+	}
+}
+
+func newWriter(format, outputPath string, rotateOptions rotatefile.Options) (dump.Writer, error) {
+	if format == "har" {
+		// HAR files are a single JSON document so can't be written to stdout or rotated
+		if outputPath == "" {
+			return nil, fmt.Errorf("--output must be set when using the har format")
+		}
+		if rotateOptions.Compression == "none" {
+			rotateOptions.Compression = ""
+		}
+		if rotateOptions != (rotatefile.Options{}) {
+			return nil, fmt.Errorf("--rotate_size, --rotate_interval, --compress, --max_backups and --max_age can't be used with the har format")
+		}
+		return har.NewFileWriter(outputPath)
+	}
+
+	var output io.Writer = os.Stdout
+	if outputPath != "" {
+		file, err := rotatefile.New(outputPath, rotateOptions)
+		if err != nil {
+			return nil, err
+		}
+		file.RotateOnSignal()
+		output = file
+	}
+
+	switch format {
+	case "json":
+		return dump.NewJSONWriter(output), nil
+	case "text":
+		return dumpformat.NewTextWriter(output), nil
+	case "binary":
+		return dumpformat.NewBinaryWriter(output), nil
+	case "pretty":
+		// only colourise output for humans
+		colour := outputPath == "" && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+		return dump.NewPrettyWriter(output, colour), nil
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runTUI runs grpc-dump while showing the captured RPCs in the terminal UI
+func runTUI(output dump.Writer, run func(output dump.Writer, logOutput io.Writer) error) error {
+	ui, err := tui.New(os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	// logs would otherwise be drawn over the UI so show them in its status bar instead
+	logOutput := ui.LogWriter()
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	go func() {
+		ui.Stop(run(dump.NewMultiWriter(output, ui), logOutput))
+	}()
+	return ui.Run()
 }
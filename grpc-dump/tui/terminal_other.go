@@ -0,0 +1,25 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("the terminal UI is not supported on this platform")
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errUnsupported
+}
+
+func terminalSize(f *os.File) (width, height int, err error) {
+	return 0, 0, errUnsupported
+}
+
+func notifyResize(resized chan<- os.Signal) {}
@@ -0,0 +1,61 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package tui
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(fd, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	return ioctl(f.Fd(), ioctlGetTermios, unsafe.Pointer(&termios)) == nil
+}
+
+// makeRaw puts the terminal into raw mode so that key presses are read immediately
+// without being echoed, and returns a function to restore the previous mode
+func makeRaw(f *os.File) (func(), error) {
+	var original syscall.Termios
+	if err := ioctl(f.Fd(), ioctlGetTermios, unsafe.Pointer(&original)); err != nil {
+		return nil, err
+	}
+	raw := original
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f.Fd(), ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return func() {
+		ioctl(f.Fd(), ioctlSetTermios, unsafe.Pointer(&original))
+	}, nil
+}
+
+func terminalSize(f *os.File) (width, height int, err error) {
+	var size struct {
+		rows, cols, x, y uint16
+	}
+	if err := ioctl(f.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return int(size.cols), int(size.rows), nil
+}
+
+// notifyResize sends to resized whenever the terminal window changes size
+func notifyResize(resized chan<- os.Signal) {
+	signal.Notify(resized, syscall.SIGWINCH)
+}
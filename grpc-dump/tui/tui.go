@@ -0,0 +1,411 @@
+// Package tui implements a full-screen terminal UI for browsing RPCs as they are captured.
+package tui
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/grpc-dump/dump"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	exitAltScreen  = "\x1b[?25h\x1b[?1049l"
+	moveHome       = "\x1b[H"
+	clearLine      = "\x1b[K"
+	clearBelow     = "\x1b[J"
+	reverseVideo   = "\x1b[7m"
+	resetStyle     = "\x1b[0m"
+
+	helpText = "↑/↓ select  enter expand  / search  f follow  g/G top/bottom  q quit"
+
+	// older RPCs are discarded so that memory usage doesn't grow forever
+	maxRPCs = 10000
+)
+
+// UI shows captured RPCs as a scrollable list that can be searched and expanded.
+// It implements dump.Writer so that RPCs appear as soon as they are captured.
+type UI struct {
+	sync.Mutex
+	in, out *os.File
+
+	rpcs     []*internal.RPC
+	expanded map[*internal.RPC]bool
+	// lower case pretty text of each RPC so that searching doesn't format every RPC on every redraw
+	searchText map[*internal.RPC]string
+	// index into the visible (i.e. matching the search) RPCs
+	selected int
+	// first screen line shown
+	offset int
+	// keep the newest RPC selected as new ones arrive
+	follow bool
+
+	search    string
+	searching bool
+	status    string
+
+	redraw chan struct{}
+	stop   chan error
+}
+
+// New creates a UI reading key presses from in and drawing to out, both of which must be terminals
+func New(in, out *os.File) (*UI, error) {
+	if !isTerminal(in) || !isTerminal(out) {
+		return nil, errors.New("the terminal UI requires stdin and stdout to be a terminal")
+	}
+	return &UI{
+		in:         in,
+		out:        out,
+		expanded:   map[*internal.RPC]bool{},
+		searchText: map[*internal.RPC]string{},
+		follow:     true,
+		redraw:     make(chan struct{}, 1),
+		stop:       make(chan error, 1),
+	}, nil
+}
+
+func (u *UI) Write(rpc *internal.RPC) error {
+	u.Lock()
+	u.rpcs = append(u.rpcs, rpc)
+	if len(u.rpcs) > maxRPCs {
+		delete(u.expanded, u.rpcs[0])
+		delete(u.searchText, u.rpcs[0])
+		u.rpcs = u.rpcs[1:]
+	}
+	u.Unlock()
+	u.requestRedraw()
+	return nil
+}
+
+// Update replaces an RPC that was previously written (e.g. because its messages have been decoded again)
+func (u *UI) Update(previous, updated *internal.RPC) error {
+	u.Lock()
+	for i, rpc := range u.rpcs {
+		if rpc == previous {
+			u.rpcs[i] = updated
+			delete(u.searchText, previous)
+			if u.expanded[previous] {
+				delete(u.expanded, previous)
+				u.expanded[updated] = true
+			}
+			break
+		}
+	}
+	u.Unlock()
+	u.requestRedraw()
+	return nil
+}
+
+// LogWriter returns a writer that shows the most recent log line in the status bar
+func (u *UI) LogWriter() io.Writer {
+	return &logWriter{ui: u}
+}
+
+// Stop closes the UI, Run returns err
+func (u *UI) Stop(err error) {
+	select {
+	case u.stop <- err:
+	default:
+	}
+}
+
+// Run takes over the terminal until the user quits or Stop is called
+func (u *UI) Run() error {
+	restore, err := makeRaw(u.in)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(u.out, enterAltScreen)
+	defer func() {
+		fmt.Fprint(u.out, exitAltScreen)
+		restore()
+	}()
+
+	keys := make(chan []byte)
+	go u.readKeys(keys)
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+
+	u.draw()
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok || u.handleKey(key) {
+				return nil
+			}
+		case <-resized:
+		case <-u.redraw:
+		case err := <-u.stop:
+			return err
+		}
+		u.draw()
+	}
+}
+
+func (u *UI) requestRedraw() {
+	select {
+	case u.redraw <- struct{}{}:
+	default:
+	}
+}
+
+func (u *UI) readKeys(keys chan<- []byte) {
+	buffer := make([]byte, 64)
+	for {
+		n, err := u.in.Read(buffer)
+		if err != nil {
+			close(keys)
+			return
+		}
+		keys <- append([]byte{}, buffer[:n]...)
+	}
+}
+
+// handleKey updates the UI for a key press and reports whether the user has quit
+func (u *UI) handleKey(key []byte) bool {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.searching {
+		switch {
+		case bytes.Equal(key, []byte{'\r'}):
+			u.searching = false
+		case bytes.Equal(key, []byte{0x1b}):
+			u.searching, u.search = false, ""
+		case bytes.Equal(key, []byte{0x7f}) || bytes.Equal(key, []byte{0x08}):
+			if len(u.search) > 0 {
+				_, size := utf8.DecodeLastRuneInString(u.search)
+				u.search = u.search[:len(u.search)-size]
+			}
+		case key[0] >= ' ' && key[0] != 0x7f:
+			u.search += string(key)
+		}
+		u.selected, u.offset = 0, 0
+		return false
+	}
+
+	visible := u.visible()
+	switch string(key) {
+	case "q", "\x03":
+		return true
+	case "\x1b[A", "k":
+		u.selected--
+		u.follow = false
+	case "\x1b[B", "j":
+		u.selected++
+	case "\x1b[5~":
+		u.selected -= u.pageSize()
+		u.follow = false
+	case "\x1b[6~", " ":
+		u.selected += u.pageSize()
+	case "\x1b[H", "g":
+		u.selected = 0
+		u.follow = false
+	case "\x1b[F", "G":
+		u.selected = len(visible) - 1
+		u.follow = true
+	case "\r":
+		if u.selected < len(visible) {
+			rpc := visible[u.selected]
+			u.expanded[rpc] = !u.expanded[rpc]
+		}
+	case "/":
+		u.searching, u.search = true, ""
+	case "\x1b":
+		u.search = ""
+	case "f":
+		u.follow = !u.follow
+	}
+	if u.selected >= len(visible) {
+		u.selected = len(visible) - 1
+	}
+	if u.selected < 0 {
+		u.selected = 0
+	}
+	return false
+}
+
+// visible returns the RPCs that match the current search
+func (u *UI) visible() []*internal.RPC {
+	if u.search == "" {
+		return u.rpcs
+	}
+	search := strings.ToLower(u.search)
+	var matching []*internal.RPC
+	for _, rpc := range u.rpcs {
+		text, ok := u.searchText[rpc]
+		if !ok {
+			text = strings.ToLower(dump.FormatPretty(rpc, false))
+			u.searchText[rpc] = text
+		}
+		if strings.Contains(text, search) {
+			matching = append(matching, rpc)
+		}
+	}
+	return matching
+}
+
+func (u *UI) pageSize() int {
+	_, height, err := terminalSize(u.out)
+	if err != nil || height < 4 {
+		return 10
+	}
+	return height - 2
+}
+
+func (u *UI) draw() {
+	u.Lock()
+	defer u.Unlock()
+	width, height, err := terminalSize(u.out)
+	if err != nil || width <= 0 || height <= 2 {
+		return
+	}
+	fmt.Fprint(u.out, u.render(width, height))
+}
+
+// render lays out the screen for a terminal of the given size.
+// Must be called with the lock held.
+func (u *UI) render(width, height int) string {
+	visible := u.visible()
+	if u.follow && len(visible) > 0 {
+		u.selected = len(visible) - 1
+	}
+	// lay out the RPCs (and any expanded details) as screen lines
+	var lines []string
+	selectedLine := 0
+	for i, rpc := range visible {
+		line := " " + timestamp(rpc) + " " + dump.Summary(rpc, true)
+		if i == u.selected {
+			selectedLine = len(lines)
+			line = reverseVideo + truncate(stripColour(line), width) + resetStyle
+		}
+		lines = append(lines, line)
+		if u.expanded[rpc] {
+			details := strings.Split(strings.TrimRight(dump.FormatPretty(rpc, true), "\n"), "\n")
+			lines = append(lines, details[1:]...)
+		}
+	}
+
+	// scroll so that the selected RPC is on screen
+	listHeight := height - 2
+	if selectedLine < u.offset {
+		u.offset = selectedLine
+	}
+	if selectedLine >= u.offset+listHeight {
+		u.offset = selectedLine - listHeight + 1
+	}
+	if u.offset > len(lines)-1 {
+		u.offset = 0
+	}
+
+	screen := &strings.Builder{}
+	screen.WriteString(moveHome)
+	header := fmt.Sprintf(" grpc-dump: %d RPCs", len(u.rpcs))
+	if u.search != "" || u.searching {
+		header += fmt.Sprintf(", %d matching %q", len(visible), u.search)
+	}
+	if u.follow {
+		header += " (following)"
+	}
+	header += "  |  " + helpText
+	screen.WriteString(reverseVideo + padRight(truncate(header, width), width) + resetStyle + "\r\n")
+	for i := 0; i < listHeight; i++ {
+		if i+u.offset < len(lines) {
+			screen.WriteString(truncate(lines[i+u.offset], width))
+		}
+		screen.WriteString(clearLine + "\r\n")
+	}
+
+	footer := u.status
+	if u.searching {
+		footer = "search: " + u.search + "_"
+	}
+	screen.WriteString(reverseVideo + padRight(truncate(footer, width), width) + resetStyle + clearBelow)
+	return screen.String()
+}
+
+func timestamp(rpc *internal.RPC) string {
+	switch {
+	case rpc.Timing != nil:
+		return rpc.Timing.Start.Format("15:04:05.000")
+	case len(rpc.Messages) > 0 && !rpc.Messages[0].Timestamp.IsZero():
+		return rpc.Messages[0].Timestamp.Format("15:04:05.000")
+	}
+	return "            "
+}
+
+// truncate shortens a line to fit the screen width, ignoring the width of ANSI escape codes
+func truncate(line string, width int) string {
+	visible := 0
+	inEscape := false
+	for i, r := range line {
+		switch {
+		case r == '\x1b':
+			inEscape = true
+		case inEscape:
+			inEscape = r < '@' || r > '~' || r == '['
+		case r == '\t':
+			visible += 4
+		default:
+			visible++
+		}
+		if visible > width {
+			return line[:i] + resetStyle
+		}
+	}
+	return line
+}
+
+func stripColour(line string) string {
+	b := &strings.Builder{}
+	inEscape := false
+	for _, r := range line {
+		switch {
+		case r == '\x1b':
+			inEscape = true
+		case inEscape:
+			inEscape = r < '@' || r > '~' || r == '['
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func padRight(line string, width int) string {
+	if n := utf8.RuneCountInString(stripColour(line)); n < width {
+		return line + strings.Repeat(" ", width-n)
+	}
+	return line
+}
+
+// logWriter can be shared by several loggers so each Write is locked
+type logWriter struct {
+	sync.Mutex
+	ui      *UI
+	partial []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	w.partial = append(w.partial, p...)
+	lines := bytes.Split(w.partial, []byte("\n"))
+	w.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		w.ui.Lock()
+		w.ui.status = string(line)
+		w.ui.Unlock()
+	}
+	w.ui.requestRedraw()
+	return len(p), nil
+}
@@ -0,0 +1,225 @@
+package tui
+
+import (
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"strings"
+	"testing"
+)
+
+func newTestUI(count int) *UI {
+	u := &UI{
+		expanded:   map[*internal.RPC]bool{},
+		searchText: map[*internal.RPC]string{},
+		follow:     true,
+		redraw:     make(chan struct{}, 1),
+		stop:       make(chan error, 1),
+	}
+	for i := 0; i < count; i++ {
+		u.Write(&internal.RPC{
+			Service:  "test.Service",
+			Method:   fmt.Sprintf("Method%d", i),
+			Messages: []*internal.Message{{MessageOrigin: internal.ClientMessage, RawMessage: []byte{1}}},
+		})
+	}
+	return u
+}
+
+// screenLines returns the list lines of the rendered screen (i.e. without the header and footer)
+func screenLines(u *UI, width, height int) []string {
+	lines := strings.Split(stripColour(u.render(width, height)), "\r\n")
+	return lines[1 : len(lines)-1]
+}
+
+func TestHandleKey(t *testing.T) {
+	u := newTestUI(5)
+	u.render(80, 10)
+	keys := []struct {
+		key      string
+		selected int
+		follow   bool
+	}{
+		{"k", 3, false},
+		{"\x1b[A", 2, false},
+		{"j", 3, false},
+		{"\x1b[B", 4, false},
+		{"j", 4, false},
+		{"g", 0, false},
+		{"k", 0, false},
+		{"G", 4, true},
+		{"f", 4, false},
+		{"\x1b[H", 0, false},
+		{" ", 4, false},
+		{"\x1b[5~", 0, false},
+	}
+	for _, key := range keys {
+		if u.handleKey([]byte(key.key)) {
+			t.Fatalf("%q quit the UI", key.key)
+		}
+		if u.selected != key.selected || u.follow != key.follow {
+			t.Errorf("after %q expected selected %d (follow %v), got %d (follow %v)", key.key, key.selected, key.follow, u.selected, u.follow)
+		}
+	}
+
+	u.handleKey([]byte("\r"))
+	if !u.expanded[u.rpcs[0]] {
+		t.Error("expected selected RPC to be expanded")
+	}
+	u.handleKey([]byte("\r"))
+	if u.expanded[u.rpcs[0]] {
+		t.Error("expected selected RPC to be collapsed")
+	}
+
+	for _, key := range []string{"/", "M", "e", "t", "h", "o", "d", "3", "9", "\x7f", "\r"} {
+		u.handleKey([]byte(key))
+	}
+	if u.searching || u.search != "Method3" {
+		t.Errorf("unexpected search %q (searching %v)", u.search, u.searching)
+	}
+	if visible := u.visible(); len(visible) != 1 || visible[0] != u.rpcs[3] {
+		t.Errorf("unexpected search results %v", visible)
+	}
+	u.handleKey([]byte("\x1b"))
+	if u.search != "" || len(u.visible()) != 5 {
+		t.Errorf("expected escape to clear the search, got %q", u.search)
+	}
+	for _, key := range []string{"/", "x", "\x1b"} {
+		u.handleKey([]byte(key))
+	}
+	if u.searching || u.search != "" {
+		t.Errorf("expected escape to cancel searching, got %q (searching %v)", u.search, u.searching)
+	}
+
+	for _, key := range []string{"q", "\x03"} {
+		if !u.handleKey([]byte(key)) {
+			t.Errorf("expected %q to quit", key)
+		}
+	}
+}
+
+func TestSearchTextCached(t *testing.T) {
+	u := newTestUI(3)
+	u.search = "method"
+	if len(u.visible()) != 3 || len(u.searchText) != 3 {
+		t.Fatalf("expected all RPCs to match and be cached, got %d", len(u.searchText))
+	}
+
+	// the cached text is used rather than formatting the RPC again
+	u.searchText[u.rpcs[0]] = "cached"
+	if len(u.visible()) != 2 {
+		t.Error("expected cached search text to be used")
+	}
+
+	updated := *u.rpcs[0]
+	u.Update(u.rpcs[0], &updated)
+	if len(u.visible()) != 3 {
+		t.Error("expected search text to be formatted again for updated RPC")
+	}
+	if _, ok := u.searchText[u.rpcs[0]]; !ok || len(u.searchText) != 3 {
+		t.Errorf("expected only the current RPCs to be cached, got %d", len(u.searchText))
+	}
+}
+
+func TestRenderScrolling(t *testing.T) {
+	u := newTestUI(20)
+	// 5 lines of RPCs between the header and footer
+	width, height := 80, 7
+
+	// following keeps the newest RPC at the bottom of the screen
+	lines := screenLines(u, width, height)
+	if u.selected != 19 || u.offset != 15 {
+		t.Fatalf("expected newest RPC to be selected, got selected %d offset %d", u.selected, u.offset)
+	}
+	if !strings.Contains(lines[4], "Method19") || !strings.Contains(lines[0], "Method15") {
+		t.Errorf("unexpected screen %q", lines)
+	}
+
+	u.handleKey([]byte("g"))
+	screenLines(u, width, height)
+	if u.offset != 0 {
+		t.Errorf("expected scroll to top, got offset %d", u.offset)
+	}
+	for i := 0; i < 6; i++ {
+		u.handleKey([]byte("j"))
+	}
+	lines = screenLines(u, width, height)
+	if u.offset != 2 || !strings.Contains(lines[4], "Method6") {
+		t.Errorf("expected selected RPC at the bottom of the screen, got offset %d %q", u.offset, lines)
+	}
+
+	// scrolling up only moves the screen once the selection reaches the top
+	for i := 0; i < 4; i++ {
+		u.handleKey([]byte("k"))
+	}
+	screenLines(u, width, height)
+	if u.offset != 2 {
+		t.Errorf("expected offset to stay at 2, got %d", u.offset)
+	}
+	u.handleKey([]byte("k"))
+	lines = screenLines(u, width, height)
+	if u.offset != 1 || !strings.Contains(lines[0], "Method1") {
+		t.Errorf("expected screen to scroll up, got offset %d %q", u.offset, lines)
+	}
+
+	// expanded RPCs show their details below the summary
+	u.handleKey([]byte("\r"))
+	lines = screenLines(u, width, height)
+	if !strings.Contains(lines[0], "Method1") || !strings.Contains(lines[1], "client message") {
+		t.Errorf("expected expanded details, got %q", lines)
+	}
+	if strings.Contains(strings.Join(lines, "\n"), "Method5") {
+		t.Errorf("expected details to push later RPCs off screen, got %q", lines)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		line     string
+		width    int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"much too long", 8, "much too" + resetStyle},
+		{"\x1b[1mbold\x1b[0m text", 6, "\x1b[1mbold\x1b[0m t" + resetStyle},
+		{"\ttab", 5, "\tt" + resetStyle},
+		{"ünïcode", 3, "ünï" + resetStyle},
+	}
+	for _, test := range tests {
+		if actual := truncate(test.line, test.width); actual != test.expected {
+			t.Errorf("truncate(%q, %d) = %q, expected %q", test.line, test.width, actual, test.expected)
+		}
+	}
+}
+
+func TestStripColour(t *testing.T) {
+	tests := map[string]string{
+		"plain":                          "plain",
+		"\x1b[1;36mbold cyan\x1b[0m":     "bold cyan",
+		"a\x1b[7mb\x1b[0mc":              "abc",
+		reverseVideo + "ünï" + clearLine: "ünï",
+	}
+	for line, expected := range tests {
+		if actual := stripColour(line); actual != expected {
+			t.Errorf("stripColour(%q) = %q, expected %q", line, actual, expected)
+		}
+	}
+}
+
+func TestPadRight(t *testing.T) {
+	tests := []struct {
+		line     string
+		width    int
+		expected string
+	}{
+		{"abc", 5, "abc  "},
+		{"abcdef", 5, "abcdef"},
+		{"\x1b[1mab\x1b[0m", 4, "\x1b[1mab\x1b[0m  "},
+		{"ünï", 4, "ünï "},
+	}
+	for _, test := range tests {
+		if actual := padRight(test.line, test.width); actual != test.expected {
+			t.Errorf("padRight(%q, %d) = %q, expected %q", test.line, test.width, actual, test.expected)
+		}
+	}
+}
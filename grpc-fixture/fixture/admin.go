@@ -0,0 +1,148 @@
+package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// exchangeSummary describes a single recorded RPC and whether it has been replayed
+type exchangeSummary struct {
+	Method   string `json:"method"`
+	Source   string `json:"source"`
+	Messages int    `json:"messages"`
+	Served   bool   `json:"served"`
+}
+
+// exchanges lists every recorded RPC in the fixture grouped by method
+// and in the order they will be served
+func (f *fixtureStruct) exchanges() []exchangeSummary {
+	f.Lock()
+	defer f.Unlock()
+	var methods []string
+	for method := range f.fixture {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	summaries := []exchangeSummary{}
+	for _, method := range methods {
+		for _, exchange := range f.fixture[method].nextMessages {
+			summary := exchangeSummary{
+				Method: method,
+				Source: exchange.source,
+				Served: true,
+			}
+			exchange.walk(func(node *messageTree) {
+				summary.Messages++
+				summary.Served = summary.Served && node.called
+			})
+			summaries = append(summaries, summary)
+		}
+	}
+	return summaries
+}
+
+// adminHandler exposes an HTTP API for controlling the fixture while it is running:
+//
+//	POST /reset               marks all exchanges as unserved
+//	POST /load?path=<dumps>   loads additional dump files
+//	POST /unload?path=<dumps> removes all exchanges loaded from the dump files
+//	GET  /exchanges           lists all exchanges and whether they have been served
+//	POST /verify              fails with 412 Precondition Failed if any exchange was not served
+//	GET  /state               returns the current scenario state
+//	POST /state?name=<state>  moves the scenario into the given state
+//
+// Dumps are a comma separated list of files, globs and directories in the same format as --dump.
+func (f *fixtureStruct) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reset", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		f.reset()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("/load", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "missing path parameter", http.StatusBadRequest)
+			return
+		}
+		files, err := expandDumpPaths(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, file := range files {
+			if err := f.loadFile(file); err != nil {
+				http.Error(w, fmt.Sprintf("failed to load %s: %v", file, err), http.StatusBadRequest)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("/unload", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "missing path parameter", http.StatusBadRequest)
+			return
+		}
+		// the path itself is also unloaded in case the dump has since been deleted
+		for _, file := range appendUnique(listDumps(path), path) {
+			f.unloadFile(file)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("/exchanges", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, f.exchanges())
+	})
+	mux.HandleFunc("/verify", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		unserved := []exchangeSummary{}
+		for _, exchange := range f.exchanges() {
+			if !exchange.Served {
+				unserved = append(unserved, exchange)
+			}
+		}
+		if len(unserved) > 0 {
+			writeJSON(w, http.StatusPreconditionFailed, unserved)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		if f.scenario == nil {
+			http.Error(w, "no scenario loaded", http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPost {
+			f.scenario.setState(r.URL.Query().Get("name"))
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"state": f.scenario.currentState()})
+	})
+	return mux
+}
+
+func (f *fixtureStruct) serveAdmin(port int) (net.Listener, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on admin port (%d): %v", port, err)
+	}
+	go http.Serve(lis, f.adminHandler())
+	return lis, nil
+}
+
+func postOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,121 @@
+package fixture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDump = `{"service":"test.Service","method":"Unary","messages":[{"message_origin":"client","raw_message":"CgE="},{"message_origin":"server","raw_message":"CgE="}]}
+{"service":"test.Service","method":"Stream","messages":[{"message_origin":"server","raw_message":"CgE="}]}
+`
+
+// writeDump writes the contents to a dump file which is removed at the end of the test
+func writeDump(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "dump.json")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAdmin_VerifyAndReset(t *testing.T) {
+	path := writeDump(t, testDump)
+	f, err := loadFixture(path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(f.adminHandler())
+	defer s.Close()
+
+	resp, err := http.Post(s.URL+"/verify", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("expected verify to fail with no exchanges served, got %d", resp.StatusCode)
+	}
+	var unserved []exchangeSummary
+	if err := json.NewDecoder(resp.Body).Decode(&unserved); err != nil {
+		t.Fatal(err)
+	}
+	if len(unserved) != 2 {
+		t.Fatalf("expected 2 unserved exchanges, got %v", unserved)
+	}
+
+	// simulate all exchanges being served
+	for _, root := range f.fixture {
+		for _, exchange := range root.nextMessages {
+			exchange.walk(func(node *messageTree) {
+				node.called = true
+			})
+		}
+	}
+	resp, err = http.Post(s.URL+"/verify", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected verify to succeed, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(s.URL+"/reset", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected reset to succeed, got %d", resp.StatusCode)
+	}
+	for _, exchange := range f.exchanges() {
+		if exchange.Served {
+			t.Fatalf("exchange %v still served after reset", exchange)
+		}
+	}
+}
+
+func TestAdmin_LoadAndUnload(t *testing.T) {
+	dir := filepath.Dir(writeDump(t, testDump))
+	f := newFixture(nil, nil)
+	s := httptest.NewServer(f.adminHandler())
+	defer s.Close()
+
+	resp, err := http.Post(s.URL+"/load?path="+filepath.Join(dir, "missing.json"), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected loading a missing dump to fail, got %d", resp.StatusCode)
+	}
+
+	// paths are expanded in the same way as --dump
+	resp, err = http.Post(s.URL+"/load?path="+filepath.Join(dir, "*.json"), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected load to succeed, got %d", resp.StatusCode)
+	}
+	if n := len(f.exchanges()); n != 2 {
+		t.Fatalf("expected 2 exchanges after load, got %d", n)
+	}
+
+	resp, err = http.Post(s.URL+"/unload?path="+dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected unload to succeed, got %d", resp.StatusCode)
+	}
+	if n := len(f.exchanges()); n != 0 {
+		t.Fatalf("expected 0 exchanges after unload, got %d", n)
+	}
+}
@@ -0,0 +1,144 @@
+package fixture
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// expandDumpPaths converts a comma separated list of files, globs and directories
+// into the list of dump files in precedence order.
+// Files are ordered as listed; globs and directories are expanded in lexical order.
+func expandDumpPaths(dumpPaths string) ([]string, error) {
+	var files []string
+	for _, pattern := range strings.Split(dumpPaths, ",") {
+		matches, err := expandDumpPath(pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = appendUnique(files, matches...)
+	}
+	return files, nil
+}
+
+// listDumps is like expandDumpPaths but skips any paths that are invalid or missing
+func listDumps(dumpPaths string) []string {
+	var files []string
+	for _, pattern := range strings.Split(dumpPaths, ",") {
+		matches, _ := expandDumpPath(pattern)
+		files = appendUnique(files, matches...)
+	}
+	return files
+}
+
+func expandDumpPath(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dump path %s: %v", pattern, err)
+	}
+	if len(matches) == 0 && !hasGlobMeta(pattern) {
+		// not a glob so the file should exist
+		return nil, fmt.Errorf("dump %s does not exist", pattern)
+	}
+	sort.Strings(matches)
+
+	var files []string
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, match)
+			continue
+		}
+		err = filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != match && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func appendUnique(files []string, paths ...string) []string {
+	for _, path := range paths {
+		duplicate := false
+		for _, file := range files {
+			if file == path {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchDumps polls the dump paths for changes and keeps the fixture in sync:
+// new files are loaded, modified files are reloaded and deleted files are unloaded.
+func (f *fixtureStruct) watchDumps(dumpPaths string, interval time.Duration) {
+	versions := statDumps(dumpPaths)
+	for range time.Tick(interval) {
+		latest := statDumps(dumpPaths)
+		changed := false
+		for path, version := range latest {
+			if previous, ok := versions[path]; ok && previous == version {
+				continue
+			}
+			changed = true
+			log.Print("Reloading changed dump: " + path)
+			if err := f.loadFile(path); err != nil {
+				log.Printf("ERROR - Failed to reload dump %s: %v", path, err)
+			}
+		}
+		for path := range versions {
+			if _, ok := latest[path]; !ok {
+				changed = true
+				log.Print("Unloading removed dump: " + path)
+				f.unloadFile(path)
+			}
+		}
+		if changed {
+			f.setSourceOrder(listDumps(dumpPaths))
+		}
+		versions = latest
+	}
+}
+
+func statDumps(dumpPaths string) map[string]fileVersion {
+	versions := map[string]fileVersion{}
+	for _, file := range listDumps(dumpPaths) {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		versions[file] = fileVersion{info.ModTime(), info.Size()}
+	}
+	return versions
+}
@@ -0,0 +1,52 @@
+package fixture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandDumpPaths_Precedence(t *testing.T) {
+	dump := writeDump(t, testDump)
+	dir := filepath.Dir(dump)
+	for _, name := range []string{"a.json", "dir/d.json", "dir/c.json", "dir/.hidden"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Link(dump, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := expandDumpPaths(filepath.Join(dir, "dir") + "," + filepath.Join(dir, "*.json") + "," + filepath.Join(dir, "a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"dir/c.json", "dir/d.json", "a.json", "dump.json"}
+	if len(files) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, files)
+	}
+	for i := range expected {
+		if files[i] != filepath.Join(dir, expected[i]) {
+			t.Fatalf("expected %v but got %v", expected, files)
+		}
+	}
+
+	f := newFixture(nil, nil)
+	for i := len(files) - 1; i >= 0; i-- {
+		if err := f.loadFile(files[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.setSourceOrder(files)
+	for i, exchange := range f.fixture["/test.Service/Unary"].nextMessages {
+		if exchange.source != files[i] {
+			t.Fatalf("exchange %d loaded from %s but expected %s", i, exchange.source, files[i])
+		}
+	}
+
+	if _, err := expandDumpPaths(filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatal("expected error for missing dump")
+	}
+}
@@ -1,36 +1,78 @@
 package fixture
 
 import (
+	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
 	"github.com/sirupsen/logrus"
-	"strings"
+	"time"
 )
 
 // Run is exported for testing
-func Run(protoRoots, protoDescriptors, dumpPath string, proxyConfig ...grpc_proxy.Configurator) error {
-	var resolvers []proto_decoder.MessageResolver
-	if protoRoots != "" {
-		r, err := proto_decoder.NewFileResolver(strings.Split(protoRoots, ",")...)
+// dumpPaths is a comma separated list of dump files, globs and directories which are served in the order given.
+// If watch is set then the dumps are reloaded whenever they change.
+// If protoSources.Watch is set then the protos are reloaded whenever they change.
+// If scenarioPath is set then RPCs are served from the scenario in preference to the dump.
+// If generate is set then methods with no recorded responses are served generated responses,
+// optionally based on the JSON messages in templatesPath.
+// If adminPort is non-zero then an HTTP API for resetting and inspecting the fixture is served on that port.
+func Run(protoSources proto_decoder.ProtoSources, dumpPaths, scenarioPath string, generate bool, templatesPath string, watch bool, adminPort int, proxyConfig ...grpc_proxy.Configurator) error {
+	resolvers, err := proto_decoder.NewResolvers(protoSources)
+	if err != nil {
+		return err
+	}
+	var methodSources []proto_decoder.MethodDescriptorSource
+	for _, r := range resolvers {
+		if source, ok := r.(proto_decoder.MethodDescriptorSource); ok {
+			methodSources = append(methodSources, source)
+		}
+	}
+	if protoSources.Watch {
+		proto_decoder.Watch(resolvers, time.Second, nil)
+	}
+	encoder := proto_decoder.NewEncoder(resolvers...)
+
+	logger := logrus.New()
+	decoder := proto_decoder.NewDecoder(logger, resolvers...)
+	interceptor := newFixture(encoder, decoder)
+	if dumpPaths == "" && scenarioPath == "" && !generate {
+		return fmt.Errorf("at least one of a dump or scenario must be provided (or generated responses enabled)")
+	}
+	if dumpPaths != "" {
+		files, err := expandDumpPaths(dumpPaths)
 		if err != nil {
 			return err
 		}
-		resolvers = append(resolvers, r)
+		for _, file := range files {
+			if err := interceptor.loadFile(file); err != nil {
+				return fmt.Errorf("failed to load dump %s: %v", file, err)
+			}
+		}
+		if watch {
+			go interceptor.watchDumps(dumpPaths, time.Second)
+		}
 	}
-	if protoDescriptors != "" {
-		r, err := proto_decoder.NewDescriptorResolver(strings.Split(protoRoots, ",")...)
+	if scenarioPath != "" {
+		s, err := loadScenario(scenarioPath)
 		if err != nil {
 			return err
 		}
-		resolvers = append(resolvers, r)
+		interceptor.scenario = s
+	}
+	if generate {
+		g, err := newResponseGenerator(templatesPath, methodSources...)
+		if err != nil {
+			return err
+		}
+		interceptor.generator = g
 	}
-	encoder := proto_decoder.NewEncoder(resolvers...)
 
-	logger := logrus.New()
-	decoder := proto_decoder.NewDecoder(logger, resolvers...)
-	interceptor, err := loadFixture(dumpPath, encoder, decoder)
-	if err != nil {
-		return err
+	if adminPort != 0 {
+		lis, err := interceptor.serveAdmin(adminPort)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Serving fixture admin API on %s", lis.Addr())
 	}
 
 	proxy, err := grpc_proxy.New(
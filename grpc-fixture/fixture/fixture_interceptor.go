@@ -12,21 +12,40 @@ import (
 )
 
 // fixtureInterceptor implements a gRPC.StreamingServerInterceptor that replays saved responses
-func (f fixtureStruct) intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, _ grpc.StreamHandler) error {
+func (f *fixtureStruct) intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, _ grpc.StreamHandler) error {
 	log.Print("intercept for " + info.FullMethod)
+	if f.scenario != nil {
+		if step := f.scenario.match(info.FullMethod); step != nil {
+			return f.scenario.serve(step, ss, info.FullMethod, f.encoder)
+		}
+	}
+
+	f.Lock()
 	messageTreeNode := f.fixture[info.FullMethod]
+	f.Unlock()
 	var taskId = ""
+	if messageTreeNode == nil && f.generator != nil {
+		return f.generator.serve(ss, info.FullMethod)
+	}
 	if messageTreeNode == nil {
 		log.Print("ERROR - No saved responses found for method "+info.FullMethod)
 		return status.Error(codes.Unavailable, "no saved responses found for method "+info.FullMethod)
 	}
 
+	root := messageTreeNode
 	for {
 		var clientOrServerWasCalled  = false
-		for _, message := range messageTreeNode.nextMessages {
+		for _, message := range f.nextMessages(messageTreeNode) {
 			log.Print("Process message " + info.FullMethod)
-			if !message.called {
+			// claimed under the lock so that concurrent calls are served different exchanges
+			if f.claim(message) {
 				clientOrServerWasCalled = true
+				if messageTreeNode == root {
+					// this is the start of an exchange
+					if err := sendRecordedMetadata(ss, message.headers, message.trailers); err != nil {
+						return err
+					}
+				}
 				if message.message.MessageOrigin == internal.ClientMessage {
 					log.Print("Process Client message " + info.FullMethod)
 					// wait for a client message and then proceed based on its contents
@@ -66,7 +85,6 @@ func (f fixtureStruct) intercept(srv interface{}, ss grpc.ServerStream, info *gr
 						taskId = strings.Split(receivedMessageDecoded.String(), "\"")[1]
 					}
 					// found the matching message so recurse deeper into the tree
-					message.called = true
 					messageTreeNode = message
 				} else {
 					var (
@@ -117,7 +135,6 @@ func (f fixtureStruct) intercept(srv interface{}, ss grpc.ServerStream, info *gr
 						}
 					}
 					log.Print("Server response for " + info.FullMethod)
-					message.called = true
 					sendMsgErr := ss.SendMsg(msgBytes)
 					//gc.ChangeState(false)
 					if sendMsgErr != nil {
@@ -126,8 +143,7 @@ func (f fixtureStruct) intercept(srv interface{}, ss grpc.ServerStream, info *gr
 				}
 				//recurse deeper into the tree
 				messageTreeNode = message
-				if len(messageTreeNode.nextMessages) == 0 {
-					messageTreeNode.called = true
+				if len(f.nextMessages(messageTreeNode)) == 0 {
 					// end of the exchange
 					return nil
 				}
@@ -141,3 +157,21 @@ func (f fixtureStruct) intercept(srv interface{}, ss grpc.ServerStream, info *gr
 		}
 	}
 }
+
+// nextMessages returns a snapshot of the messages that can follow node
+func (f *fixtureStruct) nextMessages(node *messageTree) []*messageTree {
+	f.Lock()
+	defer f.Unlock()
+	return node.nextMessages
+}
+
+// claim marks the message as called and reports whether it hadn't been called already
+func (f *fixtureStruct) claim(message *messageTree) bool {
+	f.Lock()
+	defer f.Unlock()
+	if message.called {
+		return false
+	}
+	message.called = true
+	return true
+}
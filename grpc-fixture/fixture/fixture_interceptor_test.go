@@ -0,0 +1,109 @@
+package fixture
+
+import (
+	"encoding/base64"
+	"fmt"
+	"google.golang.org/grpc"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const concurrentExchanges = 20
+
+// concurrentDump records one exchange for each concurrent call
+func concurrentDump() string {
+	var dump strings.Builder
+	for i := 0; i < concurrentExchanges; i++ {
+		raw := base64.StdEncoding.EncodeToString([]byte{byte(i)})
+		fmt.Fprintf(&dump, `{"service":"test.Service","method":"Stream","messages":[{"message_origin":"server","raw_message":"%s"}]}`+"\n", raw)
+	}
+	return dump.String()
+}
+
+// concurrentIntercept serves one call per exchange concurrently and returns the messages sent by each call
+func concurrentIntercept(t *testing.T, f *fixtureStruct, during func()) [][]byte {
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}
+	sent := make([][]byte, concurrentExchanges)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentExchanges; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ss := &stubServerStream{}
+			if err := f.intercept(nil, ss, info, nil); err != nil {
+				t.Error(err)
+				return
+			}
+			sent[i] = ss.sent[0]
+		}(i)
+	}
+	during()
+	wg.Wait()
+	return sent
+}
+
+func TestIntercept_Concurrent(t *testing.T) {
+	f, err := loadFixture(writeDump(t, concurrentDump()), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every call must be served a different exchange
+	sent := concurrentIntercept(t, f, func() {})
+	served := map[byte]bool{}
+	for _, message := range sent {
+		if len(message) != 1 || served[message[0]] {
+			t.Fatalf("exchange served more than once: %v", sent)
+		}
+		served[message[0]] = true
+	}
+	for _, exchange := range f.exchanges() {
+		if !exchange.Served {
+			t.Fatalf("exchange %v not served", exchange)
+		}
+	}
+
+	// the admin endpoints can modify the fixture while calls are being served
+	f.reset()
+	concurrentIntercept(t, f, func() {
+		f.exchanges()
+		f.reset()
+	})
+}
+
+func TestIntercept_ConcurrentReload(t *testing.T) {
+	first, second := writeDump(t, concurrentDump()), writeDump(t, concurrentDump())
+	f, err := loadFixture(first, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.loadFile(second); err != nil {
+		t.Fatal(err)
+	}
+
+	// exchanges are replaced and reordered while calls are being served
+	stop := make(chan struct{})
+	reloaded := make(chan struct{})
+	go func() {
+		defer close(reloaded)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			f.setSourceOrder([]string{second, first})
+			if err := f.loadFile(first); err != nil {
+				t.Error(err)
+			}
+			f.setSourceOrder([]string{first, second})
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		concurrentIntercept(t, f, func() {})
+		f.reset()
+	}
+	close(stop)
+	<-reloaded
+}
@@ -0,0 +1,221 @@
+package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+)
+
+const (
+	// limits how deeply nested messages are populated (to avoid infinite recursion on recursive types)
+	maxGeneratedDepth = 3
+	// maximum number of elements generated for repeated and map fields
+	maxGeneratedElements = 3
+	// number of messages sent in response to a server streaming RPC
+	generatedStreamLength = 3
+)
+
+// responseGenerator serves RPCs that have never been recorded by generating
+// schema-valid responses from the method's .proto definition.
+// Responses are pseudo-random but deterministic for a given method so that
+// clients see the same data every time.
+type responseGenerator struct {
+	sources []proto_decoder.MethodDescriptorSource
+	// templates are JSON messages (keyed by full method name) that
+	// are merged on top of the generated responses
+	templates map[string]json.RawMessage
+}
+
+func newResponseGenerator(templatesPath string, sources ...proto_decoder.MethodDescriptorSource) (*responseGenerator, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("generating responses requires --proto_roots or --proto_descriptors")
+	}
+	g := &responseGenerator{
+		sources:   sources,
+		templates: map[string]json.RawMessage{},
+	}
+	if templatesPath != "" {
+		templates, err := ioutil.ReadFile(templatesPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(templates, &g.templates); err != nil {
+			return nil, fmt.Errorf("failed to decode templates %s: %v", templatesPath, err)
+		}
+	}
+	return g, nil
+}
+
+func (g *responseGenerator) methodDescriptor(fullMethod string) (*desc.MethodDescriptor, bool) {
+	for _, source := range g.sources {
+		if method, ok := source.MethodDescriptor(fullMethod); ok {
+			return method, true
+		}
+	}
+	return nil, false
+}
+
+// serve responds to an RPC with generated messages:
+// unary and client streaming RPCs receive a single response once the client has finished sending,
+// server streaming RPCs receive a fixed number of responses,
+// bidirectional streaming RPCs receive one response per client message.
+func (g *responseGenerator) serve(ss grpc.ServerStream, fullMethod string) error {
+	method, ok := g.methodDescriptor(fullMethod)
+	if !ok {
+		return status.Error(codes.Unavailable, "no saved responses or service definition found for method "+fullMethod)
+	}
+	log.Print("Serving generated response for " + fullMethod)
+
+	sent := 0
+	send := func(count int) error {
+		for i := 0; i < count; i++ {
+			msg, err := g.generate(fullMethod, method.GetOutputType(), sent)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to generate response: %v", err)
+			}
+			sent++
+			if err := ss.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		var receivedMessage []byte
+		err := ss.RecvMsg(&receivedMessage)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if method.IsClientStreaming() && method.IsServerStreaming() {
+			if err := send(1); err != nil {
+				return err
+			}
+		}
+		if !method.IsClientStreaming() {
+			break
+		}
+	}
+
+	switch {
+	case method.IsClientStreaming() && method.IsServerStreaming():
+		return nil
+	case method.IsServerStreaming():
+		return send(generatedStreamLength)
+	default:
+		return send(1)
+	}
+}
+
+// generate creates the n-th response for a method
+func (g *responseGenerator) generate(fullMethod string, messageType *desc.MessageDescriptor, n int) ([]byte, error) {
+	seed := fnv.New64a()
+	fmt.Fprintf(seed, "%s/%d", fullMethod, n)
+	r := rand.New(rand.NewSource(int64(seed.Sum64())))
+
+	msg := dynamic.NewMessage(messageType)
+	populateMessage(r, msg, 0)
+	if template, ok := g.templates[fullMethod]; ok {
+		if err := msg.UnmarshalMergeJSON(template); err != nil {
+			return nil, fmt.Errorf("failed to apply template for %s: %v", fullMethod, err)
+		}
+	}
+	return proto.Marshal(msg)
+}
+
+func populateMessage(r *rand.Rand, msg *dynamic.Message, depth int) {
+	messageType := msg.GetMessageDescriptor()
+
+	// only one field of each oneof can be set
+	chosenOneOfFields := map[*desc.FieldDescriptor]bool{}
+	for _, oneOf := range messageType.GetOneOfs() {
+		choices := oneOf.GetChoices()
+		chosenOneOfFields[choices[r.Intn(len(choices))]] = true
+	}
+
+	for _, field := range messageType.GetFields() {
+		if field.GetOneOf() != nil && !chosenOneOfFields[field] {
+			continue
+		}
+		if messageType := field.GetMessageType(); messageType != nil {
+			if depth >= maxGeneratedDepth {
+				continue
+			}
+			if messageType.GetFullyQualifiedName() == "google.protobuf.Any" {
+				// random bytes would not be a valid instance of any type
+				continue
+			}
+		}
+
+		switch {
+		case field.IsMap():
+			for i := 1 + r.Intn(maxGeneratedElements); i > 0; i-- {
+				key := generateValue(r, field.GetMapKeyType(), depth)
+				msg.PutMapField(field, key, generateValue(r, field.GetMapValueType(), depth))
+			}
+		case field.IsRepeated():
+			for i := 1 + r.Intn(maxGeneratedElements); i > 0; i-- {
+				msg.AddRepeatedField(field, generateValue(r, field, depth))
+			}
+		default:
+			msg.SetField(field, generateValue(r, field, depth))
+		}
+	}
+}
+
+// generateValue creates a random value of the Go type expected by dynamic.Message for this field
+func generateValue(r *rand.Rand, field *desc.FieldDescriptor, depth int) interface{} {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return r.Intn(2) == 1
+	case descriptor.FieldDescriptorProto_TYPE_INT32,
+		descriptor.FieldDescriptorProto_TYPE_SINT32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return int32(r.Intn(1000))
+	case descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return int64(r.Intn(1000))
+	case descriptor.FieldDescriptorProto_TYPE_UINT32,
+		descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return uint32(r.Intn(1000))
+	case descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		return uint64(r.Intn(1000))
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return float32(r.Intn(100000)) / 100
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return float64(r.Intn(100000)) / 100
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return fmt.Sprintf("%s_%d", field.GetName(), r.Intn(1000))
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		b := make([]byte, 8)
+		r.Read(b)
+		return b
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		values := field.GetEnumType().GetValues()
+		return values[r.Intn(len(values))].GetNumber()
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptor.FieldDescriptorProto_TYPE_GROUP:
+		nested := dynamic.NewMessage(field.GetMessageType())
+		populateMessage(r, nested, depth+1)
+		return nested
+	default:
+		panic(fmt.Sprintf("unknown field type %v", field.GetType()))
+	}
+}
@@ -0,0 +1,179 @@
+package fixture
+
+import (
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_descriptor"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testGeneratorProto = `syntax = "proto3";
+package gen;
+
+service Service {
+    rpc Unary(Response) returns (Response);
+    rpc Stream(Response) returns (stream Response);
+}
+
+enum Colour {
+    RED = 0;
+    GREEN = 1;
+    BLUE = 2;
+}
+
+message Response {
+    string name = 1;
+    Colour colour = 2;
+    Nested nested = 3;
+    repeated Nested items = 4;
+}
+
+message Nested {
+    int64 id = 1;
+    Colour colour = 2;
+}
+`
+
+const testTemplates = `{"/gen.Service/Unary": {"name": "templated"}}`
+
+// recvStream is a server stream that receives a single empty client message
+type recvStream struct {
+	stubServerStream
+	received bool
+}
+
+func (s *recvStream) RecvMsg(m interface{}) error {
+	if s.received {
+		return io.EOF
+	}
+	s.received = true
+	*m.(*[]byte) = nil
+	return nil
+}
+
+func newTestGenerator(t *testing.T) *responseGenerator {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := ioutil.WriteFile(filepath.Join(dir, "gen.proto"), []byte(testGeneratorProto), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := proto_decoder.NewFileResolver(proto_descriptor.LoadOptions{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := newResponseGenerator(writeDump(t, testTemplates), resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func decodeGenerated(t *testing.T, g *responseGenerator, fullMethod string, raw []byte) *dynamic.Message {
+	method, ok := g.methodDescriptor(fullMethod)
+	if !ok {
+		t.Fatalf("method %s not found", fullMethod)
+	}
+	msg := dynamic.NewMessage(method.GetOutputType())
+	if err := msg.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestGenerator_Templates(t *testing.T) {
+	g := newTestGenerator(t)
+	ss := &recvStream{}
+	if err := g.serve(ss, "/gen.Service/Unary"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ss.sent) != 1 {
+		t.Fatalf("expected 1 response, got %d", len(ss.sent))
+	}
+	msg := decodeGenerated(t, g, "/gen.Service/Unary", ss.sent[0])
+	if name := msg.GetFieldByName("name"); name != "templated" {
+		t.Errorf("expected templated name, got %v", name)
+	}
+
+	// responses are deterministic for each method
+	again, err := g.generate("/gen.Service/Unary", msg.GetMessageDescriptor(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, ss.sent[0]) {
+		t.Error("expected the same response to be generated again")
+	}
+
+	if _, err := newResponseGenerator(filepath.Join(os.TempDir(), "missing-templates.json"), g.sources...); err == nil {
+		t.Error("expected error for missing templates")
+	}
+}
+
+func TestGenerator_PopulatesFields(t *testing.T) {
+	g := newTestGenerator(t)
+	ss := &recvStream{}
+	if err := g.serve(ss, "/gen.Service/Stream"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ss.sent) != generatedStreamLength {
+		t.Fatalf("expected %d responses, got %d", generatedStreamLength, len(ss.sent))
+	}
+	for _, raw := range ss.sent {
+		msg := decodeGenerated(t, g, "/gen.Service/Stream", raw)
+		colour := msg.GetFieldByName("colour").(int32)
+		if msg.GetMessageDescriptor().FindFieldByName("colour").GetEnumType().FindValueByNumber(colour) == nil {
+			t.Errorf("generated invalid enum value %d", colour)
+		}
+		nested, ok := msg.GetFieldByName("nested").(*dynamic.Message)
+		if !ok || nested == nil {
+			t.Fatalf("nested message not populated in %v", msg)
+		}
+		if raw, err := nested.Marshal(); err != nil || len(raw) == 0 {
+			t.Errorf("nested message fields not populated in %v", msg)
+		}
+		items := msg.GetFieldByName("items").([]interface{})
+		if len(items) == 0 || len(items) > maxGeneratedElements {
+			t.Errorf("expected between 1 and %d items, got %d", maxGeneratedElements, len(items))
+		}
+	}
+}
+
+func TestGenerator_FallbackForUnrecordedMethods(t *testing.T) {
+	f, err := loadFixture(writeDump(t, testDump), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.generator = newTestGenerator(t)
+
+	// recorded methods are still served from the dump
+	ss := &stubServerStream{}
+	if err := f.intercept(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(ss.sent) != 1 || string(ss.sent[0]) != "\n\x01" {
+		t.Errorf("expected recorded response, got %v", ss.sent)
+	}
+
+	generated := &recvStream{}
+	if err := f.intercept(nil, generated, &grpc.StreamServerInfo{FullMethod: "/gen.Service/Unary"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(generated.sent) != 1 {
+		t.Fatalf("expected a generated response, got %v", generated.sent)
+	}
+
+	err = f.intercept(nil, &recvStream{}, &grpc.StreamServerInfo{FullMethod: "/gen.Unknown/Method"}, nil)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected unavailable error for unknown method, got %v", err)
+	}
+}
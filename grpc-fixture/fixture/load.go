@@ -1,65 +1,214 @@
 package fixture
 
 import (
-	"encoding/json"
 	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpformat"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"google.golang.org/grpc/metadata"
 	"io"
 	"log"
 	"os"
+	"sort"
+	"sync"
 )
 
 type fixtureStruct struct {
+	sync.Mutex
 	fixture map[string]*messageTree
-	encoder proto_decoder.MessageEncoder
-	decoder proto_decoder.MessageDecoder
+	// sources are the loaded dump files in precedence order:
+	// for each method, exchanges from earlier sources are served first
+	sources []string
+	// scenario (if loaded) takes precedence over the recorded fixture
+	scenario *scenario
+	// generator (if set) serves methods that have no recorded responses
+	generator *responseGenerator
+	encoder   proto_decoder.MessageEncoder
+	decoder   proto_decoder.MessageDecoder
 }
 
 type messageTree struct {
 	message      *internal.Message
 	called       bool
 	nextMessages []*messageTree
+
+	// source is the dump file this exchange was loaded from.
+	// Only set on the first message of each exchange.
+	source string
+	// headers and trailers are the recorded response metadata.
+	// Only set on the first message of each exchange.
+	headers  metadata.MD
+	trailers metadata.MD
+}
+
+func newFixture(encoder proto_decoder.MessageEncoder, decoder proto_decoder.MessageDecoder) *fixtureStruct {
+	return &fixtureStruct{
+		fixture: map[string]*messageTree{},
+		encoder: encoder,
+		decoder: decoder,
+	}
 }
 
 // load fixture creates a Trie-like structure of messages
 func loadFixture(dumpPath string, encoder proto_decoder.MessageEncoder, decoder proto_decoder.MessageDecoder) (*fixtureStruct, error) {
-	log.Print("Load data for dump: "+ dumpPath)
-	dumpFile, err := os.Open(dumpPath)
-	if err != nil {
+	fixtureStruct := newFixture(encoder, decoder)
+	if err := fixtureStruct.loadFile(dumpPath); err != nil {
 		return nil, err
 	}
+	return fixtureStruct, nil
+}
 
-	dumpDecoder := json.NewDecoder(dumpFile)
-	fixtureStruct := fixtureStruct{
-		fixture: map[string]*messageTree{},
-		encoder: encoder,
-		decoder: decoder,
+// loadFile adds all the RPCs in a dump file to the fixture.
+// If the file has already been loaded then its exchanges are replaced.
+func (f *fixtureStruct) loadFile(dumpPath string) error {
+	log.Print("Load data for dump: " + dumpPath)
+	dumpFile, err := os.Open(dumpPath)
+	if err != nil {
+		return err
 	}
+	defer dumpFile.Close()
 
+	// parse the whole file before modifying the fixture so that
+	// a bad file doesn't leave the fixture partially loaded
+	var rpcs []*internal.RPC
+	dump := dumpformat.NewReader(dumpFile)
 	for {
-		rpc := internal.RPC{}
-		err := dumpDecoder.Decode(&rpc)
+		rpc, err := dump.Read()
 		if err == io.EOF {
 			break
 		}
+		if err == io.ErrUnexpectedEOF {
+			// e.g. the dump is still being written
+			log.Print("Skipping incomplete RPC at the end of dump: " + dumpPath)
+			break
+		}
 		if err != nil {
-			return nil, err
+			return err
 		}
+		rpcs = append(rpcs, rpc)
+	}
 
-		if fixtureStruct.fixture[rpc.StreamName()] == nil {
-			fixtureStruct.fixture[rpc.StreamName()] = &messageTree{}
+	f.Lock()
+	defer f.Unlock()
+	if !f.removeSource(dumpPath) {
+		f.sources = append(f.sources, dumpPath)
+	}
+	for _, rpc := range rpcs {
+		if f.fixture[rpc.StreamName()] == nil {
+			f.fixture[rpc.StreamName()] = &messageTree{}
 		}
-		messageTreeNode := fixtureStruct.fixture[rpc.StreamName()]
-		for _, msg := range rpc.Messages {
-			var foundExisting *messageTree
-			foundExisting = &messageTree{
+		messageTreeNode := f.fixture[rpc.StreamName()]
+		for i, msg := range rpc.Messages {
+			foundExisting := &messageTree{
 				message:      msg,
 				nextMessages: nil,
 			}
+			if i == 0 {
+				foundExisting.source = dumpPath
+				foundExisting.headers = rpc.ResponseHeaders
+				foundExisting.trailers = rpc.ResponseTrailers
+			}
 			messageTreeNode.nextMessages = append(messageTreeNode.nextMessages, foundExisting)
 			messageTreeNode = foundExisting
 		}
 	}
+	f.sortExchanges()
+	return nil
+}
 
-	return &fixtureStruct, nil
+// unloadFile removes all exchanges that were loaded from the given dump file
+func (f *fixtureStruct) unloadFile(dumpPath string) {
+	f.Lock()
+	defer f.Unlock()
+	f.removeSource(dumpPath)
+	for i, source := range f.sources {
+		if source == dumpPath {
+			f.sources = append(f.sources[:i], f.sources[i+1:]...)
+			break
+		}
+	}
+}
+
+// setSourceOrder changes the precedence of loaded dump files.
+// Any loaded files not in the given order keep their relative order after those that are.
+func (f *fixtureStruct) setSourceOrder(order []string) {
+	f.Lock()
+	defer f.Unlock()
+	rank := map[string]int{}
+	for i, source := range order {
+		rank[source] = i
+	}
+	sort.SliceStable(f.sources, func(i, j int) bool {
+		rankI, okI := rank[f.sources[i]]
+		rankJ, okJ := rank[f.sources[j]]
+		switch {
+		case okI && okJ:
+			return rankI < rankJ
+		default:
+			return okI && !okJ
+		}
+	})
+	f.sortExchanges()
+}
+
+// sortExchanges orders the exchanges for each method by the precedence of their source.
+// Must be called with the lock held.
+func (f *fixtureStruct) sortExchanges() {
+	rank := map[string]int{}
+	for i, source := range f.sources {
+		rank[source] = i
+	}
+	for _, root := range f.fixture {
+		// sorted into a new slice as intercept may still be iterating over the old one
+		exchanges := append([]*messageTree(nil), root.nextMessages...)
+		sort.SliceStable(exchanges, func(i, j int) bool {
+			return rank[exchanges[i].source] < rank[exchanges[j].source]
+		})
+		root.nextMessages = exchanges
+	}
+}
+
+// removeSource removes all exchanges loaded from a dump file and reports whether the file had been loaded.
+// Must be called with the lock held.
+func (f *fixtureStruct) removeSource(dumpPath string) bool {
+	loaded := false
+	for _, source := range f.sources {
+		if source == dumpPath {
+			loaded = true
+		}
+	}
+	for method, root := range f.fixture {
+		var remaining []*messageTree
+		for _, exchange := range root.nextMessages {
+			if exchange.source != dumpPath {
+				remaining = append(remaining, exchange)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(f.fixture, method)
+			continue
+		}
+		root.nextMessages = remaining
+	}
+	return loaded
+}
+
+// reset marks every message as not yet called so the fixture can be replayed from the start
+func (f *fixtureStruct) reset() {
+	if f.scenario != nil {
+		f.scenario.reset()
+	}
+	f.Lock()
+	defer f.Unlock()
+	for _, root := range f.fixture {
+		root.walk(func(node *messageTree) {
+			node.called = false
+		})
+	}
+}
+
+func (m *messageTree) walk(fn func(node *messageTree)) {
+	fn(m)
+	for _, next := range m.nextMessages {
+		next.walk(fn)
+	}
 }
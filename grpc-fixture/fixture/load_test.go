@@ -0,0 +1,28 @@
+package fixture
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestLoadFile_IncompleteDump(t *testing.T) {
+	// as if the dump was still being written
+	path := writeDump(t, testDump+`{"service":"test.Service","method":"Unary","mess`)
+	f, err := loadFixture(path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.fixture["/test.Service/Unary"].nextMessages) != 1 || len(f.fixture["/test.Service/Stream"].nextMessages) != 1 {
+		t.Errorf("expected the complete RPCs to be loaded, got %v", f.fixture)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(testDump+`{"service":5}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.loadFile(path); err == nil {
+		t.Error("expected invalid dump to fail to load")
+	}
+	if len(f.fixture["/test.Service/Unary"].nextMessages) != 1 {
+		t.Error("expected the fixture to be unchanged by the invalid dump")
+	}
+}
@@ -0,0 +1,68 @@
+package fixture
+
+import (
+	"bytes"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+	"text/template"
+)
+
+// sendRecordedMetadata sets the recorded response headers and trailers on the stream.
+// Recorded values can be templates that echo the request metadata e.g.
+//
+//	{{header "x-request-id"}}
+func sendRecordedMetadata(ss grpc.ServerStream, headers, trailers metadata.MD) error {
+	requestMetadata, _ := metadata.FromIncomingContext(ss.Context())
+	if len(headers) > 0 {
+		rendered, err := renderMetadata(headers, requestMetadata)
+		if err != nil {
+			return err
+		}
+		if err := ss.SetHeader(rendered); err != nil {
+			return err
+		}
+	}
+	if len(trailers) > 0 {
+		rendered, err := renderMetadata(trailers, requestMetadata)
+		if err != nil {
+			return err
+		}
+		ss.SetTrailer(rendered)
+	}
+	return nil
+}
+
+func renderMetadata(recorded, request metadata.MD) (metadata.MD, error) {
+	funcs := template.FuncMap{
+		// header returns the first value of the given request metadata key
+		"header": func(key string) string {
+			values := request.Get(key)
+			if len(values) == 0 {
+				return ""
+			}
+			return values[0]
+		},
+	}
+
+	rendered := metadata.MD{}
+	for key, values := range recorded {
+		for _, value := range values {
+			if !strings.Contains(value, "{{") {
+				rendered.Append(key, value)
+				continue
+			}
+			tmpl, err := template.New(key).Funcs(funcs).Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for metadata %s: %v", key, err)
+			}
+			buf := &bytes.Buffer{}
+			if err := tmpl.Execute(buf, nil); err != nil {
+				return nil, fmt.Errorf("failed to render metadata %s: %v", key, err)
+			}
+			rendered.Append(key, buf.String())
+		}
+	}
+	return rendered, nil
+}
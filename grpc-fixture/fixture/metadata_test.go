@@ -0,0 +1,33 @@
+package fixture
+
+import (
+	"google.golang.org/grpc/metadata"
+	"testing"
+)
+
+func TestRenderMetadata(t *testing.T) {
+	recorded := metadata.Pairs(
+		"x-request-id", `{{header "x-request-id"}}`,
+		"x-next-page", "abc123",
+		"x-missing", `{{header "not-sent"}}`,
+	)
+	request := metadata.Pairs("x-request-id", "req-1")
+
+	rendered, err := renderMetadata(recorded, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]string{
+		"x-request-id": "req-1",
+		"x-next-page":  "abc123",
+		"x-missing":    "",
+	} {
+		if got := rendered.Get(key); len(got) != 1 || got[0] != expected {
+			t.Fatalf("expected %s to be %q but got %v", key, expected, got)
+		}
+	}
+
+	if _, err := renderMetadata(metadata.Pairs("bad", "{{header"), request); err == nil {
+		t.Fatal("expected error for invalid template")
+	}
+}
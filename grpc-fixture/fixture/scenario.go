@@ -0,0 +1,124 @@
+package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"os"
+	"sync"
+)
+
+// A scenario models a server as a state machine: the response to an RPC
+// depends on the current named state and serving an RPC can move the
+// scenario into a new state.
+//
+// Unlike a plain dump, steps are not consumed when served so the same
+// step can be served repeatedly (e.g. a client polling for job status).
+type scenario struct {
+	sync.Mutex
+	InitialState string          `json:"initial_state"`
+	Steps        []*scenarioStep `json:"steps"`
+
+	state string
+}
+
+type scenarioStep struct {
+	// State this step applies in. An empty state matches any state
+	// but steps with an explicit state take precedence.
+	State string `json:"state,omitempty"`
+	internal.RPC
+	// Transition is the state to move to once this step has been served
+	Transition string `json:"transition,omitempty"`
+}
+
+func loadScenario(path string) (*scenario, error) {
+	log.Print("Load scenario: " + path)
+	scenarioFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer scenarioFile.Close()
+
+	s := &scenario{}
+	if err := json.NewDecoder(scenarioFile).Decode(s); err != nil {
+		return nil, fmt.Errorf("failed to decode scenario %s: %v", path, err)
+	}
+	s.state = s.InitialState
+	return s, nil
+}
+
+// match finds the step to serve for a method in the current state
+func (s *scenario) match(fullMethod string) *scenarioStep {
+	s.Lock()
+	defer s.Unlock()
+	var wildcard *scenarioStep
+	for _, step := range s.Steps {
+		if step.StreamName() != fullMethod {
+			continue
+		}
+		switch step.State {
+		case s.state:
+			return step
+		case "":
+			if wildcard == nil {
+				wildcard = step
+			}
+		}
+	}
+	return wildcard
+}
+
+func (s *scenario) currentState() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.state
+}
+
+func (s *scenario) setState(state string) {
+	s.Lock()
+	defer s.Unlock()
+	s.state = state
+}
+
+func (s *scenario) reset() {
+	s.setState(s.InitialState)
+}
+
+// serve replays a scenario step and then performs its state transition
+func (s *scenario) serve(step *scenarioStep, ss grpc.ServerStream, fullMethod string, encoder proto_decoder.MessageEncoder) error {
+	log.Printf("Serving scenario step for %s in state %q", fullMethod, s.currentState())
+	if err := sendRecordedMetadata(ss, step.ResponseHeaders, step.ResponseTrailers); err != nil {
+		return err
+	}
+	for _, message := range step.Messages {
+		switch message.MessageOrigin {
+		case internal.ClientMessage:
+			var receivedMessage []byte
+			if err := ss.RecvMsg(&receivedMessage); err != nil {
+				return err
+			}
+		case internal.ServerMessage:
+			msgBytes, err := encoder.Encode(fullMethod, message)
+			if err != nil {
+				return err
+			}
+			if err := ss.SendMsg(msgBytes); err != nil {
+				return err
+			}
+		default:
+			return status.Errorf(codes.Internal, "invalid message type: %v", message.MessageOrigin)
+		}
+	}
+
+	if step.Transition != "" {
+		log.Printf("Scenario transitioning to state %q", step.Transition)
+		s.setState(step.Transition)
+	}
+
+	return step.Status.Err()
+}
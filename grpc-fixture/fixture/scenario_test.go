@@ -0,0 +1,64 @@
+package fixture
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"testing"
+)
+
+const testScenario = `{
+  "initial_state": "running",
+  "steps": [
+    {"service": "jobs.Service", "method": "Start", "transition": "running", "messages": [{"message_origin": "server", "raw_message": "AA=="}]},
+    {"state": "running", "service": "jobs.Service", "method": "Poll", "transition": "done", "messages": [{"message_origin": "server", "raw_message": "AQ=="}]},
+    {"state": "done", "service": "jobs.Service", "method": "Poll", "messages": [{"message_origin": "server", "raw_message": "Ag=="}]}
+  ]
+}`
+
+type stubServerStream struct {
+	grpc.ServerStream
+	sent [][]byte
+}
+
+func (s *stubServerStream) Context() context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.MD{})
+}
+
+func (s *stubServerStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m.([]byte))
+	return nil
+}
+
+func TestScenario_Transitions(t *testing.T) {
+	f := newFixture(proto_decoder.NewEncoder(), nil)
+	var err error
+	f.scenario, err = loadScenario(writeDump(t, testScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := &stubServerStream{}
+	for _, method := range []string{"Poll", "Poll", "Poll", "Start", "Poll"} {
+		err := f.intercept(nil, ss, &grpc.StreamServerInfo{FullMethod: "/jobs.Service/" + method}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, msg := range ss.sent {
+		got = append(got, base64.StdEncoding.EncodeToString(msg))
+	}
+	expected := []string{"AQ==", "Ag==", "Ag==", "AA==", "AQ=="}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v but got %v", expected, got)
+		}
+	}
+}
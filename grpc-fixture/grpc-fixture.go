@@ -5,20 +5,29 @@ import (
 	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/grpc-fixture/fixture"
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
 	_ "github.com/bradleyjkemp/grpc-tools/internal/versionflag"
 	"os"
 )
 
 func main() {
 	var (
-		dumpPath         = flag.String("dump", "", "gRPC dump to serve requests from")
+		dumpPaths        = flag.String("dump", "", "A comma separated list of gRPC dump files, globs or directories to serve requests from. Earlier dumps take precedence.")
+		watch            = flag.Bool("watch", false, "Reload dumps whenever they change.")
 		protoRoots       = flag.String("proto_roots", "", "A comma separated list of directories to search for gRPC service definitions.")
-		protoDescriptors = flag.String("proto_descriptors", "", "A comma separated list of proto descriptors to load gRPC service definitions from.")
+		protoDescriptors = flag.String("proto_descriptors", "", "A comma separated list of descriptor set files (e.g. written by protoc --descriptor_set_out --include_imports) to load gRPC service definitions from.")
+		protoImportPaths = flag.String("proto_import_paths", "", "A comma separated list of directories to search for files imported by the .proto files in --proto_roots.")
+		protoStrict      = flag.Bool("proto_strict", false, "Fail if any .proto file in --proto_roots can't be parsed. By default such files are skipped.")
+		protoWatch       = flag.Bool("proto_watch", false, "Reload --proto_roots and --proto_descriptors whenever they change.")
+		scenarioPath     = flag.String("scenario", "", "A scenario file describing stateful responses to serve in preference to the dump.")
+		generate         = flag.Bool("generate", false, "Serve generated responses for methods in --proto_roots/--proto_descriptors that have no recorded responses.")
+		templatesPath    = flag.String("templates", "", "A JSON file mapping full method names (e.g. /pkg.Service/Method) to response messages to use as templates for generated responses.")
+		adminPort        = flag.Int("admin_port", 0, "Port to serve the fixture admin API on (for resetting, loading and verifying fixtures). Disabled if not set.")
 	)
 
 	grpc_proxy.RegisterDefaultFlags()
 	flag.Parse()
-	err := fixture.Run(*protoRoots, *protoDescriptors, *dumpPath, grpc_proxy.DefaultFlags())
+	err := fixture.Run(proto_decoder.ProtoSources{Roots: *protoRoots, ImportPaths: *protoImportPaths, Descriptors: *protoDescriptors, Strict: *protoStrict, Watch: *protoWatch}, *dumpPaths, *scenarioPath, *generate, *templatesPath, *watch, *adminPort, grpc_proxy.DefaultFlags())
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		flag.Usage()
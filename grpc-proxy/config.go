@@ -6,6 +6,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"io"
 	"runtime/debug"
 )
 
@@ -19,7 +20,7 @@ func WithOptions(options ...grpc.ServerOption) Configurator {
 
 func WithInterceptor(interceptor grpc.StreamServerInterceptor) Configurator {
 	return func(s *server) {
-		s.serverOptions = append(s.serverOptions, grpc.StreamInterceptor(recoverWrapper(s, interceptor)))
+		s.interceptor = recoverWrapper(s, interceptor)
 	}
 }
 
@@ -35,6 +36,13 @@ func recoverWrapper(s *server, interceptor grpc.StreamServerInterceptor) grpc.St
 	}
 }
 
+// WithLogOutput writes the proxy's logs to out instead of stderr
+func WithLogOutput(out io.Writer) Configurator {
+	return func(s *server) {
+		s.logOutput = out
+	}
+}
+
 func UsingTLS(certFile, keyFile string) Configurator {
 	return func(s *server) {
 		s.certFile = certFile
@@ -48,6 +56,13 @@ func Port(port int) Configurator {
 	}
 }
 
+// MetricsPort serves Prometheus metrics on the given port (at /metrics)
+func MetricsPort(port int) Configurator {
+	return func(s *server) {
+		s.metricsPort = port
+	}
+}
+
 func WithDialer(dialer ContextDialer) Configurator {
 	return func(s *server) {
 		s.dialer = dialer
@@ -61,6 +76,7 @@ var (
 	fDestination       string
 	fLogLevel          string
 	fEnableSystemProxy bool
+	fMetricsPort       int
 )
 
 // Must be called before flag.Parse() if using the DefaultFlags option
@@ -71,6 +87,7 @@ func RegisterDefaultFlags() {
 	flag.StringVar(&fDestination, "destination", "", "Destination server to forward requests to if no destination can be inferred from the request itself. This is generally only used for clients not supporting HTTP proxies.")
 	flag.StringVar(&fLogLevel, "log_level", logrus.InfoLevel.String(), "Set the log level that grpc-proxy will log at. Values are {error, warning, info, debug}")
 	flag.BoolVar(&fEnableSystemProxy, "system_proxy", false, "Automatically configure system to use this as the proxy for all connections.")
+	flag.IntVar(&fMetricsPort, "metrics_port", 0, "Port to serve Prometheus metrics on (at /metrics). Disabled if not set.")
 }
 
 // This must be used after a call to flag.Parse()
@@ -81,5 +98,6 @@ func DefaultFlags() Configurator {
 		s.keyFile = fKeyFile
 		s.destination = fDestination
 		s.enableSystemProxy = fEnableSystemProxy
+		s.metricsPort = fMetricsPort
 	}
 }
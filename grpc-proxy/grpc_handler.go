@@ -2,7 +2,6 @@ package grpc_proxy
 
 import (
 	"context"
-	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/internal/codec"
 	"github.com/bradleyjkemp/grpc-tools/internal/marker"
 	"google.golang.org/grpc"
@@ -11,7 +10,6 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"io"
-	"os"
 	"strings"
 )
 
@@ -76,7 +74,7 @@ func (s *server) proxyHandler(srv interface{}, ss grpc.ServerStream) error {
 				// to cancel the clientStream to the backend, let all of its goroutines be freed up by the CancelFunc and
 				// exit with an error to the stack
 				clientCancel()
-				fmt.Fprintln(os.Stderr, "failed proxying s2c", s2cErr)
+				s.logger.WithError(s2cErr).Warn("failed proxying s2c")
 				return grpc.Errorf(codes.Internal, "failed proxying s2c: %v", s2cErr)
 			}
 		case c2sErr := <-c2sErrChan:
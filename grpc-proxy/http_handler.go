@@ -2,7 +2,9 @@ package grpc_proxy
 
 import (
 	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
 	"github.com/bradleyjkemp/grpc-tools/internal/marker"
+	"github.com/bradleyjkemp/grpc-tools/internal/tlsmux"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
@@ -36,7 +38,7 @@ func (locker *CalledState) CheckState() bool {
 	return locker.globalCalled
 }
 
-func newHttpServer(logger logrus.FieldLogger, grpcHandler grpcWebServer, internalRedirect func(net.Conn, string), reverseProxy http.Handler) *http.Server {
+func newHttpServer(logger logrus.FieldLogger, grpcHandler grpcWebServer, internalRedirect func(net.Conn, string), reverseProxy http.Handler, tlsStates *tlsmux.ConnectionStates) *http.Server {
 	gc := CalledState{
 		globalCalled: false,
 	}
@@ -67,6 +69,7 @@ func newHttpServer(logger logrus.FieldLogger, grpcHandler grpcWebServer, interna
 				// Bad Request: HTTP status code 400; transport: received the unexpected content-type \"text/plain; charset=utf-8\"
 				r.Header.Del("Connection")
 				r.Header.Del("Proxy-Connection")
+				r = r.WithContext(internal.WithConnection(r.Context(), connectionInfo(r, grpcHandler, tlsStates)))
 				grpcHandler.ServeHTTP(w, r)
 				log.Print("Finished Handling gRPC request ")
 				gc.ChangeState(false)
@@ -123,6 +126,29 @@ func newReverseProxy(logger logrus.FieldLogger) *httputil.ReverseProxy {
 	}
 }
 
+// connectionInfo describes the connection that a gRPC request was received on.
+// This must be called before the request is handled as the gRPC-Web wrapper modifies the request.
+func connectionInfo(r *http.Request, grpcHandler grpcWebServer, tlsStates *tlsmux.ConnectionStates) *internal.Connection {
+	connection := &internal.Connection{
+		Protocol:    internal.ProtocolGRPC,
+		HTTPVersion: r.Proto,
+	}
+	if grpcHandler.IsGrpcWebRequest(r) {
+		connection.Protocol = internal.ProtocolGRPCWeb
+	}
+	if encoding := r.Header.Get("grpc-encoding"); encoding != "" && encoding != "identity" {
+		connection.Compression = encoding
+	}
+	if r.TLS != nil {
+		connection.SetTLS(r.TLS.Version, r.TLS.CipherSuite)
+	} else if tlsStates != nil {
+		if state, ok := tlsStates.Get(r.RemoteAddr); ok {
+			connection.SetTLS(state.Version, state.CipherSuite)
+		}
+	}
+	return connection
+}
+
 func isGrpcRequest(server grpcWebServer, r *http.Request) bool {
 	return server.IsGrpcWebRequest(r) || // gRPC-Web request from browser
 		r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc") // Standard gRPC request
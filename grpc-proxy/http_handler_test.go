@@ -2,6 +2,7 @@ package grpc_proxy
 
 import (
 	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
 	"github.com/sirupsen/logrus"
 	"net"
 	"net/http"
@@ -37,7 +38,7 @@ func TestHTTPHandler_RedirectsCONNECT(t *testing.T) {
 		proxiedConn <- conn
 		destination <- dest
 		handlerFinished.Done()
-	}, nil).Handler)
+	}, nil, nil).Handler)
 
 	clientConn, err := net.Dial(s.Listener.Addr().Network(), s.Listener.Addr().String())
 	if err != nil {
@@ -80,14 +81,16 @@ func TestHTTPHandler_RedirectsCONNECT(t *testing.T) {
 func TestHTTPHandler_InterceptsGRPC(t *testing.T) {
 	logger := logrus.New()
 	var gRPCHandlerCalled bool
+	var connection *internal.Connection
 	s := httptest.NewServer(newHttpServer(logger, stubGRPCWebHandler{
-		handler: func(_ http.ResponseWriter, _ *http.Request) {
+		handler: func(_ http.ResponseWriter, r *http.Request) {
 			gRPCHandlerCalled = true
+			connection = internal.ConnectionFromContext(r.Context())
 		},
 		isGRPC: func(_ *http.Request) bool {
 			return true
 		},
-	}, nil, nil).Handler)
+	}, nil, nil, nil).Handler)
 
 	_, err := http.Post(s.URL, "", nil)
 	if err != nil {
@@ -96,6 +99,9 @@ func TestHTTPHandler_InterceptsGRPC(t *testing.T) {
 	if !gRPCHandlerCalled {
 		panic("gRPC Handler not called")
 	}
+	if connection.Protocol != internal.ProtocolGRPCWeb || connection.HTTPVersion != "HTTP/1.1" {
+		t.Errorf("unexpected connection %+v", connection)
+	}
 }
 
 func TestHTTPHandler_ReverseProxiesUnknown(t *testing.T) {
@@ -110,7 +116,7 @@ func TestHTTPHandler_ReverseProxiesUnknown(t *testing.T) {
 		},
 	}, nil, http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
 		reverseProxyCalled = true
-	})).Handler)
+	}), nil).Handler)
 
 	_, err := http.Get(s.URL)
 	if err != nil {
@@ -0,0 +1,258 @@
+package grpc_proxy
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	durationBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+	sizeBuckets     = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+)
+
+// proxyMetrics are exposed in the Prometheus text format (https://prometheus.io/docs/instrumenting/exposition_formats/)
+type proxyMetrics struct {
+	sync.Mutex
+	families []*metricFamily
+
+	requests      *metricFamily
+	duration      *metricFamily
+	messageSize   *metricFamily
+	activeStreams *metricFamily
+	dials         *metricFamily
+	dialDuration  *metricFamily
+	poolSize      func() int
+}
+
+func newProxyMetrics(poolSize func() int) *proxyMetrics {
+	m := &proxyMetrics{poolSize: poolSize}
+	m.requests = m.family("grpc_proxy_requests_total", "Number of RPCs handled by the proxy.", "counter", nil, "service", "method", "code")
+	m.duration = m.family("grpc_proxy_request_duration_seconds", "Time taken to handle RPCs.", "histogram", durationBuckets, "service", "method")
+	m.messageSize = m.family("grpc_proxy_message_size_bytes", "Size of messages sent by clients and servers.", "histogram", sizeBuckets, "service", "method", "origin")
+	m.activeStreams = m.family("grpc_proxy_active_streams", "Number of RPCs currently in progress.", "gauge", nil, "service", "method")
+	m.dials = m.family("grpc_proxy_upstream_dials_total", "Number of connections dialled to upstream servers.", "counter", nil, "destination", "result")
+	m.dialDuration = m.family("grpc_proxy_upstream_dial_duration_seconds", "Time taken to dial upstream servers.", "histogram", durationBuckets, "destination")
+	return m
+}
+
+func (m *proxyMetrics) family(name, help, kind string, buckets []float64, labels ...string) *metricFamily {
+	family := &metricFamily{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	m.families = append(m.families, family)
+	return family
+}
+
+// interceptor records metrics for every RPC before passing it on to the next interceptor (if any)
+func (m *proxyMetrics) interceptor(next grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		service, method := splitMethod(info.FullMethod)
+		m.add(m.activeStreams, 1, service, method)
+		defer m.add(m.activeStreams, -1, service, method)
+
+		start := time.Now()
+		var err error
+		metered := &meteredServerStream{ss, m, service, method}
+		if next != nil {
+			err = next(srv, metered, info, handler)
+		} else {
+			err = handler(srv, metered)
+		}
+		m.add(m.requests, 1, service, method, status.Code(err).String())
+		m.observe(m.duration, time.Since(start).Seconds(), service, method)
+		return err
+	}
+}
+
+func (m *proxyMetrics) observeDial(destination string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.add(m.dials, 1, destination, result)
+	m.observe(m.dialDuration, duration.Seconds(), destination)
+}
+
+func (m *proxyMetrics) add(family *metricFamily, delta float64, labels ...string) {
+	m.Lock()
+	defer m.Unlock()
+	family.get(labels).value += delta
+}
+
+func (m *proxyMetrics) observe(family *metricFamily, value float64, labels ...string) {
+	m.Lock()
+	defer m.Unlock()
+	s := family.get(labels)
+	for i, bucket := range family.buckets {
+		if value <= bucket {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.value++
+}
+
+func (m *proxyMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.writeTo(w)
+}
+
+func (m *proxyMetrics) writeTo(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+	for _, family := range m.families {
+		family.writeTo(w)
+	}
+	fmt.Fprintln(w, "# HELP grpc_proxy_connection_pool_size Number of connections to upstream servers.")
+	fmt.Fprintln(w, "# TYPE grpc_proxy_connection_pool_size gauge")
+	fmt.Fprintln(w, "grpc_proxy_connection_pool_size", m.poolSize())
+}
+
+func (m *proxyMetrics) serve(port int) (net.Listener, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on metrics port (%d): %v", port, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(lis, mux)
+	return lis, nil
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labels []string
+	// the value of a counter or gauge, or the number of observations of a histogram
+	value   float64
+	buckets []uint64
+	sum     float64
+}
+
+func (f *metricFamily) get(labels []string) *series {
+	key := strings.Join(labels, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			labels:  labels,
+			buckets: make([]uint64, len(f.buckets)),
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *metricFamily) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	var keys []string
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		labels := f.formatLabels(s.labels)
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatValue(s.value))
+			continue
+		}
+		for i, bucket := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.formatLabels(s.labels, "le", formatValue(bucket)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %s\n", f.name, f.formatLabels(s.labels, "le", "+Inf"), formatValue(s.value))
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %s\n", f.name, labels, formatValue(s.value))
+	}
+}
+
+// formatLabels formats label values (and any extra label name/value pairs) as {name="value",...}
+func (f *metricFamily) formatLabels(values []string, extra ...string) string {
+	var pairs []string
+	for i, value := range values {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labels[i], labelEscaper.Replace(value)))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func splitMethod(fullMethod string) (service, method string) {
+	parts := strings.SplitN(strings.TrimPrefix(fullMethod, "/"), "/", 2)
+	if len(parts) != 2 {
+		return fullMethod, ""
+	}
+	return parts[0], parts[1]
+}
+
+// meteredServerStream records the size of every message sent and received
+type meteredServerStream struct {
+	grpc.ServerStream
+	metrics         *proxyMetrics
+	service, method string
+}
+
+func (m *meteredServerStream) RecvMsg(msg interface{}) error {
+	err := m.ServerStream.RecvMsg(msg)
+	if err == nil {
+		m.metrics.observe(m.metrics.messageSize, float64(messageSize(msg)), m.service, m.method, "client")
+	}
+	return err
+}
+
+func (m *meteredServerStream) SendMsg(msg interface{}) error {
+	err := m.ServerStream.SendMsg(msg)
+	if err == nil {
+		m.metrics.observe(m.metrics.messageSize, float64(messageSize(msg)), m.service, m.method, "server")
+	}
+	return err
+}
+
+func messageSize(msg interface{}) int {
+	switch msg := msg.(type) {
+	case []byte:
+		return len(msg)
+	case *[]byte:
+		return len(*msg)
+	case proto.Message:
+		return proto.Size(msg)
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package grpc_proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubServerStream struct {
+	grpc.ServerStream
+}
+
+func (stubServerStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stubServerStream) RecvMsg(m interface{}) error {
+	*(m.(*[]byte)) = []byte("request")
+	return nil
+}
+
+func (stubServerStream) SendMsg(m interface{}) error {
+	return nil
+}
+
+func TestMetrics(t *testing.T) {
+	m := newProxyMetrics(func() int { return 3 })
+	interceptor := m.interceptor(nil)
+	info := &grpc.StreamServerInfo{FullMethod: "/mypackage.Service/Method"}
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		var msg []byte
+		if err := ss.RecvMsg(&msg); err != nil {
+			return err
+		}
+		if err := ss.SendMsg(make([]byte, 2000)); err != nil {
+			return err
+		}
+		return status.Error(codes.NotFound, "not found")
+	}
+	for i := 0; i < 2; i++ {
+		interceptor(nil, stubServerStream{}, info, handler)
+	}
+	m.observeDial("example.com:443", time.Millisecond, errors.New("connection refused"))
+
+	output := &bytes.Buffer{}
+	m.writeTo(output)
+	expectedLines := []string{
+		`grpc_proxy_requests_total{service="mypackage.Service",method="Method",code="NotFound"} 2`,
+		`grpc_proxy_request_duration_seconds_count{service="mypackage.Service",method="Method"} 2`,
+		`grpc_proxy_message_size_bytes_bucket{service="mypackage.Service",method="Method",origin="client",le="64"} 2`,
+		`grpc_proxy_message_size_bytes_bucket{service="mypackage.Service",method="Method",origin="server",le="1024"} 0`,
+		`grpc_proxy_message_size_bytes_bucket{service="mypackage.Service",method="Method",origin="server",le="4096"} 2`,
+		`grpc_proxy_message_size_bytes_sum{service="mypackage.Service",method="Method",origin="server"} 4000`,
+		`grpc_proxy_active_streams{service="mypackage.Service",method="Method"} 0`,
+		`grpc_proxy_upstream_dials_total{destination="example.com:443",result="failure"} 1`,
+		`grpc_proxy_connection_pool_size 3`,
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("expected metrics to contain %s, got:\n%s", line, output)
+		}
+	}
+}
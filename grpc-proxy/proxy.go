@@ -17,6 +17,7 @@ import (
 	"golang.org/x/net/http/httpproxy"
 	"google.golang.org/grpc"
 	_ "google.golang.org/grpc/encoding/gzip"
+	"io"
 	"net"
 	"os"
 	"os/signal"
@@ -27,8 +28,10 @@ type ContextDialer = func(context.Context, string) (net.Conn, error)
 
 type server struct {
 	serverOptions []grpc.ServerOption
+	interceptor   grpc.StreamServerInterceptor
 	grpcServer    *grpc.Server
 	logger        logrus.FieldLogger
+	logOutput     io.Writer
 
 	port     int
 	certFile string
@@ -42,6 +45,9 @@ type server struct {
 
 	enableSystemProxy bool
 
+	metricsPort int
+	metrics     *proxyMetrics
+
 	listener net.Listener
 }
 
@@ -60,10 +66,24 @@ func New(configurators ...Configurator) (*server, error) {
 		configurator(s)
 	}
 
+	if s.logOutput != nil {
+		logger.SetOutput(s.logOutput)
+	}
+
 	// Have to initialise the connpool now because
 	// the dialer may been changed by options
 	s.connPool = internal.NewConnPool(logger, s.dialer)
 
+	interceptor := s.interceptor
+	if s.metricsPort != 0 {
+		s.metrics = newProxyMetrics(s.connPool.Size)
+		s.connPool.ObserveDials(s.metrics.observeDial)
+		interceptor = s.metrics.interceptor(interceptor)
+	}
+	if interceptor != nil {
+		s.serverOptions = append(s.serverOptions, grpc.StreamInterceptor(interceptor))
+	}
+
 	if fLogLevel != "" {
 		level, err := logrus.ParseLevel(fLogLevel)
 		if err != nil {
@@ -108,6 +128,13 @@ func (s *server) Start() error {
 	} else {
 		s.logger.Infof("Not intercepting TLS connections")
 	}
+	if s.metrics != nil {
+		metricsLis, err := s.metrics.serve(s.metricsPort)
+		if err != nil {
+			return err
+		}
+		s.logger.Infof("Serving metrics on http://%s/metrics", metricsLis.Addr())
+	}
 
 	grpcWebHandler := grpcweb.WrapServer(
 		grpc.NewServer(s.serverOptions...),
@@ -116,11 +143,11 @@ func (s *server) Start() error {
 	)
 
 	proxyLis := newProxyListener(s.logger, s.listener)
-	httpReverseProxy := newReverseProxy(s.logger)
-	httpServer := newHttpServer(s.logger, grpcWebHandler, proxyLis.internalRedirect, httpReverseProxy)
-	httpsServer := withHttpsMiddleware(newHttpServer(s.logger, grpcWebHandler, proxyLis.internalRedirect, httpReverseProxy))
+	httpLis, httpsLis, tlsStates := tlsmux.New(s.logger, proxyLis, s.x509Cert, s.tlsCert)
 
-	httpLis, httpsLis := tlsmux.New(s.logger, proxyLis, s.x509Cert, s.tlsCert)
+	httpReverseProxy := newReverseProxy(s.logger)
+	httpServer := newHttpServer(s.logger, grpcWebHandler, proxyLis.internalRedirect, httpReverseProxy, tlsStates)
+	httpsServer := withHttpsMiddleware(newHttpServer(s.logger, grpcWebHandler, proxyLis.internalRedirect, httpReverseProxy, tlsStates))
 
 	errChan := make(chan error)
 	if s.enableSystemProxy {
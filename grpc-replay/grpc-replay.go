@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/grpc-replay/replay"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
 	"github.com/bradleyjkemp/grpc-tools/internal/proxydialer"
 	_ "github.com/bradleyjkemp/grpc-tools/internal/versionflag"
 	"golang.org/x/net/http/httpproxy"
@@ -15,11 +16,13 @@ func main() {
 		destinationOverride = flag.String("destination", "", "Destination server to forward requests to. By default the destination for each RPC is autodetected from the dump metadata.")
 		dumpPath            = flag.String("dump", "", "The gRPC dump to replay requests from")
 		protoRoots          = flag.String("proto_roots", "", "A comma separated list of directories to search for gRPC service definitions.")
-		protoDescriptors    = flag.String("proto_descriptors", "", "A comma separated list of proto descriptors to load gRPC service definitions from.")
+		protoDescriptors    = flag.String("proto_descriptors", "", "A comma separated list of descriptor set files (e.g. written by protoc --descriptor_set_out --include_imports) to load gRPC service definitions from.")
+		protoImportPaths    = flag.String("proto_import_paths", "", "A comma separated list of directories to search for files imported by the .proto files in --proto_roots.")
+		protoStrict         = flag.Bool("proto_strict", false, "Fail if any .proto file in --proto_roots can't be parsed. By default such files are skipped.")
 	)
 
 	flag.Parse()
-	err := replay.Run(*protoRoots, *protoDescriptors, *dumpPath, *destinationOverride, proxydialer.NewProxyDialer(httpproxy.FromEnvironment().ProxyFunc()))
+	err := replay.Run(proto_decoder.ProtoSources{Roots: *protoRoots, ImportPaths: *protoImportPaths, Descriptors: *protoDescriptors, Strict: *protoStrict}, *dumpPath, *destinationOverride, proxydialer.NewProxyDialer(httpproxy.FromEnvironment().ProxyFunc()))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		flag.Usage()
@@ -2,11 +2,11 @@ package replay
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
 	"github.com/bradleyjkemp/grpc-tools/internal"
 	"github.com/bradleyjkemp/grpc-tools/internal/codec"
+	"github.com/bradleyjkemp/grpc-tools/internal/dumpformat"
 	"github.com/bradleyjkemp/grpc-tools/internal/marker"
 	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
 	"github.com/sirupsen/logrus"
@@ -15,44 +15,38 @@ import (
 	"google.golang.org/grpc/metadata"
 	"io"
 	"os"
-	"strings"
 	"time"
 )
 
-func Run(protoRoots, protoDescriptors, dumpPath, destinationOverride string, dialer grpc_proxy.ContextDialer) error {
+func Run(protoSources proto_decoder.ProtoSources, dumpPath, destinationOverride string, dialer grpc_proxy.ContextDialer) error {
 	pool := internal.NewConnPool(logrus.New(), dialer)
 
 	dumpFile, err := os.Open(dumpPath)
 	if err != nil {
 		return err
 	}
-	var resolvers []proto_decoder.MessageResolver
-	if protoRoots != "" {
-		r, err := proto_decoder.NewFileResolver(strings.Split(protoRoots, ",")...)
-		if err != nil {
-			return err
-		}
-		resolvers = append(resolvers, r)
-	}
-	if protoDescriptors != "" {
-		r, err := proto_decoder.NewDescriptorResolver(strings.Split(protoRoots, ",")...)
-		if err != nil {
-			return err
-		}
-		resolvers = append(resolvers, r)
+	defer dumpFile.Close()
+	resolvers, err := proto_decoder.NewResolvers(protoSources)
+	if err != nil {
+		return err
 	}
 	encoder := proto_decoder.NewEncoder(resolvers...)
 
-	dumpDecoder := json.NewDecoder(dumpFile)
+	// RPCs are replayed as they are read so that large dumps don't have to fit in memory
+	dump := dumpformat.NewReader(dumpFile)
 RPC:
 	for {
-		rpc := internal.RPC{}
-		err := dumpDecoder.Decode(&rpc)
+		rpc, err := dump.Read()
 		if err == io.EOF {
 			break
 		}
+		if err == io.ErrUnexpectedEOF {
+			// e.g. the dump is still being written
+			fmt.Println("Skipping incomplete RPC at the end of the dump")
+			break
+		}
 		if err != nil {
-			return fmt.Errorf("failed to decode dump: %s", err)
+			return err
 		}
 
 		conn, err := getConnection(pool, rpc.Metadata, destinationOverride)
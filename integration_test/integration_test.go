@@ -1,4 +1,5 @@
-//+build integration
+//go:build integration
+// +build integration
 
 package main
 
@@ -10,26 +11,30 @@ import (
 	"github.com/bradleyjkemp/grpc-tools/grpc-fixture/fixture"
 	"github.com/bradleyjkemp/grpc-tools/grpc-proxy"
 	"github.com/bradleyjkemp/grpc-tools/grpc-replay/replay"
+	"github.com/bradleyjkemp/grpc-tools/internal/proto_decoder"
 	"github.com/bradleyjkemp/grpc-tools/internal/proxydialer"
 	"net/url"
+	"os"
 	"os/exec"
 	"regexp"
 	"testing"
 )
 
 const (
-	protoRoots       = "."
-	protoDescriptors = ""
-	certFile         = "_wildcard.github.io.pem"
-	keyFile          = "_wildcard.github.io-key.pem"
+	certFile = "_wildcard.github.io.pem"
+	keyFile  = "_wildcard.github.io-key.pem"
 
 	fixturePort = 16353
 	dumpPort    = 16354
 )
 
 var (
+	protoSources   = proto_decoder.ProtoSources{Roots: "."}
 	timestampRegex = regexp.MustCompile(`"timestamp":"[0-9TZ:.+\-]+"`)
-	snapshotter    = cupaloy.NewDefaultConfig().WithOptions(cupaloy.SnapshotFileExtension(".json"))
+	timingRegex    = regexp.MustCompile(`"timing":{[^}]*}`)
+	// addresses include ephemeral ports and the cipher suite depends on the client's preferences
+	connectionRegex = regexp.MustCompile(`"(client_address|upstream_address|tls_cipher_suite)":"[^"]*"`)
+	snapshotter     = cupaloy.NewDefaultConfig().WithOptions(cupaloy.SnapshotFileExtension(".json"))
 )
 
 func TestIntegration(t *testing.T) {
@@ -46,9 +51,13 @@ func TestIntegration(t *testing.T) {
 
 	go func() {
 		fixtureErr := fixture.Run(
-			protoRoots,
-			protoDescriptors,
+			protoSources,
 			"test-fixture.json",
+			"",
+			false,
+			"",
+			false,
+			0,
 			grpc_proxy.Port(fixturePort),
 			grpc_proxy.UsingTLS(certFile, keyFile),
 		)
@@ -60,9 +69,11 @@ func TestIntegration(t *testing.T) {
 	dumpLog := &bytes.Buffer{}
 	go func() {
 		dumpErr := dump.Run(
-			dumpLog,
-			protoRoots,
-			protoDescriptors,
+			dump.NewJSONWriter(dumpLog),
+			os.Stderr,
+			protoSources,
+			nil,
+			nil,
 			grpc_proxy.Port(dumpPort),
 			grpc_proxy.UsingTLS(certFile, keyFile),
 			grpc_proxy.WithDialer(proxydialer.NewProxyDialer(func(req *url.URL) (*url.URL, error) {
@@ -77,8 +88,7 @@ func TestIntegration(t *testing.T) {
 	}()
 
 	replayErr := replay.Run(
-		protoRoots,
-		protoDescriptors,
+		protoSources,
 		"test-dump.json",
 		"",
 		proxydialer.NewProxyDialer(func(req *url.URL) (*url.URL, error) {
@@ -104,6 +114,8 @@ func TestIntegration(t *testing.T) {
 		t.Fail()
 	}
 	dumpLogSanitised := timestampRegex.ReplaceAll(dumpLog.Bytes(), []byte("\"timestamp\":\"2019-06-24T19:19:46.644943+01:00\""))
+	dumpLogSanitised = timingRegex.ReplaceAll(dumpLogSanitised, []byte(`"timing":{"start":"2019-06-24T19:19:46.644943+01:00","end":"2019-06-24T19:19:46.644943+01:00","duration_ms":1}`))
+	dumpLogSanitised = connectionRegex.ReplaceAll(dumpLogSanitised, []byte(`"$1":"sanitised"`))
 
 	snapshotter.SnapshotT(t, dumpLogSanitised)
 }
@@ -7,25 +7,47 @@ import (
 	"google.golang.org/grpc"
 	"net"
 	"sync"
+	"time"
 )
 
 type contextDialer = func(context.Context, string) (net.Conn, error)
 
+// DialObserver is called with the result of every new connection dialled by a ConnPool
+type DialObserver func(destination string, duration time.Duration, err error)
+
 type ConnPool struct {
 	sync.Mutex
-	conns  map[string]*grpc.ClientConn
-	logger logrus.FieldLogger
-	dialer contextDialer
+	conns map[string]*grpc.ClientConn
+	// the address most recently connected to for each destination
+	remoteAddrs map[string]string
+	logger      logrus.FieldLogger
+	dialer      contextDialer
+	observer    DialObserver
 }
 
 func NewConnPool(logger logrus.FieldLogger, dialer contextDialer) *ConnPool {
 	return &ConnPool{
-		conns:  map[string]*grpc.ClientConn{},
-		logger: logger.WithField("", "connpool"),
-		dialer: dialer,
+		conns:       map[string]*grpc.ClientConn{},
+		remoteAddrs: map[string]string{},
+		logger:      logger.WithField("", "connpool"),
+		dialer:      dialer,
 	}
 }
 
+// ObserveDials registers a function to be called after each new connection is dialled
+func (c *ConnPool) ObserveDials(observer DialObserver) {
+	c.Lock()
+	defer c.Unlock()
+	c.observer = observer
+}
+
+// Size returns the number of connections in the pool
+func (c *ConnPool) Size() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.conns)
+}
+
 func (c *ConnPool) getConn(destination string) (*grpc.ClientConn, bool) {
 	c.Lock()
 	defer c.Unlock()
@@ -39,16 +61,49 @@ func (c *ConnPool) addConn(destination string, conn *grpc.ClientConn) {
 	c.conns[destination] = conn
 }
 
+func (c *ConnPool) getRemoteAddr(destination string) string {
+	c.Lock()
+	defer c.Unlock()
+	return c.remoteAddrs[destination]
+}
+
+// recordingDialer remembers the address of each connection dialled to a destination
+// (which may differ from the destination itself e.g. if it is a hostname)
+func (c *ConnPool) recordingDialer(destination string) contextDialer {
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		conn, err := c.dialer(ctx, address)
+		if err == nil {
+			c.Lock()
+			c.remoteAddrs[destination] = conn.RemoteAddr().String()
+			c.Unlock()
+		}
+		return conn, err
+	}
+}
+
+// GetClientConn returns a connection to the destination, dialling a new one if there isn't one in the pool.
+// Details of the connection are recorded into the Upstream added to ctx by WithUpstream (if any).
 func (c *ConnPool) GetClientConn(ctx context.Context, destination string, dialOptions ...grpc.DialOption) (*grpc.ClientConn, error) {
+	upstream := upstreamFromContext(ctx)
 	conn, ok := c.getConn(destination)
 	if ok {
 		c.logger.Debugf("Returning cached connection to %s", destination)
+		upstream.Address = c.getRemoteAddr(destination)
 		return conn, nil
 	}
 
 	c.logger.Debugf("Dialing new connection to %s", destination)
-	dialOptions = append(dialOptions, grpc.WithContextDialer(c.dialer))
+	dialOptions = append(dialOptions, grpc.WithContextDialer(c.recordingDialer(destination)))
+	start := time.Now()
 	conn, err := grpc.DialContext(ctx, destination, dialOptions...)
+	upstream.Dial = time.Since(start)
+	upstream.Address = c.getRemoteAddr(destination)
+	c.Lock()
+	observer := c.observer
+	c.Unlock()
+	if observer != nil {
+		observer(destination, time.Since(start), err)
+	}
 	if err != nil {
 		c.logger.WithError(err).Debugf("Failed dialing to %s", destination)
 		return nil, fmt.Errorf("failed dialing %s: %v", destination, err)
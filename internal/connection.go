@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+)
+
+// Connection describes how an RPC reached the proxy and where it was forwarded to
+type Connection struct {
+	ClientAddress   string `json:"client_address,omitempty"`
+	UpstreamAddress string `json:"upstream_address,omitempty"`
+	// "grpc" or "grpc-web"
+	Protocol       string `json:"protocol,omitempty"`
+	HTTPVersion    string `json:"http_version,omitempty"`
+	TLSVersion     string `json:"tls_version,omitempty"`
+	TLSCipherSuite string `json:"tls_cipher_suite,omitempty"`
+	// the grpc-encoding used by the client (omitted if messages weren't compressed)
+	Compression string `json:"compression,omitempty"`
+}
+
+const (
+	ProtocolGRPC    = "grpc"
+	ProtocolGRPCWeb = "grpc-web"
+)
+
+// SetTLS records the negotiated TLS parameters of the connection
+func (c *Connection) SetTLS(version, cipherSuite uint16) {
+	c.TLSVersion = tlsVersionName(version)
+	c.TLSCipherSuite = tls.CipherSuiteName(cipherSuite)
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionSSL30:
+		return "SSL 3.0"
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
+type connectionKey struct{}
+
+// WithConnection returns a context carrying the details of the connection that an RPC was received on
+func WithConnection(ctx context.Context, connection *Connection) context.Context {
+	return context.WithValue(ctx, connectionKey{}, connection)
+}
+
+// ConnectionFromContext returns a copy of the connection details added by WithConnection (if any)
+func ConnectionFromContext(ctx context.Context) *Connection {
+	connection := &Connection{}
+	if c, ok := ctx.Value(connectionKey{}).(*Connection); ok {
+		*connection = *c
+	}
+	return connection
+}
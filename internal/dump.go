@@ -2,7 +2,9 @@ package internal
 
 import (
 	"fmt"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"time"
 )
 
@@ -12,6 +14,57 @@ type RPC struct {
 	Messages []*Message  `json:"messages"`
 	Status   *Status     `json:"error,omitempty"`
 	Metadata metadata.MD `json:"metadata"`
+
+	ResponseHeaders  metadata.MD `json:"response_headers,omitempty"`
+	ResponseTrailers metadata.MD `json:"response_trailers,omitempty"`
+
+	Timing     *Timing     `json:"timing,omitempty"`
+	Connection *Connection `json:"connection,omitempty"`
+}
+
+// Timing records when an RPC was made and how long it took.
+// Durations are in milliseconds so that slow RPCs are easy to spot in a dump.
+type Timing struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs float64   `json:"duration_ms"`
+	// time spent dialling a new connection to the server (omitted when an existing connection was reused)
+	DialMs float64 `json:"dial_ms,omitempty"`
+	// time from the start of the RPC until the first server message (omitted when there were no server messages)
+	TimeToFirstResponseMs float64 `json:"time_to_first_response_ms,omitempty"`
+}
+
+// NewTiming calculates the timing of an RPC that started and ended at the given times
+func NewTiming(start, end time.Time, dial time.Duration, messages []*Message) *Timing {
+	timing := &Timing{
+		Start:      start,
+		End:        end,
+		DurationMs: milliseconds(end.Sub(start)),
+		DialMs:     milliseconds(dial),
+	}
+	for _, message := range messages {
+		if message.MessageOrigin == ServerMessage && !message.Timestamp.IsZero() {
+			timing.TimeToFirstResponseMs = milliseconds(message.Timestamp.Sub(start))
+			break
+		}
+	}
+	return timing
+}
+
+// FirstResponse returns the time that the first server message was sent (or the end of the RPC if there were none)
+func (t *Timing) FirstResponse() time.Time {
+	if t.TimeToFirstResponseMs == 0 {
+		return t.End
+	}
+	return t.Start.Add(fromMilliseconds(t.TimeToFirstResponseMs))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func fromMilliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
 }
 
 type Status struct {
@@ -19,6 +72,22 @@ type Status struct {
 	Message string `json:"message"`
 }
 
+// Err converts a recorded status back into a gRPC error.
+// Unrecognised status codes are returned as codes.Unknown.
+func (s *Status) Err() error {
+	if s == nil {
+		return nil
+	}
+	code := codes.Unknown
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if c.String() == s.Code {
+			code = c
+			break
+		}
+	}
+	return status.Error(code, s.Message)
+}
+
 func (r RPC) StreamName() string {
 	return fmt.Sprintf("/%s/%s", r.Service, r.Method)
 }
@@ -35,4 +104,6 @@ type Message struct {
 	RawMessage    []byte        `json:"raw_message,omitempty"`
 	Message       interface{}   `json:"message,omitempty"`
 	Timestamp     time.Time     `json:"timestamp"`
+	// the guessed type (and confidence in the guess) of each field that wasn't in the message's definition
+	UnknownFieldTypes map[string]string `json:"unknown_field_types,omitempty"`
 }
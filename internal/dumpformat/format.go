@@ -0,0 +1,326 @@
+package dumpformat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/dynamic"
+	"io"
+	"unicode"
+)
+
+const (
+	// the tag of the Dump.rpcs field which starts every record in a binary dump
+	rpcsTag = 16<<3 | proto.WireBytes
+	// the first byte of every binary dump: the first byte of rpcsTag as a varint
+	binaryPrefix = 0x82
+)
+
+// well-known types are dynamic messages too so that they are indented like the rest of a text dump
+var messageFactory = dynamic.NewMessageFactoryWithKnownTypeRegistry(dynamic.NewKnownTypeRegistryWithoutWellKnownTypes())
+
+// TextWriter writes RPCs in the protobuf text format of a Dump message (see Schema)
+type TextWriter struct {
+	output io.Writer
+}
+
+// NewTextWriter writes a text dump, including the decoded form of messages
+func NewTextWriter(output io.Writer) *TextWriter {
+	return &TextWriter{output}
+}
+
+func (w *TextWriter) Write(rpc *internal.RPC) error {
+	dump, err := toDump(rpc, true)
+	if err != nil {
+		return err
+	}
+	// parsing JSON creates generated well-known types so round-trip through the binary
+	// format to make everything a dynamic message (which are indented in text dumps)
+	dumpBytes, err := dump.Marshal()
+	if err != nil {
+		return err
+	}
+	dump = messageFactory.NewDynamicMessage(dumpType)
+	if err := dump.Unmarshal(dumpBytes); err != nil {
+		return err
+	}
+	// each RPC is a separate rpcs field so the file is still a valid Dump after every write
+	text, err := dump.MarshalTextIndent()
+	if err != nil {
+		return err
+	}
+	_, err = w.output.Write(append(text, '\n'))
+	return err
+}
+
+// BinaryWriter writes RPCs as a sequence of length delimited RPC records (see Schema)
+type BinaryWriter struct {
+	output io.Writer
+}
+
+// NewBinaryWriter writes a binary dump.
+// The decoded form of messages is only included if the raw message isn't available.
+func NewBinaryWriter(output io.Writer) *BinaryWriter {
+	return &BinaryWriter{output}
+}
+
+func (w *BinaryWriter) Write(rpc *internal.RPC) error {
+	dump, err := toDump(rpc, false)
+	if err != nil {
+		return err
+	}
+	// the binary form of a Dump with a single RPC is its tag, length and then the RPC itself
+	record, err := dump.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = w.output.Write(record)
+	return err
+}
+
+// toDump converts an RPC into a Dump message containing just that RPC
+func toDump(rpc *internal.RPC, includeDecoded bool) (*dynamic.Message, error) {
+	if !includeDecoded {
+		withoutDecoded := *rpc
+		withoutDecoded.Messages = nil
+		for _, message := range rpc.Messages {
+			if message.RawMessage != nil {
+				message = &internal.Message{
+					MessageOrigin: message.MessageOrigin,
+					RawMessage:    message.RawMessage,
+					Timestamp:     message.Timestamp,
+				}
+			}
+			withoutDecoded.Messages = append(withoutDecoded.Messages, message)
+		}
+		rpc = &withoutDecoded
+	}
+
+	// the JSON form of an RPC is the same as the JSON form of the RPC message
+	rpcJSON, err := json.Marshal(rpc)
+	if err != nil {
+		return nil, err
+	}
+	record := messageFactory.NewDynamicMessage(rpcType)
+	if err := record.UnmarshalJSON(rpcJSON); err != nil {
+		return nil, fmt.Errorf("failed to convert %s: %v", rpc.StreamName(), err)
+	}
+	dump := messageFactory.NewDynamicMessage(dumpType)
+	if err := dump.TryAddRepeatedFieldByName("rpcs", record); err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+// Read reads all the RPCs in a dump, detecting whether it is in the JSON, text or binary format
+func Read(input io.Reader) ([]*internal.RPC, error) {
+	reader := NewReader(input)
+	var rpcs []*internal.RPC
+	for {
+		rpc, err := reader.Read()
+		if err == io.EOF {
+			return rpcs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rpcs = append(rpcs, rpc)
+	}
+}
+
+// Reader reads the RPCs in a dump one at a time so that the whole dump doesn't have to fit in memory
+type Reader struct {
+	input *bufio.Reader
+	// reads the next record in the detected format
+	readRecord  func() ([]*internal.RPC, error)
+	jsonDecoder *json.Decoder
+	// RPCs already read but not yet returned (a text record can contain several)
+	pending []*internal.RPC
+}
+
+// NewReader reads a dump, detecting whether it is in the JSON, text or binary format
+func NewReader(input io.Reader) *Reader {
+	return &Reader{input: bufio.NewReader(input)}
+}
+
+// Read returns the next RPC in the dump. It returns io.EOF at the end of the dump
+// and io.ErrUnexpectedEOF if the last record is incomplete (e.g. because the dump
+// is still being written). All the RPCs before an incomplete record can be read.
+func (r *Reader) Read() (*internal.RPC, error) {
+	for len(r.pending) == 0 {
+		if r.readRecord == nil {
+			if err := r.detectFormat(); err != nil {
+				return nil, err
+			}
+		}
+		rpcs, err := r.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		r.pending = rpcs
+	}
+	rpc := r.pending[0]
+	r.pending = r.pending[1:]
+	return rpc, nil
+}
+
+func (r *Reader) detectFormat() error {
+	for {
+		first, err := r.input.ReadByte()
+		if err != nil {
+			return err
+		}
+		if unicode.IsSpace(rune(first)) {
+			continue
+		}
+		if err := r.input.UnreadByte(); err != nil {
+			return err
+		}
+		switch first {
+		case '{':
+			r.jsonDecoder = json.NewDecoder(r.input)
+			r.readRecord = r.readJSON
+		case binaryPrefix:
+			r.readRecord = r.readBinary
+		default:
+			r.readRecord = r.readText
+		}
+		return nil
+	}
+}
+
+// readJSON reads an RPC from a JSON dump: a sequence of JSON objects (usually one per line)
+func (r *Reader) readJSON() ([]*internal.RPC, error) {
+	rpc := &internal.RPC{}
+	err := r.jsonDecoder.Decode(rpc)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode dump: %s", err)
+	}
+	return []*internal.RPC{rpc}, nil
+}
+
+// readBinary reads an RPC from a binary dump: a sequence of length delimited rpcs fields
+func (r *Reader) readBinary() ([]*internal.RPC, error) {
+	tag, err := binary.ReadUvarint(r.input)
+	if err != nil {
+		return nil, err
+	}
+	if tag != rpcsTag {
+		return nil, fmt.Errorf("failed to decode binary dump: unexpected field tag %d", tag)
+	}
+	length, err := binary.ReadUvarint(r.input)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	// copied rather than read into a buffer of the given length so that a corrupt length can't allocate too much
+	contents := &bytes.Buffer{}
+	if _, err := io.CopyN(contents, r.input, int64(length)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	record := messageFactory.NewDynamicMessage(rpcType)
+	if err := proto.Unmarshal(contents.Bytes(), record); err != nil {
+		return nil, fmt.Errorf("failed to decode binary dump: %s", err)
+	}
+	rpc, err := fromRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	return []*internal.RPC{rpc}, nil
+}
+
+// readText reads the RPCs from a text dump up to the next line starting a new rpcs field
+func (r *Reader) readText() ([]*internal.RPC, error) {
+	var contents []byte
+	for {
+		if len(bytes.TrimSpace(contents)) > 0 {
+			if next, _ := r.input.Peek(len("rpcs")); string(next) == "rpcs" {
+				break
+			}
+		}
+		line, err := r.input.ReadBytes('\n')
+		contents = append(contents, line...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(bytes.TrimSpace(contents)) == 0 {
+		return nil, io.EOF
+	}
+
+	dump := messageFactory.NewDynamicMessage(dumpType)
+	if err := dump.UnmarshalText(contents); err != nil {
+		if unterminated(contents) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to decode text dump: %s", err)
+	}
+	return fromDump(dump)
+}
+
+// unterminated checks whether text ends inside a string or before all its messages are closed
+func unterminated(text []byte) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '<' || c == '{':
+			depth++
+		case c == '>' || c == '}':
+			depth--
+		}
+	}
+	return quote != 0 || depth > 0
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// fromDump converts the RPC messages in a Dump back into RPCs
+func fromDump(dump *dynamic.Message) ([]*internal.RPC, error) {
+	var rpcs []*internal.RPC
+	for _, record := range dump.GetFieldByName("rpcs").([]interface{}) {
+		rpc, err := fromRecord(record.(*dynamic.Message))
+		if err != nil {
+			return nil, err
+		}
+		rpcs = append(rpcs, rpc)
+	}
+	return rpcs, nil
+}
+
+// fromRecord converts an RPC message back into an RPC
+func fromRecord(record *dynamic.Message) (*internal.RPC, error) {
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	rpcJSON, err := record.MarshalJSONPB(marshaler)
+	if err != nil {
+		return nil, err
+	}
+	rpc := &internal.RPC{}
+	if err := json.Unmarshal(rpcJSON, rpc); err != nil {
+		return nil, err
+	}
+	return rpc, nil
+}
@@ -0,0 +1,159 @@
+package dumpformat
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadWrittenDumps(t *testing.T) {
+	start := time.Date(2019, 6, 24, 19, 19, 46, 0, time.UTC)
+	rpcs := []*internal.RPC{
+		{
+			Service: "mypackage.Service",
+			Method:  "Method",
+			Messages: []*internal.Message{
+				{MessageOrigin: internal.ClientMessage, RawMessage: []byte{8, 1}, Message: map[string]string{"1": "1"}, Timestamp: start},
+				{MessageOrigin: internal.ServerMessage, Message: map[string]interface{}{"key": "value", "list": []int{1, 2}}, Timestamp: start.Add(10 * time.Millisecond)},
+			},
+			Status:          &internal.Status{Code: "NotFound", Message: "no such thing"},
+			Metadata:        metadata.Pairs("user-agent", "grpc-go/1.23.0", "multi", "a", "multi", "b"),
+			ResponseHeaders: metadata.Pairs("x-request-id", "1"),
+			Timing:          internal.NewTiming(start, start.Add(12500*time.Microsecond), 0, nil),
+			Connection:      &internal.Connection{ClientAddress: "127.0.0.1:52044", Protocol: internal.ProtocolGRPC},
+		},
+		{
+			Service: "mypackage.Service",
+			Method:  "Other",
+		},
+	}
+
+	// the JSON format is used as the reference
+	var expected bytes.Buffer
+	for _, rpc := range rpcs {
+		rpcJSON, _ := json.Marshal(rpc)
+		expected.Write(append(rpcJSON, '\n'))
+	}
+	jsonRPCs, err := Read(bytes.NewReader(expected.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jsonRPCs) != len(rpcs) {
+		t.Fatalf("expected %d RPCs, got %d", len(rpcs), len(jsonRPCs))
+	}
+	// binary dumps don't include the decoded form of messages that have a raw message
+	jsonRPCs[0].Messages[0].Message = nil
+
+	for name, format := range map[string]func(*bytes.Buffer) interface{ Write(*internal.RPC) error }{
+		"text":   func(output *bytes.Buffer) interface{ Write(*internal.RPC) error } { return NewTextWriter(output) },
+		"binary": func(output *bytes.Buffer) interface{ Write(*internal.RPC) error } { return NewBinaryWriter(output) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var output bytes.Buffer
+			writer := format(&output)
+			for _, rpc := range rpcs {
+				if err := writer.Write(rpc); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			read, err := Read(&output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name == "text" {
+				read[0].Messages[0].Message = nil
+			}
+			expectedJSON, _ := json.Marshal(jsonRPCs)
+			actualJSON, _ := json.Marshal(read)
+			if string(expectedJSON) != string(actualJSON) {
+				t.Errorf("expected %s\ngot %s", expectedJSON, actualJSON)
+			}
+		})
+	}
+}
+
+func TestReaderIncompleteRecord(t *testing.T) {
+	rpcs := []*internal.RPC{
+		{Service: "mypackage.Service", Method: "First"},
+		{Service: "mypackage.Service", Method: "Second", Status: &internal.Status{Code: "NotFound", Message: "no such > thing"}},
+	}
+	for name, format := range map[string]func(*bytes.Buffer) interface{ Write(*internal.RPC) error }{
+		"json":   func(output *bytes.Buffer) interface{ Write(*internal.RPC) error } { return jsonWriter{output} },
+		"text":   func(output *bytes.Buffer) interface{ Write(*internal.RPC) error } { return NewTextWriter(output) },
+		"binary": func(output *bytes.Buffer) interface{ Write(*internal.RPC) error } { return NewBinaryWriter(output) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var output bytes.Buffer
+			writer := format(&output)
+			for _, rpc := range rpcs {
+				if err := writer.Write(rpc); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the complete dump is read one RPC at a time
+			reader := NewReader(bytes.NewReader(output.Bytes()))
+			for _, expected := range rpcs {
+				rpc, err := reader.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rpc.Method != expected.Method {
+					t.Errorf("expected %s, got %s", expected.Method, rpc.Method)
+				}
+			}
+			if _, err := reader.Read(); err != io.EOF {
+				t.Errorf("expected EOF, got %v", err)
+			}
+
+			// as if the dump was still being written
+			reader = NewReader(bytes.NewReader(output.Bytes()[:output.Len()-5]))
+			rpc, err := reader.Read()
+			if err != nil || rpc.Method != "First" {
+				t.Fatalf("expected first RPC to be read, got %v (%v)", rpc, err)
+			}
+			if _, err := reader.Read(); err != io.ErrUnexpectedEOF {
+				t.Errorf("expected unexpected EOF for incomplete RPC, got %v", err)
+			}
+			if _, err := Read(bytes.NewReader(output.Bytes()[:output.Len()-5])); err != io.ErrUnexpectedEOF {
+				t.Errorf("expected reading the whole dump to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReaderInvalidRecord(t *testing.T) {
+	for name, dump := range map[string]string{
+		"json":   `{"service": "a"}` + "\n" + `{"service": 5}`,
+		"text":   "rpcs: <\n  service: \"a\"\n>\nrpcs: <\n  unknown: 1\n>\n",
+		"binary": "\x82\x01\x00\x83\x01\x00",
+	} {
+		reader := NewReader(strings.NewReader(dump))
+		if _, err := reader.Read(); err != nil {
+			t.Fatalf("%s: expected first RPC to be read, got %v", name, err)
+		}
+		if _, err := reader.Read(); err == nil || err == io.ErrUnexpectedEOF {
+			t.Errorf("%s: expected invalid record to fail, got %v", name, err)
+		}
+	}
+}
+
+// jsonWriter writes the JSON format in the same way as grpc-dump
+type jsonWriter struct {
+	output *bytes.Buffer
+}
+
+func (w jsonWriter) Write(rpc *internal.RPC) error {
+	rpcJSON, err := json.Marshal(rpc)
+	if err != nil {
+		return err
+	}
+	w.output.Write(append(rpcJSON, '\n'))
+	return nil
+}
@@ -0,0 +1,82 @@
+package dumpformat
+
+import (
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+// Schema is the definition of the records in text and binary dumps.
+// Its JSON form is the same as the JSON dump format.
+const Schema = `syntax = "proto3";
+
+package grpctools.dump;
+
+import "google/protobuf/struct.proto";
+import "google/protobuf/timestamp.proto";
+
+// A dump is a sequence of RPCs.
+// Binary dumps are written one RPC at a time so field 16 is used to make sure that
+// they start with a byte (0x82) which can't appear at the start of a JSON or text dump.
+message Dump {
+    repeated RPC rpcs = 16;
+}
+
+message RPC {
+    string service = 1;
+    string method = 2;
+    repeated Message messages = 3;
+    // present if the gRPC status is not OK
+    Status error = 4;
+    map<string, google.protobuf.ListValue> metadata = 5;
+    map<string, google.protobuf.ListValue> response_headers = 6;
+    map<string, google.protobuf.ListValue> response_trailers = 7;
+    Timing timing = 8;
+    Connection connection = 9;
+}
+
+message Message {
+    // "client" or "server"
+    string message_origin = 1;
+    bytes raw_message = 2;
+    // the JSON form of the decoded message (omitted from binary dumps if the raw message is present)
+    google.protobuf.Value message = 3;
+    google.protobuf.Timestamp timestamp = 4;
+}
+
+message Status {
+    string code = 1;
+    string message = 2;
+}
+
+message Timing {
+    google.protobuf.Timestamp start = 1;
+    google.protobuf.Timestamp end = 2;
+    double duration_ms = 3;
+    double dial_ms = 4;
+    double time_to_first_response_ms = 5;
+}
+
+message Connection {
+    string client_address = 1;
+    string upstream_address = 2;
+    string protocol = 3;
+    string http_version = 4;
+    string tls_version = 5;
+    string tls_cipher_suite = 6;
+    string compression = 7;
+}
+`
+
+var dumpType, rpcType *desc.MessageDescriptor
+
+func init() {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"grpctools/dump.proto": Schema}),
+	}
+	files, err := parser.ParseFiles("grpctools/dump.proto")
+	if err != nil {
+		panic(err)
+	}
+	dumpType = files[0].FindMessage("grpctools.dump.Dump")
+	rpcType = files[0].FindMessage("grpctools.dump.RPC")
+}
@@ -0,0 +1,317 @@
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/marker"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Types for the subset of HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/) produced from gRPC dumps.
+// gRPC details that don't map onto HTTP are included as custom fields (prefixed with an underscore).
+
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+type Log struct {
+	Version string   `json:"version"`
+	Creator Creator  `json:"creator"`
+	Entries []*Entry `json:"entries"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+	ServerIPAddress string    `json:"serverIPAddress,omitempty"`
+	GRPC            GRPC      `json:"_grpc"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type Timings struct {
+	Connect float64 `json:"connect,omitempty"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type GRPC struct {
+	Service  string              `json:"service"`
+	Method   string              `json:"method"`
+	Status   *internal.Status    `json:"status,omitempty"`
+	Messages []*internal.Message `json:"messages"`
+
+	Connection *internal.Connection `json:"connection,omitempty"`
+}
+
+func NewHAR(entries ...*Entry) *HAR {
+	return &HAR{
+		Log: Log{
+			Version: "1.2",
+			Creator: Creator{Name: "grpc-tools", Version: "1.0"},
+			Entries: append([]*Entry{}, entries...),
+		},
+	}
+}
+
+// FromRPC converts a recorded RPC into a HAR entry.
+// Messages are included as a JSON array of the decoded messages
+// (or the base64 encoded raw messages if they could not be decoded).
+func FromRPC(rpc *internal.RPC) (*Entry, error) {
+	var start, end, firstResponse time.Time
+	var requestMessages, responseMessages []interface{}
+	var requestSize, responseSize int
+	for _, message := range rpc.Messages {
+		if start.IsZero() || message.Timestamp.Before(start) {
+			start = message.Timestamp
+		}
+		if message.Timestamp.After(end) {
+			end = message.Timestamp
+		}
+		switch message.MessageOrigin {
+		case internal.ClientMessage:
+			requestMessages = append(requestMessages, messageContents(message))
+			requestSize += len(message.RawMessage)
+		case internal.ServerMessage:
+			if firstResponse.IsZero() {
+				firstResponse = message.Timestamp
+			}
+			responseMessages = append(responseMessages, messageContents(message))
+			responseSize += len(message.RawMessage)
+		}
+	}
+	if start.IsZero() {
+		start = time.Now()
+		end = start
+	}
+	if firstResponse.IsZero() {
+		firstResponse = end
+	}
+	var connect float64
+	if rpc.Timing != nil {
+		// recorded timings also cover RPCs without messages and the time before the first message
+		start, end, firstResponse = rpc.Timing.Start, rpc.Timing.End, rpc.Timing.FirstResponse()
+		connect = rpc.Timing.DialMs
+	}
+
+	requestText, err := json.Marshal(requestMessages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request messages: %v", err)
+	}
+	responseText, err := json.Marshal(responseMessages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response messages: %v", err)
+	}
+
+	scheme := "http"
+	if marker.IsTLSRPC(rpc.Metadata) {
+		scheme = "https"
+	}
+	authority := ""
+	if values := rpc.Metadata.Get(":authority"); len(values) > 0 {
+		authority = values[0]
+	}
+	grpcStatus, grpcMessage := "OK", ""
+	if rpc.Status != nil {
+		grpcStatus, grpcMessage = rpc.Status.Code, rpc.Status.Message
+	}
+	httpVersion, serverIP := "HTTP/2.0", ""
+	if rpc.Connection != nil {
+		if rpc.Connection.HTTPVersion != "" {
+			httpVersion = rpc.Connection.HTTPVersion
+		}
+		serverIP, _, _ = net.SplitHostPort(rpc.Connection.UpstreamAddress)
+	}
+	responseHeaders := append(nameValues(rpc.ResponseHeaders), nameValues(rpc.ResponseTrailers)...)
+	responseHeaders = append(responseHeaders, NameValue{"grpc-status", grpcStatus})
+	if grpcMessage != "" {
+		responseHeaders = append(responseHeaders, NameValue{"grpc-message", grpcMessage})
+	}
+
+	return &Entry{
+		StartedDateTime: start,
+		Time:            milliseconds(end.Sub(start)),
+		Request: Request{
+			Method:      "POST",
+			URL:         fmt.Sprintf("%s://%s%s", scheme, authority, rpc.StreamName()),
+			HTTPVersion: httpVersion,
+			Cookies:     []NameValue{},
+			Headers:     nameValues(rpc.Metadata),
+			QueryString: []NameValue{},
+			PostData: &PostData{
+				MimeType: "application/json",
+				Text:     string(requestText),
+			},
+			HeadersSize: -1,
+			BodySize:    requestSize,
+		},
+		Response: Response{
+			Status:      200,
+			StatusText:  "OK",
+			HTTPVersion: httpVersion,
+			Cookies:     []NameValue{},
+			Headers:     responseHeaders,
+			Content: Content{
+				Size:     responseSize,
+				MimeType: "application/json",
+				Text:     string(responseText),
+			},
+			HeadersSize: -1,
+			BodySize:    responseSize,
+		},
+		Timings: Timings{
+			Connect: connect,
+			Send:    0,
+			Wait:    milliseconds(firstResponse.Sub(start)) - connect,
+			Receive: milliseconds(end.Sub(firstResponse)),
+		},
+		ServerIPAddress: serverIP,
+		GRPC: GRPC{
+			Service:    rpc.Service,
+			Method:     rpc.Method,
+			Status:     rpc.Status,
+			Messages:   rpc.Messages,
+			Connection: rpc.Connection,
+		},
+	}, nil
+}
+
+func messageContents(message *internal.Message) interface{} {
+	if message.Message != nil {
+		return message.Message
+	}
+	return base64.StdEncoding.EncodeToString(message.RawMessage)
+}
+
+func nameValues(md map[string][]string) []NameValue {
+	var keys []string
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []NameValue{}
+	for _, key := range keys {
+		for _, value := range md[key] {
+			pairs = append(pairs, NameValue{key, value})
+		}
+	}
+	return pairs
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// FileWriter maintains a HAR file containing every RPC written to it.
+// Each entry is written in place of the closing brackets of the document (which are then written again after it)
+// so the file is valid after every RPC without the previous entries having to be kept or rewritten.
+type FileWriter struct {
+	sync.Mutex
+	file *os.File
+	// offset is where the closing brackets of the document start
+	offset  int64
+	suffix  []byte
+	entries int
+}
+
+func NewFileWriter(path string) (*FileWriter, error) {
+	empty, err := json.Marshal(NewHAR())
+	if err != nil {
+		return nil, err
+	}
+	// split the empty document either side of the entries array
+	split := bytes.LastIndex(empty, []byte("[]")) + 1
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(empty); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileWriter{
+		file:   file,
+		offset: int64(split),
+		suffix: empty[split:],
+	}, nil
+}
+
+func (w *FileWriter) Write(rpc *internal.RPC) error {
+	entry, err := FromRPC(rpc)
+	if err != nil {
+		return err
+	}
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if w.entries > 0 {
+		contents = append([]byte{','}, contents...)
+	}
+	if _, err := w.file.WriteAt(append(contents, w.suffix...), w.offset); err != nil {
+		return err
+	}
+	w.offset += int64(len(contents))
+	w.entries++
+	return nil
+}
@@ -0,0 +1,124 @@
+package har
+
+import (
+	"encoding/json"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromRPC(t *testing.T) {
+	start := time.Date(2019, 6, 24, 19, 19, 46, 0, time.UTC)
+	rpc := &internal.RPC{
+		Service: "mypackage.Service",
+		Method:  "Method",
+		Messages: []*internal.Message{
+			{MessageOrigin: internal.ClientMessage, RawMessage: []byte{1, 2}, Timestamp: start},
+			{MessageOrigin: internal.ServerMessage, Message: map[string]string{"key": "value"}, RawMessage: []byte{3}, Timestamp: start.Add(10 * time.Millisecond)},
+		},
+		Status: &internal.Status{
+			Code:    "NotFound",
+			Message: "no such thing",
+		},
+		Metadata:         metadata.Pairs(":authority", "example.com:443"),
+		ResponseTrailers: metadata.Pairs("trailer", "value"),
+	}
+
+	entry, err := FromRPC(rpc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Request.URL != "http://example.com:443/mypackage.Service/Method" {
+		t.Errorf("unexpected url %s", entry.Request.URL)
+	}
+	if entry.Request.PostData.Text != `["AQI="]` {
+		t.Errorf("unexpected request body %s", entry.Request.PostData.Text)
+	}
+	if entry.Response.Content.Text != `[{"key":"value"}]` {
+		t.Errorf("unexpected response body %s", entry.Response.Content.Text)
+	}
+	expectedHeaders := []NameValue{{"trailer", "value"}, {"grpc-status", "NotFound"}, {"grpc-message", "no such thing"}}
+	if len(entry.Response.Headers) != len(expectedHeaders) {
+		t.Fatalf("unexpected response headers %v", entry.Response.Headers)
+	}
+	for i, header := range expectedHeaders {
+		if entry.Response.Headers[i] != header {
+			t.Errorf("expected header %v, got %v", header, entry.Response.Headers[i])
+		}
+	}
+	if entry.Time != 10 || entry.Timings.Wait != 10 {
+		t.Errorf("unexpected timings %v %v", entry.Time, entry.Timings)
+	}
+}
+
+func TestFromRPCWithTimingAndConnection(t *testing.T) {
+	start := time.Date(2019, 6, 24, 19, 19, 46, 0, time.UTC)
+	response := &internal.Message{MessageOrigin: internal.ServerMessage, RawMessage: []byte{3}, Timestamp: start.Add(30 * time.Millisecond)}
+	rpc := &internal.RPC{
+		Service:  "mypackage.Service",
+		Method:   "Method",
+		Messages: []*internal.Message{response},
+		Metadata: metadata.MD{},
+		Connection: &internal.Connection{
+			UpstreamAddress: "10.0.0.2:443",
+			HTTPVersion:     "HTTP/1.1",
+		},
+		Timing: internal.NewTiming(start, start.Add(50*time.Millisecond), 20*time.Millisecond, []*internal.Message{response}),
+	}
+
+	entry, err := FromRPC(rpc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.StartedDateTime.Equal(start) || entry.Time != 50 {
+		t.Errorf("unexpected start %v and time %v", entry.StartedDateTime, entry.Time)
+	}
+	if entry.Timings != (Timings{Connect: 20, Wait: 10, Receive: 20}) {
+		t.Errorf("unexpected timings %v", entry.Timings)
+	}
+	if entry.ServerIPAddress != "10.0.0.2" || entry.Request.HTTPVersion != "HTTP/1.1" {
+		t.Errorf("unexpected connection details %s %s", entry.ServerIPAddress, entry.Request.HTTPVersion)
+	}
+}
+
+func TestFileWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dump.har")
+	w, err := NewFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is a complete HAR document after every RPC
+	for i, method := range []string{"", "First", "Second"} {
+		if method != "" {
+			rpc := &internal.RPC{Service: "mypackage.Service", Method: method}
+			if err := w.Write(rpc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var har HAR
+		if err := json.Unmarshal(contents, &har); err != nil {
+			t.Fatalf("invalid HAR after %d RPCs: %v\n%s", i, err, contents)
+		}
+		if len(har.Log.Entries) != i {
+			t.Fatalf("expected %d entries, got %d", i, len(har.Log.Entries))
+		}
+		if i > 0 && !strings.HasSuffix(har.Log.Entries[i-1].Request.URL, method) {
+			t.Errorf("unexpected entry %v", har.Log.Entries[i-1].Request.URL)
+		}
+	}
+}
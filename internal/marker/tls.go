@@ -18,6 +18,12 @@ func AddHTTPSMarker(header http.Header) {
 	header.Add(forwardedHeader, httpsProto)
 }
 
+// MarkTLSRPC adds the marker to an RPC's metadata for RPCs that were
+// made over TLS but didn't pass through the proxy (e.g. imported from a packet capture)
+func MarkTLSRPC(md metadata.MD) {
+	md.Append(forwardedHeader, httpsProto)
+}
+
 func RemoveHTTPSMarker(md metadata.MD) {
 	// TODO: what if the request was genuinely forwarded before reaching grpc-dump?
 	// in that case we'll be deleting information here that wasn't added by grpc-dump
@@ -0,0 +1,159 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize     = 512
+	batchInterval = time.Second
+)
+
+// Exporter sends RPCs as spans to an OTLP/HTTP collector (e.g. Jaeger or the OpenTelemetry Collector).
+// Spans are sent in the background in batches so that slow collectors don't slow down the proxy.
+type Exporter struct {
+	endpoint string
+	rpcs     chan *internal.RPC
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	// closed once the final batch has been sent
+	closed chan struct{}
+	// the error sending the final batch
+	err error
+}
+
+// NewExporter creates an exporter sending to the given collector endpoint e.g. http://localhost:4318
+func NewExporter(endpoint string) (*Exporter, error) {
+	endpoint, err := tracesURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	e := &Exporter{
+		endpoint: endpoint,
+		rpcs:     make(chan *internal.RPC, batchSize*4),
+		closing:  make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+func (e *Exporter) Write(rpc *internal.RPC) error {
+	select {
+	case <-e.closing:
+		return errors.New("OTLP exporter is closed, dropping span")
+	default:
+	}
+	select {
+	case e.rpcs <- rpc:
+		return nil
+	default:
+		return errors.New("OTLP export queue is full, dropping span")
+	}
+}
+
+// Close sends the spans that are still queued and stops the exporter
+func (e *Exporter) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.closing)
+	})
+	<-e.closed
+	return e.err
+}
+
+func (e *Exporter) run() {
+	var batch []*internal.RPC
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case rpc := <-e.rpcs:
+			batch = append(batch, rpc)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-e.closing:
+			e.err = e.drain(batch)
+			close(e.closed)
+			return
+		}
+		if err := Export(e.endpoint, FromRPCs(batch...)); err != nil {
+			log.Println("Failed to export spans:", err)
+		}
+		batch = nil
+	}
+}
+
+// drain sends the batch along with everything still in the queue
+func (e *Exporter) drain(batch []*internal.RPC) error {
+	var err error
+	for {
+		select {
+		case rpc := <-e.rpcs:
+			batch = append(batch, rpc)
+			if len(batch) < batchSize {
+				continue
+			}
+		default:
+			if len(batch) == 0 {
+				return err
+			}
+		}
+		if exportErr := Export(e.endpoint, FromRPCs(batch...)); exportErr != nil {
+			err = exportErr
+		}
+		batch = nil
+	}
+}
+
+// Export sends spans to an OTLP/HTTP collector endpoint
+func Export(endpoint string, data *TracesData) error {
+	endpoint, err := tracesURL(endpoint)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, message)
+	}
+	return nil
+}
+
+// tracesURL adds the default OTLP/HTTP traces path to an endpoint if it doesn't already have a path
+func tracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid OTLP endpoint %s: must be a URL such as http://localhost:4318", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,263 @@
+// Package otlp converts recorded RPCs into OpenTelemetry spans encoded as OTLP/JSON
+// (https://github.com/open-telemetry/opentelemetry-proto/blob/main/docs/specification.md#json-protobuf-encoding).
+package otlp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types for the subset of an OTLP ExportTraceServiceRequest produced from gRPC dumps
+
+type TracesData struct {
+	ResourceSpans []*ResourceSpans `json:"resourceSpans"`
+}
+
+type ResourceSpans struct {
+	Resource   Resource      `json:"resource"`
+	ScopeSpans []*ScopeSpans `json:"scopeSpans"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeSpans struct {
+	Scope Scope   `json:"scope"`
+	Spans []*Span `json:"spans"`
+}
+
+type Scope struct {
+	Name string `json:"name"`
+}
+
+type Span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes"`
+	Events            []Event    `json:"events,omitempty"`
+	Status            Status     `json:"status"`
+}
+
+type Event struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []KeyValue `json:"attributes"`
+}
+
+type Status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+type AnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	// 64 bit integers are encoded as strings in OTLP/JSON
+	IntValue *string `json:"intValue,omitempty"`
+}
+
+const (
+	spanKindClient      = 3
+	statusCodeError     = 2
+	instrumentationName = "github.com/bradleyjkemp/grpc-tools"
+)
+
+func stringAttribute(key, value string) KeyValue {
+	return KeyValue{key, AnyValue{StringValue: &value}}
+}
+
+func intAttribute(key string, value int64) KeyValue {
+	s := strconv.FormatInt(value, 10)
+	return KeyValue{key, AnyValue{IntValue: &s}}
+}
+
+func unixNano(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// FromRPCs converts RPCs into spans, grouped into a resource for each gRPC service.
+// Each RPC is a separate trace unless the request had a W3C traceparent header
+// in which case the span is made a child of the caller's span.
+func FromRPCs(rpcs ...*internal.RPC) *TracesData {
+	services := map[string]*ScopeSpans{}
+	data := &TracesData{
+		ResourceSpans: []*ResourceSpans{},
+	}
+	for _, rpc := range rpcs {
+		scope := services[rpc.Service]
+		if scope == nil {
+			scope = &ScopeSpans{
+				Scope: Scope{Name: instrumentationName},
+			}
+			services[rpc.Service] = scope
+			data.ResourceSpans = append(data.ResourceSpans, &ResourceSpans{
+				Resource: Resource{
+					Attributes: []KeyValue{stringAttribute("service.name", rpc.Service)},
+				},
+				ScopeSpans: []*ScopeSpans{scope},
+			})
+		}
+		scope.Spans = append(scope.Spans, FromRPC(rpc))
+	}
+	return data
+}
+
+// FromRPC converts an RPC into a span with an event for each message, following
+// the OpenTelemetry semantic conventions for gRPC (https://opentelemetry.io/docs/specs/semconv/rpc/grpc/).
+func FromRPC(rpc *internal.RPC) *Span {
+	start, end := timeRange(rpc)
+	span := &Span{
+		SpanID:            randomID(8),
+		Name:              strings.TrimPrefix(rpc.StreamName(), "/"),
+		Kind:              spanKindClient,
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(end),
+	}
+	if traceID, parentID, ok := traceParent(rpc); ok {
+		span.TraceID, span.ParentSpanID = traceID, parentID
+	} else {
+		span.TraceID = randomID(16)
+	}
+
+	code := codes.OK
+	if rpc.Status != nil {
+		code = status.Code(rpc.Status.Err())
+		span.Status = Status{Code: statusCodeError, Message: rpc.Status.Message}
+	}
+	span.Attributes = []KeyValue{
+		stringAttribute("rpc.system", "grpc"),
+		stringAttribute("rpc.service", rpc.Service),
+		stringAttribute("rpc.method", rpc.Method),
+		intAttribute("rpc.grpc.status_code", int64(code)),
+	}
+	if authority := rpc.Metadata.Get(":authority"); len(authority) > 0 {
+		host, port, err := net.SplitHostPort(authority[0])
+		if err != nil {
+			host, port = authority[0], ""
+		}
+		span.Attributes = append(span.Attributes, stringAttribute("server.address", host))
+		if portNumber, err := strconv.Atoi(port); err == nil {
+			span.Attributes = append(span.Attributes, intAttribute("server.port", int64(portNumber)))
+		}
+	}
+	if userAgent := rpc.Metadata.Get("user-agent"); len(userAgent) > 0 {
+		span.Attributes = append(span.Attributes, stringAttribute("user_agent.original", userAgent[0]))
+	}
+	if rpc.Connection != nil {
+		span.Attributes = append(span.Attributes, connectionAttributes(rpc.Connection)...)
+	}
+
+	// message IDs are counted separately for each direction
+	ids := map[internal.MessageOrigin]int64{}
+	for _, message := range rpc.Messages {
+		messageType := "RECEIVED"
+		if message.MessageOrigin == internal.ClientMessage {
+			messageType = "SENT"
+		}
+		ids[message.MessageOrigin]++
+		span.Events = append(span.Events, Event{
+			TimeUnixNano: unixNano(message.Timestamp),
+			Name:         "message",
+			Attributes: []KeyValue{
+				stringAttribute("message.type", messageType),
+				intAttribute("message.id", ids[message.MessageOrigin]),
+				intAttribute("message.uncompressed_size", int64(len(message.RawMessage))),
+			},
+		})
+	}
+	return span
+}
+
+func connectionAttributes(connection *internal.Connection) []KeyValue {
+	var attributes []KeyValue
+	attributes = append(attributes, addressAttributes("client", connection.ClientAddress)...)
+	attributes = append(attributes, addressAttributes("network.peer", connection.UpstreamAddress)...)
+	if version := strings.TrimPrefix(connection.HTTPVersion, "HTTP/"); version != connection.HTTPVersion {
+		attributes = append(attributes, stringAttribute("network.protocol.name", "http"), stringAttribute("network.protocol.version", strings.TrimSuffix(version, ".0")))
+	}
+	if version := strings.TrimPrefix(connection.TLSVersion, "TLS "); version != connection.TLSVersion {
+		attributes = append(attributes, stringAttribute("tls.protocol.name", "tls"), stringAttribute("tls.protocol.version", version))
+	}
+	if connection.TLSCipherSuite != "" {
+		attributes = append(attributes, stringAttribute("tls.cipher", connection.TLSCipherSuite))
+	}
+	return attributes
+}
+
+// addressAttributes splits an address into e.g. client.address and client.port attributes
+func addressAttributes(prefix, address string) []KeyValue {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	attributes := []KeyValue{stringAttribute(prefix+".address", host)}
+	if portNumber, err := strconv.Atoi(port); err == nil {
+		attributes = append(attributes, intAttribute(prefix+".port", int64(portNumber)))
+	}
+	return attributes
+}
+
+func timeRange(rpc *internal.RPC) (start, end time.Time) {
+	if rpc.Timing != nil {
+		return rpc.Timing.Start, rpc.Timing.End
+	}
+	var timestamps []time.Time
+	for _, message := range rpc.Messages {
+		if !message.Timestamp.IsZero() {
+			timestamps = append(timestamps, message.Timestamp)
+		}
+	}
+	if len(timestamps) == 0 {
+		now := time.Now()
+		return now, now
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+	return timestamps[0], timestamps[len(timestamps)-1]
+}
+
+// traceParent extracts the trace and parent span IDs from a W3C traceparent header
+// (https://www.w3.org/TR/trace-context/#traceparent-header) e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func traceParent(rpc *internal.RPC) (traceID, parentID string, ok bool) {
+	values := rpc.Metadata.Get("traceparent")
+	if len(values) == 0 {
+		return "", "", false
+	}
+	parts := strings.Split(values[0], "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return "", "", false
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), true
+}
+
+func randomID(length int) string {
+	id := make([]byte, length)
+	// crypto/rand only fails if the OS has no source of randomness
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,135 @@
+package otlp
+
+import (
+	"encoding/json"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFromRPC(t *testing.T) {
+	start := time.Unix(1561403986, 0)
+	rpc := &internal.RPC{
+		Service: "mypackage.Service",
+		Method:  "Method",
+		Messages: []*internal.Message{
+			{MessageOrigin: internal.ClientMessage, RawMessage: []byte{1, 2}, Timestamp: start},
+			{MessageOrigin: internal.ServerMessage, RawMessage: []byte{3}, Timestamp: start.Add(time.Second)},
+		},
+		Status: &internal.Status{Code: "NotFound", Message: "no such thing"},
+		Metadata: metadata.Pairs(
+			":authority", "example.com:443",
+			"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		),
+		Connection: &internal.Connection{
+			ClientAddress:   "127.0.0.1:50000",
+			UpstreamAddress: "10.0.0.2:443",
+			HTTPVersion:     "HTTP/2.0",
+			TLSVersion:      "TLS 1.3",
+		},
+	}
+
+	span := FromRPC(rpc)
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("expected span to continue the trace from traceparent, got %s %s", span.TraceID, span.ParentSpanID)
+	}
+	if span.StartTimeUnixNano != "1561403986000000000" || span.EndTimeUnixNano != "1561403987000000000" {
+		t.Errorf("unexpected span times %s %s", span.StartTimeUnixNano, span.EndTimeUnixNano)
+	}
+	if span.Status.Code != statusCodeError || span.Status.Message != "no such thing" {
+		t.Errorf("unexpected status %v", span.Status)
+	}
+
+	attributes := map[string]string{}
+	for _, attribute := range span.Attributes {
+		if attribute.Value.StringValue != nil {
+			attributes[attribute.Key] = *attribute.Value.StringValue
+		} else {
+			attributes[attribute.Key] = *attribute.Value.IntValue
+		}
+	}
+	expected := map[string]string{
+		"rpc.system":               "grpc",
+		"rpc.service":              "mypackage.Service",
+		"rpc.method":               "Method",
+		"rpc.grpc.status_code":     "5",
+		"server.address":           "example.com",
+		"server.port":              "443",
+		"client.address":           "127.0.0.1",
+		"network.peer.address":     "10.0.0.2",
+		"network.peer.port":        "443",
+		"network.protocol.version": "2",
+		"tls.protocol.version":     "1.3",
+	}
+	for key, value := range expected {
+		if attributes[key] != value {
+			t.Errorf("expected attribute %s=%s, got %s", key, value, attributes[key])
+		}
+	}
+	if len(span.Events) != 2 || *span.Events[0].Attributes[0].Value.StringValue != "SENT" || *span.Events[1].Attributes[0].Value.StringValue != "RECEIVED" {
+		t.Errorf("unexpected events %v", span.Events)
+	}
+}
+
+func TestExport(t *testing.T) {
+	var received TracesData
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer collector.Close()
+
+	err := Export(collector.URL, FromRPCs(&internal.RPC{Service: "a.Service", Method: "Method"}, &internal.RPC{Service: "b.Service", Method: "Method"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received.ResourceSpans) != 2 {
+		t.Errorf("expected a resource for each service, got %d", len(received.ResourceSpans))
+	}
+
+	if err := Export(collector.URL+"/wrong/path", FromRPCs()); err == nil {
+		t.Error("expected error from collector")
+	}
+}
+
+func TestExporter_Close(t *testing.T) {
+	var received TracesData
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer collector.Close()
+
+	exporter, err := NewExporter(collector.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := exporter.Write(&internal.RPC{Service: "a.Service", Method: "Method"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the batch is sent straight away rather than waiting for the batch interval
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(received.ResourceSpans) != 1 || len(received.ResourceSpans[0].ScopeSpans[0].Spans) != 3 {
+		t.Errorf("expected the queued spans to be sent, got %+v", received)
+	}
+
+	if err := exporter.Write(&internal.RPC{Service: "a.Service", Method: "Method"}); err == nil {
+		t.Error("expected writing to a closed exporter to fail")
+	}
+}
@@ -0,0 +1,255 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// Link layer types (http://www.tcpdump.org/linktypes.html) that packets can be decoded from
+const (
+	linkTypeNull      = 0
+	linkTypeEthernet  = 1
+	linkTypeRaw       = 101
+	linkTypeLoop      = 108
+	linkTypeLinuxSLL  = 113
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+const (
+	pcapMagicMicroseconds = 0xa1b2c3d4
+	pcapMagicNanoseconds  = 0xa1b23c4d
+
+	pcapngSectionHeader         = 0x0a0d0d0a
+	pcapngInterfaceDescription  = 0x00000001
+	pcapngSimplePacket          = 0x00000003
+	pcapngEnhancedPacket        = 0x00000006
+	pcapngDecryptionSecrets     = 0x0000000a
+	pcapngByteOrderMagic        = 0x1a2b3c4d
+	pcapngInterfaceTimestampRes = 9
+	pcapngTLSKeyLog             = 0x544c534b
+)
+
+type packet struct {
+	timestamp time.Time
+	linkType  uint32
+	data      []byte
+}
+
+// captureFile is the contents of a pcap or pcapng file
+type captureFile struct {
+	packets []packet
+	// TLS secrets embedded in a pcapng Decryption Secrets Block
+	keyLog []byte
+}
+
+// readCaptureFile reads a pcap or pcapng file, detecting the format from its magic number
+func readCaptureFile(r io.Reader) (*captureFile, error) {
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture file: %v", err)
+	}
+
+	if binary.LittleEndian.Uint32(magic) == pcapngSectionHeader {
+		return readPcapng(reader)
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(magic) {
+		case pcapMagicMicroseconds:
+			return readPcap(reader, order, time.Microsecond)
+		case pcapMagicNanoseconds:
+			return readPcap(reader, order, time.Nanosecond)
+		}
+	}
+	return nil, fmt.Errorf("not a pcap or pcapng file (magic number %x)", magic)
+}
+
+func readPcap(r io.Reader, order binary.ByteOrder, resolution time.Duration) (*captureFile, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %v", err)
+	}
+	linkType := order.Uint32(header[20:24]) & 0x0fffffff
+
+	file := &captureFile{}
+	recordHeader := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, recordHeader); err != nil {
+			// a truncated capture (e.g. tcpdump was killed) is still useful so ignore any partial record
+			return file, nil
+		}
+		seconds := order.Uint32(recordHeader[0:4])
+		fraction := order.Uint32(recordHeader[4:8])
+		length := order.Uint32(recordHeader[8:12])
+		if length > 1<<26 {
+			return nil, fmt.Errorf("invalid pcap record length %d", length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return file, nil
+		}
+		file.packets = append(file.packets, packet{
+			timestamp: time.Unix(int64(seconds), int64(fraction)*int64(resolution)),
+			linkType:  linkType,
+			data:      data,
+		})
+	}
+}
+
+type pcapngInterface struct {
+	linkType uint32
+	// number of timestamp units per second
+	unitsPerSecond uint64
+}
+
+func readPcapng(r io.Reader) (*captureFile, error) {
+	file := &captureFile{}
+	var order binary.ByteOrder = binary.LittleEndian
+	var interfaces []pcapngInterface
+
+	blockHeader := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, blockHeader); err != nil {
+			// ignore any partial block at the end of a truncated capture
+			return file, nil
+		}
+
+		blockType := order.Uint32(blockHeader[0:4])
+		if binary.LittleEndian.Uint32(blockHeader[0:4]) == pcapngSectionHeader {
+			// each section can have a different byte order so it must be detected before reading the length
+			byteOrderMagic := make([]byte, 4)
+			if _, err := io.ReadFull(r, byteOrderMagic); err != nil {
+				return nil, fmt.Errorf("failed to read pcapng section header: %v", err)
+			}
+			switch {
+			case binary.LittleEndian.Uint32(byteOrderMagic) == pcapngByteOrderMagic:
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint32(byteOrderMagic) == pcapngByteOrderMagic:
+				order = binary.BigEndian
+			default:
+				return nil, errors.New("invalid pcapng byte order magic")
+			}
+			blockType = pcapngSectionHeader
+			interfaces = nil
+		}
+
+		length := order.Uint32(blockHeader[4:8])
+		bodyLength := int64(length) - 12
+		if blockType == pcapngSectionHeader {
+			bodyLength -= 4
+		}
+		if bodyLength < 0 || length > 1<<26 {
+			return nil, fmt.Errorf("invalid pcapng block length %d", length)
+		}
+		body := make([]byte, bodyLength+4) // includes the trailing copy of the block length
+		if _, err := io.ReadFull(r, body); err != nil {
+			return file, nil
+		}
+		body = body[:bodyLength]
+
+		switch blockType {
+		case pcapngInterfaceDescription:
+			if len(body) < 8 {
+				return nil, errors.New("invalid pcapng interface description block")
+			}
+			iface := pcapngInterface{
+				linkType:       uint32(order.Uint16(body[0:2])),
+				unitsPerSecond: 1e6, // microseconds by default
+			}
+			forEachOption(body[8:], order, func(code uint16, value []byte) {
+				if code != pcapngInterfaceTimestampRes || len(value) == 0 {
+					return
+				}
+				// the most significant bit selects between a power of 10 and a power of 2
+				exponent := uint(value[0] & 0x7f)
+				if value[0]&0x80 == 0 && exponent <= 19 {
+					iface.unitsPerSecond = pow10(exponent)
+				} else if value[0]&0x80 != 0 && exponent <= 63 {
+					iface.unitsPerSecond = 1 << exponent
+				}
+			})
+			interfaces = append(interfaces, iface)
+
+		case pcapngEnhancedPacket:
+			if len(body) < 20 {
+				return nil, errors.New("invalid pcapng enhanced packet block")
+			}
+			interfaceID := order.Uint32(body[0:4])
+			if int(interfaceID) >= len(interfaces) {
+				return nil, fmt.Errorf("pcapng packet refers to unknown interface %d", interfaceID)
+			}
+			iface := interfaces[interfaceID]
+			timestamp := uint64(order.Uint32(body[4:8]))<<32 | uint64(order.Uint32(body[8:12]))
+			capturedLength := order.Uint32(body[12:16])
+			if int(capturedLength) > len(body)-20 {
+				return nil, errors.New("invalid pcapng enhanced packet length")
+			}
+			file.packets = append(file.packets, packet{
+				timestamp: iface.timestamp(timestamp),
+				linkType:  iface.linkType,
+				data:      body[20 : 20+capturedLength],
+			})
+
+		case pcapngSimplePacket:
+			if len(body) < 4 || len(interfaces) == 0 {
+				return nil, errors.New("invalid pcapng simple packet block")
+			}
+			// simple packets don't have a timestamp
+			file.packets = append(file.packets, packet{
+				linkType: interfaces[0].linkType,
+				data:     body[4:],
+			})
+
+		case pcapngDecryptionSecrets:
+			if len(body) < 8 {
+				return nil, errors.New("invalid pcapng decryption secrets block")
+			}
+			secretsType := order.Uint32(body[0:4])
+			secretsLength := order.Uint32(body[4:8])
+			if secretsType == pcapngTLSKeyLog && int(secretsLength) <= len(body)-8 {
+				file.keyLog = append(file.keyLog, body[8:8+secretsLength]...)
+				file.keyLog = append(file.keyLog, '\n')
+			}
+		}
+	}
+}
+
+func (i pcapngInterface) timestamp(units uint64) time.Time {
+	seconds := units / i.unitsPerSecond
+	// remainder * 1e9 can overflow 64 bits for high resolution timestamps
+	hi, lo := bits.Mul64(units%i.unitsPerSecond, 1e9)
+	nanos, _ := bits.Div64(hi, lo, i.unitsPerSecond)
+	return time.Unix(int64(seconds), int64(nanos))
+}
+
+func pow10(exponent uint) uint64 {
+	result := uint64(1)
+	for ; exponent > 0; exponent-- {
+		result *= 10
+	}
+	return result
+}
+
+func forEachOption(options []byte, order binary.ByteOrder, fn func(code uint16, value []byte)) {
+	for len(options) >= 4 {
+		code := order.Uint16(options[0:2])
+		length := int(order.Uint16(options[2:4]))
+		if code == 0 || 4+length > len(options) {
+			return
+		}
+		fn(code, options[4:4+length])
+		// options are padded to 32 bits
+		padded := 4 + (length+3)&^3
+		if padded > len(options) {
+			return
+		}
+		options = options[padded:]
+	}
+}
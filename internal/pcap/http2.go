@@ -0,0 +1,391 @@
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/bradleyjkemp/grpc-tools/internal/marker"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcStream is the state of a single HTTP/2 stream carrying a gRPC call
+type grpcStream struct {
+	id    uint32
+	start time.Time
+	// when the trailers were sent or the stream was reset
+	end time.Time
+
+	requestHeaders  []hpack.HeaderField
+	responseHeaders []hpack.HeaderField
+	trailers        []hpack.HeaderField
+	resetCode       *http2.ErrCode
+
+	// data received in each direction that doesn't yet form a complete message
+	clientBuffer, serverBuffer []byte
+	messages                   []*internal.Message
+}
+
+type capturedRPC struct {
+	rpc *internal.RPC
+	// when the request headers were sent
+	start time.Time
+}
+
+// countingReader tracks how far through the stream the framer has read
+// so that frames can be matched up with the time they were captured
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// extractRPCs parses the HTTP/2 frames sent in each direction of a connection and returns the gRPC calls made
+func extractRPCs(logger logrus.FieldLogger, client, server *stream, connection internal.Connection) []capturedRPC {
+	if !bytes.HasPrefix(client.data, []byte(http2.ClientPreface)) {
+		logger.Debug("Skipping connection that isn't HTTP/2")
+		return nil
+	}
+
+	streams := map[uint32]*grpcStream{}
+	err := readFrames(client, len(http2.ClientPreface), func(frame http2.Frame, timestamp time.Time) {
+		s := streams[frame.Header().StreamID]
+		switch frame := frame.(type) {
+		case *http2.MetaHeadersFrame:
+			if s == nil {
+				streams[frame.StreamID] = &grpcStream{
+					id:             frame.StreamID,
+					start:          timestamp,
+					requestHeaders: frame.Fields,
+				}
+			}
+		case *http2.DataFrame:
+			if s != nil {
+				s.clientBuffer = s.appendData(logger, internal.ClientMessage, s.clientBuffer, frame.Data(), timestamp)
+			}
+		case *http2.RSTStreamFrame:
+			if s != nil && s.resetCode == nil {
+				s.resetCode = &frame.ErrCode
+				s.end = timestamp
+			}
+		}
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read HTTP/2 frames sent by client")
+	}
+
+	err = readFrames(server, 0, func(frame http2.Frame, timestamp time.Time) {
+		s := streams[frame.Header().StreamID]
+		if s == nil {
+			return
+		}
+		switch frame := frame.(type) {
+		case *http2.MetaHeadersFrame:
+			if frame.StreamEnded() || hasHeader(frame.Fields, "grpc-status") {
+				s.trailers = frame.Fields
+				s.end = timestamp
+			} else if s.responseHeaders == nil {
+				s.responseHeaders = frame.Fields
+			}
+		case *http2.DataFrame:
+			s.serverBuffer = s.appendData(logger, internal.ServerMessage, s.serverBuffer, frame.Data(), timestamp)
+		case *http2.RSTStreamFrame:
+			if s.resetCode == nil {
+				s.resetCode = &frame.ErrCode
+				s.end = timestamp
+			}
+		}
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read HTTP/2 frames sent by server")
+	}
+
+	var ids []uint32
+	for id := range streams {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	var rpcs []capturedRPC
+	for _, id := range ids {
+		if rpc := streams[id].toRPC(logger, connection); rpc != nil {
+			rpcs = append(rpcs, capturedRPC{rpc, streams[id].start})
+		}
+	}
+	return rpcs
+}
+
+// readFrames calls fn for each complete HTTP/2 frame in the stream
+func readFrames(s *stream, offset int, fn func(frame http2.Frame, timestamp time.Time)) error {
+	if offset > len(s.data) {
+		return nil
+	}
+	reader := &countingReader{r: bytes.NewReader(s.data[offset:]), n: offset}
+	framer := http2.NewFramer(nil, reader)
+	framer.SetMaxReadFrameSize(1<<24 - 1)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	// the table size is negotiated by SETTINGS frames sent in the other direction so allow any size
+	framer.ReadMetaHeaders.SetAllowedMaxDynamicTableSize(1<<32 - 1)
+	for {
+		frame, err := framer.ReadFrame()
+		switch err.(type) {
+		case nil:
+		case http2.StreamError:
+			// only affects a single stream so carry on
+			continue
+		default:
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// the capture may have ended part way through a frame
+				return nil
+			}
+			return err
+		}
+		fn(frame, s.timeAt(reader.n-1))
+	}
+}
+
+// appendData adds the contents of a DATA frame to the buffer and extracts any complete gRPC messages
+func (s *grpcStream) appendData(logger logrus.FieldLogger, origin internal.MessageOrigin, buffer, data []byte, timestamp time.Time) []byte {
+	buffer = append(buffer, data...)
+	for len(buffer) >= 5 {
+		flags := buffer[0]
+		length := int(binary.BigEndian.Uint32(buffer[1:5]))
+		if len(buffer) < 5+length {
+			break
+		}
+		message := append([]byte{}, buffer[5:5+length]...)
+		buffer = buffer[5+length:]
+
+		if flags&0x80 != 0 {
+			// gRPC-Web sends trailers as a final length-prefixed frame
+			s.trailers = parseWebTrailers(message)
+			s.end = timestamp
+			continue
+		}
+		if flags&0x01 != 0 {
+			encoding := "identity"
+			headers := s.requestHeaders
+			if origin == internal.ServerMessage {
+				headers = s.responseHeaders
+			}
+			if values := headerValues(headers, "grpc-encoding"); len(values) > 0 {
+				encoding = values[0]
+			}
+			decompressed, err := decompress(encoding, message)
+			if err != nil {
+				logger.WithError(err).Warnf("Failed to decompress message on stream %d, recording the compressed message", s.id)
+			} else {
+				message = decompressed
+			}
+		}
+		s.messages = append(s.messages, &internal.Message{
+			MessageOrigin: origin,
+			RawMessage:    message,
+			Timestamp:     timestamp,
+		})
+	}
+	return buffer
+}
+
+func decompress(encoding string, message []byte) ([]byte, error) {
+	if encoding != "gzip" {
+		return nil, fmt.Errorf("unsupported compression %s", encoding)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func parseWebTrailers(trailers []byte) []hpack.HeaderField {
+	header, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(trailers, "\r\n"...)))).ReadMIMEHeader()
+	var fields []hpack.HeaderField
+	for key, values := range header {
+		for _, value := range values {
+			fields = append(fields, hpack.HeaderField{Name: strings.ToLower(key), Value: value})
+		}
+	}
+	return fields
+}
+
+func (s *grpcStream) toRPC(logger logrus.FieldLogger, connection internal.Connection) *internal.RPC {
+	contentType := headerValues(s.requestHeaders, "content-type")
+	if len(contentType) == 0 || !strings.HasPrefix(contentType[0], "application/grpc") {
+		return nil
+	}
+	if strings.HasPrefix(contentType[0], "application/grpc-web-text") {
+		logger.Warnf("Skipping RPC on stream %d: base64 encoded gRPC-Web isn't supported", s.id)
+		return nil
+	}
+	path := headerValues(s.requestHeaders, ":path")
+	if len(path) == 0 {
+		return nil
+	}
+	fullMethod := strings.SplitN(strings.TrimPrefix(path[0], "/"), "/", 2)
+	if len(fullMethod) != 2 {
+		return nil
+	}
+
+	rpc := &internal.RPC{
+		Service:          fullMethod[0],
+		Method:           fullMethod[1],
+		Messages:         s.messages,
+		Metadata:         requestMetadata(s.requestHeaders),
+		ResponseHeaders:  responseMetadata(s.responseHeaders),
+		ResponseTrailers: responseMetadata(s.trailers),
+	}
+	if connection.TLSVersion != "" {
+		marker.MarkTLSRPC(rpc.Metadata)
+	}
+	connection.Protocol = internal.ProtocolGRPC
+	if strings.HasPrefix(contentType[0], "application/grpc-web") {
+		connection.Protocol = internal.ProtocolGRPCWeb
+	}
+	if encoding := headerValues(s.requestHeaders, "grpc-encoding"); len(encoding) > 0 && encoding[0] != "identity" {
+		connection.Compression = encoding[0]
+	}
+	rpc.Connection = &connection
+
+	switch {
+	case s.trailers != nil:
+		rpc.Status = grpcStatus(s.trailers)
+	case s.resetCode != nil && *s.resetCode == http2.ErrCodeCancel:
+		rpc.Status = &internal.Status{Code: codes.Canceled.String(), Message: "stream reset"}
+	case s.resetCode != nil:
+		rpc.Status = &internal.Status{Code: codes.Internal.String(), Message: "stream reset with " + s.resetCode.String()}
+	default:
+		logger.Warnf("RPC %s on stream %d didn't complete in the capture", rpc.StreamName(), s.id)
+	}
+
+	// sort the messages so that client and server messages are interleaved in the order they were sent
+	sort.SliceStable(rpc.Messages, func(i, j int) bool {
+		return rpc.Messages[i].Timestamp.Before(rpc.Messages[j].Timestamp)
+	})
+
+	if !s.end.IsZero() {
+		// the dial time can't be known as the connection may have been used for earlier RPCs
+		rpc.Timing = internal.NewTiming(s.start, s.end, 0, rpc.Messages)
+	}
+	return rpc
+}
+
+func grpcStatus(trailers []hpack.HeaderField) *internal.Status {
+	values := headerValues(trailers, "grpc-status")
+	if len(values) == 0 {
+		return &internal.Status{Code: codes.Unknown.String(), Message: "missing grpc-status"}
+	}
+	code, err := strconv.Atoi(values[0])
+	if err != nil {
+		return &internal.Status{Code: codes.Unknown.String(), Message: "invalid grpc-status " + values[0]}
+	}
+	if codes.Code(code) == codes.OK {
+		return nil
+	}
+
+	message := ""
+	if values := headerValues(trailers, "grpc-message"); len(values) > 0 {
+		// grpc-message is percent encoded
+		message = values[0]
+		if decoded, err := url.PathUnescape(message); err == nil {
+			message = decoded
+		}
+	}
+	return &internal.Status{Code: codes.Code(code).String(), Message: message}
+}
+
+// requestMetadata converts request headers into the metadata that a gRPC server would see
+func requestMetadata(fields []hpack.HeaderField) metadata.MD {
+	md := metadata.MD{}
+	for _, field := range fields {
+		if field.Name == ":authority" || field.Name == "content-type" || field.Name == "user-agent" || !isReservedHeader(field.Name) {
+			appendMetadata(md, field)
+		}
+	}
+	return md
+}
+
+// responseMetadata converts response headers or trailers into the metadata that a gRPC client would see
+func responseMetadata(fields []hpack.HeaderField) metadata.MD {
+	if fields == nil {
+		return nil
+	}
+	md := metadata.MD{}
+	for _, field := range fields {
+		if !isReservedHeader(field.Name) {
+			appendMetadata(md, field)
+		}
+	}
+	if len(md) == 0 {
+		return nil
+	}
+	return md
+}
+
+func appendMetadata(md metadata.MD, field hpack.HeaderField) {
+	value := field.Value
+	if strings.HasSuffix(field.Name, "-bin") {
+		// binary values are base64 encoded, with or without padding
+		decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err == nil {
+			value = string(decoded)
+		}
+	}
+	md.Append(field.Name, value)
+}
+
+// isReservedHeader matches the headers that gRPC doesn't expose as metadata
+func isReservedHeader(name string) bool {
+	if strings.HasPrefix(name, ":") {
+		return true
+	}
+	switch name {
+	case "content-type",
+		"user-agent",
+		"grpc-message-type",
+		"grpc-encoding",
+		"grpc-message",
+		"grpc-status",
+		"grpc-timeout",
+		"grpc-status-details-bin",
+		"te":
+		return true
+	}
+	return false
+}
+
+func hasHeader(fields []hpack.HeaderField, name string) bool {
+	return len(headerValues(fields, name)) > 0
+}
+
+func headerValues(fields []hpack.HeaderField, name string) []string {
+	var values []string
+	for _, field := range fields {
+		if field.Name == name {
+			values = append(values, field.Value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,100 @@
+// Package pcap extracts gRPC calls from packet captures (e.g. from tcpdump or Wireshark).
+//
+// TCP streams are reassembled from the captured packets and then parsed as HTTP/2 connections.
+// Both cleartext (h2c) connections and TLS connections are supported, as long as the
+// TLS secrets are available in a key log (or embedded in a pcapng file).
+package pcap
+
+import (
+	"bytes"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"io"
+	"sort"
+)
+
+// ReadRPCs reads a pcap or pcapng capture and returns all the gRPC calls in it ordered by when they started.
+// Messages are not decoded.
+func ReadRPCs(logger logrus.FieldLogger, capture io.Reader, keys KeyLog) ([]*internal.RPC, error) {
+	file, err := readCaptureFile(capture)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = KeyLog{}
+	}
+	if len(file.keyLog) > 0 {
+		embeddedKeys, err := ParseKeyLog(file.keyLog)
+		if err != nil {
+			return nil, err
+		}
+		keys.merge(embeddedKeys)
+	}
+
+	var captured []capturedRPC
+	for _, conn := range assembleConnections(file.packets) {
+		connLogger := logger.WithField("connection", conn.String())
+		client, server, ok := conn.streams(connLogger)
+		if !ok {
+			continue
+		}
+
+		connection := internal.Connection{
+			ClientAddress:   conn.endpoints[conn.client],
+			UpstreamAddress: conn.endpoints[1-conn.client],
+			HTTPVersion:     "HTTP/2.0",
+		}
+		if isTLS(client) {
+			var hello *serverHello
+			client, server, hello, err = decryptTLS(client, server, keys)
+			if err != nil {
+				connLogger.WithError(err).Warn("Skipping TLS connection that couldn't be decrypted")
+				continue
+			}
+			connection.SetTLS(hello.version, hello.cipherSuite)
+		}
+		captured = append(captured, extractRPCs(connLogger, client, server, connection)...)
+	}
+
+	sort.SliceStable(captured, func(i, j int) bool {
+		return captured[i].start.Before(captured[j].start)
+	})
+	rpcs := make([]*internal.RPC, len(captured))
+	for i := range captured {
+		rpcs[i] = captured[i].rpc
+	}
+	return rpcs, nil
+}
+
+// streams reassembles the data sent by the client and server of a connection
+func (c *tcpConnection) streams(logger logrus.FieldLogger) (client *stream, server *stream, ok bool) {
+	first, firstComplete := c.halves[0].reassemble()
+	second, secondComplete := c.halves[1].reassemble()
+	if !firstComplete || !secondComplete {
+		logger.Warn("Connection is missing packets, any RPCs after the missing packets will be skipped")
+	}
+
+	switch {
+	case c.client == 0:
+		return first, second, true
+	case c.client == 1:
+		return second, first, true
+	case looksLikeClient(first):
+		c.client = 0
+		return first, second, true
+	case looksLikeClient(second):
+		c.client = 1
+		return second, first, true
+	}
+	// the capture started part way through the connection so the
+	// HTTP/2 header compression state (or TLS secrets) can't be recovered
+	if len(first.data) > 0 || len(second.data) > 0 {
+		logger.Debug("Skipping connection that started before the capture")
+	}
+	return nil, nil, false
+}
+
+func looksLikeClient(s *stream) bool {
+	return bytes.HasPrefix(s.data, []byte(http2.ClientPreface)) || isTLS(s)
+}
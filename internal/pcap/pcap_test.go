@@ -0,0 +1,289 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"github.com/bradleyjkemp/grpc-tools/internal/marker"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadRPCs(t *testing.T) {
+	tests := []struct {
+		name       string
+		tlsVersion uint16
+	}{
+		{"h2c", 0},
+		{"tls1.2", tls.VersionTLS12},
+		{"tls1.3", tls.VersionTLS13},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			capture, keyLog := captureHealthChecks(t, test.tlsVersion)
+			keys, err := ParseKeyLog(keyLog)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rpcs, err := ReadRPCs(logrus.New(), bytes.NewReader(capture), keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rpcs) != 2 {
+				t.Fatalf("expected 2 RPCs, got %d", len(rpcs))
+			}
+			for _, rpc := range rpcs {
+				if rpc.StreamName() != "/grpc.health.v1.Health/Check" {
+					t.Errorf("unexpected method %s", rpc.StreamName())
+				}
+				if values := rpc.Metadata.Get("test-key"); len(values) != 1 || values[0] != "test-value" {
+					t.Errorf("unexpected metadata %v", rpc.Metadata)
+				}
+				if marker.IsTLSRPC(rpc.Metadata) != (test.tlsVersion != 0) {
+					t.Errorf("expected TLS marker to be %v", test.tlsVersion != 0)
+				}
+				if rpc.Connection.ClientAddress != "10.0.0.1:50000" || rpc.Connection.UpstreamAddress != "10.0.0.2:443" {
+					t.Errorf("unexpected connection addresses %+v", rpc.Connection)
+				}
+				if (rpc.Connection.TLSVersion != "") != (test.tlsVersion != 0) || rpc.Connection.TLSCipherSuite == "" && test.tlsVersion != 0 {
+					t.Errorf("unexpected TLS parameters %+v", rpc.Connection)
+				}
+				if rpc.Timing == nil || rpc.Timing.End.Before(rpc.Timing.Start) {
+					t.Errorf("unexpected timing %v", rpc.Timing)
+				}
+			}
+			// the failed RPC has no response message
+			if len(rpcs[0].Messages) != 2 || len(rpcs[1].Messages) != 1 {
+				t.Errorf("unexpected number of messages %d and %d", len(rpcs[0].Messages), len(rpcs[1].Messages))
+			}
+			if rpcs[0].Status != nil {
+				t.Errorf("expected first RPC to succeed, got %v", rpcs[0].Status)
+			}
+			if rpcs[1].Status == nil || rpcs[1].Status.Code != "NotFound" {
+				t.Errorf("expected second RPC to fail with NotFound, got %v", rpcs[1].Status)
+			}
+		})
+	}
+}
+
+// captureHealthChecks makes two health check RPCs and returns a pcap file of the connection
+func captureHealthChecks(t *testing.T, tlsVersion uint16) ([]byte, []byte) {
+	serverOpts := []grpc.ServerOption{}
+	dialOpts := []grpc.DialOption{grpc.WithInsecure()}
+	keyLog := &bytes.Buffer{}
+	if tlsVersion != 0 {
+		cert := selfSignedCert(t)
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tlsVersion,
+			MaxVersion:   tlsVersion,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		})))
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			InsecureSkipVerify: true,
+			KeyLogWriter:       keyLog,
+			MaxVersion:         tlsVersion,
+		}))}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(serverOpts...)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("test", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	recorder := &connRecorder{}
+	dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		return &recordingConn{conn, recorder}, err
+	}))
+	conn, err := grpc.Dial(listener.Addr().String(), dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "test-key", "test-value")
+	client := healthpb.NewHealthClient(conn)
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"}); err == nil {
+		t.Fatal("expected unknown service to fail")
+	}
+	conn.Close()
+
+	return recorder.pcap(), keyLog.Bytes()
+}
+
+type connRecorder struct {
+	sync.Mutex
+	writes []recordedWrite
+}
+
+type recordedWrite struct {
+	fromClient bool
+	data       []byte
+	timestamp  time.Time
+}
+
+type recordingConn struct {
+	net.Conn
+	recorder *connRecorder
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.recorder.record(false, p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.recorder.record(true, p)
+	return c.Conn.Write(p)
+}
+
+func (r *connRecorder) record(fromClient bool, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.writes = append(r.writes, recordedWrite{fromClient, append([]byte{}, data...), time.Now()})
+}
+
+// pcap converts the recorded connection into a pcap file of Ethernet/IPv4/TCP packets
+func (r *connRecorder) pcap() []byte {
+	r.Lock()
+	defer r.Unlock()
+
+	file := &bytes.Buffer{}
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicMicroseconds)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeEthernet)
+	file.Write(header)
+
+	start := time.Now()
+	if len(r.writes) > 0 {
+		start = r.writes[0].timestamp
+	}
+	seqs := map[bool]uint32{true: 1000, false: 0xfffffff0} // the server sequence number wraps
+	writePacket(file, true, seqs[true], tcpSYN, nil, start)
+	writePacket(file, false, seqs[false], tcpSYN|tcpACK, nil, start)
+	seqs[true]++
+	seqs[false]++
+
+	for i, write := range r.writes {
+		for data := write.data; len(data) > 0; {
+			segment := data
+			if len(segment) > 1000 {
+				segment = segment[:1000]
+			}
+			writePacket(file, write.fromClient, seqs[write.fromClient], tcpACK, segment, write.timestamp)
+			if i%5 == 0 {
+				// duplicate some packets to check that retransmissions are ignored
+				writePacket(file, write.fromClient, seqs[write.fromClient], tcpACK, segment, write.timestamp)
+			}
+			seqs[write.fromClient] += uint32(len(segment))
+			data = data[len(segment):]
+		}
+	}
+	return file.Bytes()
+}
+
+func writePacket(file *bytes.Buffer, fromClient bool, seq uint32, flags uint8, payload []byte, timestamp time.Time) {
+	srcPort, dstPort := uint16(50000), uint16(443)
+	srcIP, dstIP := []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}
+	if !fromClient {
+		srcPort, dstPort = dstPort, srcPort
+		srcIP, dstIP = dstIP, srcIP
+	}
+
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(tcp)+len(payload)))
+	ip[8] = 64
+	ip[9] = ipProtocolTCP
+	copy(ip[12:16], srcIP)
+	copy(ip[16:20], dstIP)
+
+	ethernet := make([]byte, 14)
+	binary.BigEndian.PutUint16(ethernet[12:14], etherTypeIPv4)
+
+	frame := append(append(append(ethernet, ip...), tcp...), payload...)
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:4], uint32(timestamp.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(frame)))
+	file.Write(record)
+	file.Write(frame)
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestParseKeyLog(t *testing.T) {
+	keys, err := ParseKeyLog([]byte(strings.Join([]string{
+		"# comment",
+		"CLIENT_RANDOM 0A0B 0102",
+		"",
+		"CLIENT_TRAFFIC_SECRET_0 0a0b 0304",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(keys["0a0b"]["CLIENT_RANDOM"], []byte{1, 2}) || !bytes.Equal(keys["0a0b"]["CLIENT_TRAFFIC_SECRET_0"], []byte{3, 4}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	if _, err := ParseKeyLog([]byte("CLIENT_RANDOM 0a0b")); err == nil {
+		t.Error("expected error for line with missing secret")
+	}
+}
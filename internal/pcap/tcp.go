@@ -0,0 +1,311 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpACK = 0x10
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	ipProtocolTCP = 6
+)
+
+type tcpSegment struct {
+	src, dst  string
+	seq       uint32
+	flags     uint8
+	payload   []byte
+	timestamp time.Time
+}
+
+// decodePacket extracts the TCP segment from a captured packet.
+// Returns false for any packet that isn't TCP (or can't be decoded).
+func decodePacket(p packet) (*tcpSegment, bool) {
+	data := p.data
+	var etherType uint16
+	switch p.linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType = binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for etherType == etherTypeVLAN && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		etherType = binary.BigEndian.Uint16(data[14:16])
+		data = data[16:]
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		etherType = binary.BigEndian.Uint16(data[0:2])
+		data = data[20:]
+	case linkTypeNull, linkTypeLoop:
+		// the 4 byte address family is in the capturing host's byte order so use the IP version instead
+		if len(data) < 4 {
+			return nil, false
+		}
+		data = data[4:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return nil, false
+	}
+	if etherType == 0 && len(data) > 0 {
+		switch data[0] >> 4 {
+		case 4:
+			etherType = etherTypeIPv4
+		case 6:
+			etherType = etherTypeIPv6
+		}
+	}
+
+	var srcIP, dstIP net.IP
+	switch etherType {
+	case etherTypeIPv4:
+		if len(data) < 20 || data[0]>>4 != 4 {
+			return nil, false
+		}
+		headerLength := int(data[0]&0x0f) * 4
+		totalLength := int(binary.BigEndian.Uint16(data[2:4]))
+		fragmentOffset := binary.BigEndian.Uint16(data[6:8]) & 0x1fff
+		moreFragments := data[6]&0x20 != 0
+		if data[9] != ipProtocolTCP || fragmentOffset != 0 || moreFragments {
+			return nil, false
+		}
+		if headerLength < 20 || totalLength < headerLength || totalLength > len(data) {
+			// TSO/GSO offloaded packets captured on the sending host can have a zero total length
+			if totalLength != 0 || headerLength < 20 || headerLength > len(data) {
+				return nil, false
+			}
+			totalLength = len(data)
+		}
+		srcIP, dstIP = net.IP(data[12:16]), net.IP(data[16:20])
+		data = data[headerLength:totalLength]
+
+	case etherTypeIPv6:
+		if len(data) < 40 || data[0]>>4 != 6 {
+			return nil, false
+		}
+		payloadLength := int(binary.BigEndian.Uint16(data[4:6]))
+		nextHeader := data[6]
+		srcIP, dstIP = net.IP(data[8:24]), net.IP(data[24:40])
+		data = data[40:]
+		if payloadLength > 0 && payloadLength <= len(data) {
+			data = data[:payloadLength]
+		}
+		// skip hop-by-hop, routing and destination options extension headers
+		for nextHeader == 0 || nextHeader == 43 || nextHeader == 60 {
+			if len(data) < 8 {
+				return nil, false
+			}
+			nextHeader = data[0]
+			length := (int(data[1]) + 1) * 8
+			if length > len(data) {
+				return nil, false
+			}
+			data = data[length:]
+		}
+		if nextHeader != ipProtocolTCP {
+			return nil, false
+		}
+
+	default:
+		return nil, false
+	}
+
+	if len(data) < 20 {
+		return nil, false
+	}
+	headerLength := int(data[12]>>4) * 4
+	if headerLength < 20 || headerLength > len(data) {
+		return nil, false
+	}
+	return &tcpSegment{
+		src:       net.JoinHostPort(srcIP.String(), fmt.Sprint(binary.BigEndian.Uint16(data[0:2]))),
+		dst:       net.JoinHostPort(dstIP.String(), fmt.Sprint(binary.BigEndian.Uint16(data[2:4]))),
+		seq:       binary.BigEndian.Uint32(data[4:8]),
+		flags:     data[13],
+		payload:   data[headerLength:],
+		timestamp: p.timestamp,
+	}, true
+}
+
+// halfConnection is one direction of a TCP connection
+type halfConnection struct {
+	segments []*tcpSegment
+	// initial sequence number, only known if the SYN was captured
+	isn    uint32
+	sawSYN bool
+	sawFIN bool
+}
+
+type tcpConnection struct {
+	// endpoints of the connection, halves[i] is the data sent by endpoints[i]
+	endpoints [2]string
+	halves    [2]*halfConnection
+	// index of the endpoint that opened the connection or -1 if unknown
+	client int
+	start  time.Time
+}
+
+func (c *tcpConnection) String() string {
+	if c.client == 1 {
+		return c.endpoints[1] + " -> " + c.endpoints[0]
+	}
+	return c.endpoints[0] + " -> " + c.endpoints[1]
+}
+
+// assembleConnections groups the TCP segments in a capture by connection
+func assembleConnections(packets []packet) []*tcpConnection {
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].timestamp.Before(packets[j].timestamp)
+	})
+
+	var connections []*tcpConnection
+	active := map[[2]string]*tcpConnection{}
+	for _, p := range packets {
+		segment, ok := decodePacket(p)
+		if !ok {
+			continue
+		}
+		key := [2]string{segment.src, segment.dst}
+		if key[1] < key[0] {
+			key[0], key[1] = key[1], key[0]
+		}
+
+		conn := active[key]
+		opening := segment.flags&tcpSYN != 0 && segment.flags&tcpACK == 0
+		if conn == nil || (opening && conn.hasStarted()) {
+			// a new connection (possibly reusing the ports of a previous one)
+			conn = &tcpConnection{
+				endpoints: key,
+				halves:    [2]*halfConnection{{}, {}},
+				client:    -1,
+				start:     segment.timestamp,
+			}
+			active[key] = conn
+			connections = append(connections, conn)
+		}
+
+		direction := 0
+		if segment.src != conn.endpoints[0] {
+			direction = 1
+		}
+		half := conn.halves[direction]
+		if segment.flags&tcpSYN != 0 {
+			half.isn = segment.seq
+			half.sawSYN = true
+			if segment.flags&tcpACK == 0 {
+				conn.client = direction
+			} else {
+				conn.client = 1 - direction
+			}
+		}
+		if segment.flags&(tcpFIN|tcpRST) != 0 {
+			half.sawFIN = true
+		}
+		if len(segment.payload) > 0 {
+			half.segments = append(half.segments, segment)
+		}
+	}
+	return connections
+}
+
+func (c *tcpConnection) hasStarted() bool {
+	for _, half := range c.halves {
+		if len(half.segments) > 0 || half.sawFIN {
+			return true
+		}
+	}
+	return false
+}
+
+// stream is a reassembled byte stream along with the time at which each part of it was captured
+type stream struct {
+	data  []byte
+	times []streamTime
+}
+
+type streamTime struct {
+	offset    int
+	timestamp time.Time
+}
+
+func (s *stream) append(data []byte, timestamp time.Time) {
+	s.times = append(s.times, streamTime{len(s.data), timestamp})
+	s.data = append(s.data, data...)
+}
+
+// timeAt returns the time at which the byte at the given offset was captured
+func (s *stream) timeAt(offset int) time.Time {
+	i := sort.Search(len(s.times), func(i int) bool {
+		return s.times[i].offset > offset
+	})
+	if i == 0 {
+		if len(s.times) == 0 {
+			return time.Time{}
+		}
+		return s.times[0].timestamp
+	}
+	return s.times[i-1].timestamp
+}
+
+// reassemble orders the segments by sequence number, discarding retransmissions.
+// If a segment is missing then the stream is truncated at that point and complete is false.
+func (h *halfConnection) reassemble() (s *stream, complete bool) {
+	s = &stream{}
+	if len(h.segments) == 0 {
+		return s, true
+	}
+
+	// sequence numbers wrap so work with offsets relative to the start of the stream
+	reference := h.segments[0].seq
+	if h.sawSYN {
+		reference = h.isn + 1
+	}
+	type orderedSegment struct {
+		offset int64
+		*tcpSegment
+	}
+	ordered := make([]orderedSegment, len(h.segments))
+	for i, segment := range h.segments {
+		ordered[i] = orderedSegment{int64(int32(segment.seq - reference)), segment}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].offset < ordered[j].offset
+	})
+
+	next := ordered[0].offset
+	if h.sawSYN && next > 0 {
+		return s, false
+	}
+	for _, segment := range ordered {
+		end := segment.offset + int64(len(segment.payload))
+		switch {
+		case segment.offset > next:
+			return s, false
+		case end <= next:
+			// retransmission of data we already have
+			continue
+		}
+		s.append(segment.payload[next-segment.offset:], segment.timestamp)
+		next = end
+	}
+	return s, true
+}
@@ -0,0 +1,468 @@
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+const (
+	recordTypeChangeCipherSpec = 20
+	recordTypeHandshake        = 22
+	recordTypeApplicationData  = 23
+
+	handshakeTypeClientHello = 1
+	handshakeTypeServerHello = 2
+	handshakeTypeKeyUpdate   = 24
+
+	extensionSupportedVersions = 43
+
+	versionTLS12 = 0x0303
+	versionTLS13 = 0x0304
+)
+
+// the random of a ServerHello that is actually a HelloRetryRequest (RFC 8446 section 4.1.3)
+var helloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// KeyLog holds TLS secrets in the NSS key log format (https://developer.mozilla.org/en-US/docs/Mozilla/Projects/NSS/Key_Log_Format)
+// as written by e.g. SSLKEYLOGFILE or crypto/tls.Config.KeyLogWriter.
+// Secrets are indexed by client random and then by label.
+type KeyLog map[string]map[string][]byte
+
+func ParseKeyLog(data []byte) (KeyLog, error) {
+	keys := KeyLog{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid key log line %d: expected 3 fields", line)
+		}
+		clientRandom := strings.ToLower(fields[1])
+		secret, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key log line %d: %v", line, err)
+		}
+		if keys[clientRandom] == nil {
+			keys[clientRandom] = map[string][]byte{}
+		}
+		keys[clientRandom][fields[0]] = secret
+	}
+	return keys, scanner.Err()
+}
+
+func (k KeyLog) merge(other KeyLog) {
+	for clientRandom, secrets := range other {
+		if k[clientRandom] == nil {
+			k[clientRandom] = map[string][]byte{}
+		}
+		for label, secret := range secrets {
+			k[clientRandom][label] = secret
+		}
+	}
+}
+
+type tlsRecord struct {
+	recordType uint8
+	header     []byte
+	payload    []byte
+	// offset of the end of the record in the stream
+	end int
+}
+
+// tlsRecords splits a stream into TLS records, ignoring any incomplete record at the end
+func tlsRecords(s *stream) []tlsRecord {
+	var records []tlsRecord
+	offset := 0
+	for offset+5 <= len(s.data) {
+		length := int(binary.BigEndian.Uint16(s.data[offset+3 : offset+5]))
+		if offset+5+length > len(s.data) {
+			break
+		}
+		records = append(records, tlsRecord{
+			recordType: s.data[offset],
+			header:     s.data[offset : offset+5],
+			payload:    s.data[offset+5 : offset+5+length],
+			end:        offset + 5 + length,
+		})
+		offset += 5 + length
+	}
+	return records
+}
+
+func isTLS(s *stream) bool {
+	// a handshake record containing a ClientHello
+	return len(s.data) >= 6 && s.data[0] == recordTypeHandshake && s.data[1] == 3 && s.data[5] == handshakeTypeClientHello
+}
+
+// plaintextHandshakes returns the handshake messages sent before encryption started
+func plaintextHandshakes(records []tlsRecord) [][]byte {
+	var buffer []byte
+	for _, record := range records {
+		if record.recordType == recordTypeApplicationData || record.recordType == recordTypeChangeCipherSpec {
+			break
+		}
+		if record.recordType == recordTypeHandshake {
+			buffer = append(buffer, record.payload...)
+		}
+	}
+
+	var messages [][]byte
+	for len(buffer) >= 4 {
+		length := int(buffer[1])<<16 | int(buffer[2])<<8 | int(buffer[3])
+		if 4+length > len(buffer) {
+			break
+		}
+		messages = append(messages, buffer[:4+length])
+		buffer = buffer[4+length:]
+	}
+	return messages
+}
+
+type serverHello struct {
+	random      []byte
+	version     uint16
+	cipherSuite uint16
+}
+
+func parseServerHello(message []byte) (*serverHello, error) {
+	if len(message) < 4+2+32+1 {
+		return nil, errors.New("ServerHello too short")
+	}
+	hello := &serverHello{
+		version: binary.BigEndian.Uint16(message[4:6]),
+		random:  message[6:38],
+	}
+	rest := message[38:]
+	sessionIDLength := int(rest[0])
+	if len(rest) < 1+sessionIDLength+3 {
+		return nil, errors.New("ServerHello too short")
+	}
+	rest = rest[1+sessionIDLength:]
+	hello.cipherSuite = binary.BigEndian.Uint16(rest[0:2])
+	rest = rest[3:]
+
+	if len(rest) < 2 {
+		return hello, nil
+	}
+	extensions := rest[2:]
+	for len(extensions) >= 4 {
+		extensionType := binary.BigEndian.Uint16(extensions[0:2])
+		length := int(binary.BigEndian.Uint16(extensions[2:4]))
+		if 4+length > len(extensions) {
+			break
+		}
+		if extensionType == extensionSupportedVersions && length == 2 {
+			hello.version = binary.BigEndian.Uint16(extensions[4:6])
+		}
+		extensions = extensions[4+length:]
+	}
+	return hello, nil
+}
+
+type cipherSuite struct {
+	keyLength int
+	hash      func() hash.Hash
+}
+
+// only AES-GCM cipher suites are supported as the others aren't in the standard library
+var cipherSuites = map[uint16]cipherSuite{
+	// TLS 1.3
+	0x1301: {16, sha256.New},
+	0x1302: {32, sha512.New384},
+	// TLS 1.2
+	0x009c: {16, sha256.New},
+	0x009d: {32, sha512.New384},
+	0xc02b: {16, sha256.New},
+	0xc02c: {32, sha512.New384},
+	0xc02f: {16, sha256.New},
+	0xc030: {32, sha512.New384},
+}
+
+// decryptTLS decrypts both directions of a TLS connection using the secrets in the key log
+// and returns the application data sent by the client and the server along with the negotiated parameters.
+func decryptTLS(client, server *stream, keys KeyLog) (*stream, *stream, *serverHello, error) {
+	clientRecords, serverRecords := tlsRecords(client), tlsRecords(server)
+
+	var clientRandom []byte
+	for _, message := range plaintextHandshakes(clientRecords) {
+		if message[0] == handshakeTypeClientHello && len(message) >= 4+2+32 {
+			clientRandom = message[6:38]
+			break
+		}
+	}
+	if clientRandom == nil {
+		return nil, nil, nil, errors.New("no ClientHello found")
+	}
+	var hello *serverHello
+	for _, message := range plaintextHandshakes(serverRecords) {
+		if message[0] != handshakeTypeServerHello {
+			continue
+		}
+		var err error
+		hello, err = parseServerHello(message)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !bytes.Equal(hello.random, helloRetryRequestRandom) {
+			break
+		}
+	}
+	if hello == nil {
+		return nil, nil, nil, errors.New("no ServerHello found")
+	}
+
+	suite, ok := cipherSuites[hello.cipherSuite]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("cipher suite %04x is not supported", hello.cipherSuite)
+	}
+	secrets := keys[hex.EncodeToString(clientRandom)]
+	if secrets == nil {
+		return nil, nil, nil, fmt.Errorf("no secrets in key log for client random %x", clientRandom)
+	}
+
+	switch hello.version {
+	case versionTLS13:
+		clientKeys, err := tls13Keys(secrets, "CLIENT_HANDSHAKE_TRAFFIC_SECRET", "CLIENT_TRAFFIC_SECRET_0")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		serverKeys, err := tls13Keys(secrets, "SERVER_HANDSHAKE_TRAFFIC_SECRET", "SERVER_TRAFFIC_SECRET_0")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		clientData, err := decryptTLS13(client, clientRecords, suite, clientKeys)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decrypt client data: %v", err)
+		}
+		serverData, err := decryptTLS13(server, serverRecords, suite, serverKeys)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decrypt server data: %v", err)
+		}
+		return clientData, serverData, hello, nil
+
+	case versionTLS12:
+		masterSecret := secrets["CLIENT_RANDOM"]
+		if masterSecret == nil {
+			return nil, nil, nil, fmt.Errorf("no CLIENT_RANDOM secret in key log for client random %x", clientRandom)
+		}
+		seed := append(append([]byte{}, hello.random...), clientRandom...)
+		keyBlock := prf12(suite.hash, masterSecret, []byte("key expansion"), seed, 2*suite.keyLength+2*4)
+		clientKey, serverKey := keyBlock[:suite.keyLength], keyBlock[suite.keyLength:2*suite.keyLength]
+		clientSalt, serverSalt := keyBlock[2*suite.keyLength:2*suite.keyLength+4], keyBlock[2*suite.keyLength+4:]
+
+		clientData, err := decryptTLS12(client, clientRecords, clientKey, clientSalt)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decrypt client data: %v", err)
+		}
+		serverData, err := decryptTLS12(server, serverRecords, serverKey, serverSalt)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decrypt server data: %v", err)
+		}
+		return clientData, serverData, hello, nil
+
+	default:
+		return nil, nil, nil, fmt.Errorf("TLS version %04x is not supported", hello.version)
+	}
+}
+
+// tls13Keys returns the traffic secrets that one side of a connection could use in the order they're used
+func tls13Keys(secrets map[string][]byte, labels ...string) ([][]byte, error) {
+	var keys [][]byte
+	for _, label := range labels {
+		secret := secrets[label]
+		if secret == nil {
+			return nil, fmt.Errorf("no %s secret in key log", label)
+		}
+		keys = append(keys, secret)
+	}
+	return keys, nil
+}
+
+type tls13Decrypter struct {
+	suite  cipherSuite
+	secret []byte
+	aead   cipher.AEAD
+	iv     []byte
+	seq    uint64
+}
+
+func newTLS13Decrypter(suite cipherSuite, secret []byte) (*tls13Decrypter, error) {
+	key := hkdfExpandLabel(suite.hash, secret, "key", suite.keyLength)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &tls13Decrypter{
+		suite:  suite,
+		secret: secret,
+		aead:   aead,
+		iv:     hkdfExpandLabel(suite.hash, secret, "iv", aead.NonceSize()),
+	}, nil
+}
+
+func (d *tls13Decrypter) decrypt(record tlsRecord) ([]byte, error) {
+	nonce := make([]byte, len(d.iv))
+	copy(nonce, d.iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(d.seq >> (8 * uint(i)))
+	}
+	plaintext, err := d.aead.Open(nil, nonce, record.payload, record.header)
+	if err != nil {
+		return nil, err
+	}
+	d.seq++
+	return plaintext, nil
+}
+
+// decryptTLS13 decrypts the records sent by one side of a TLS 1.3 connection.
+// The handshake is encrypted using different keys to the application data so,
+// rather than fully tracking the handshake, the next key is tried whenever decryption fails.
+func decryptTLS13(s *stream, records []tlsRecord, suite cipherSuite, secrets [][]byte) (*stream, error) {
+	plaintext := &stream{}
+	var decrypter *tls13Decrypter
+	next := 0
+	for _, record := range records {
+		if record.recordType != recordTypeApplicationData {
+			// plaintext handshake or compatibility mode ChangeCipherSpec
+			continue
+		}
+
+		var content []byte
+		var err error
+		if decrypter != nil {
+			content, err = decrypter.decrypt(record)
+		}
+		for (decrypter == nil || err != nil) && next < len(secrets) {
+			decrypter, err = newTLS13Decrypter(suite, secrets[next])
+			if err != nil {
+				return nil, err
+			}
+			next++
+			content, err = decrypter.decrypt(record)
+		}
+		if err != nil {
+			return plaintext, err
+		}
+
+		// the real content type is the last non-zero byte
+		content = bytes.TrimRight(content, "\x00")
+		if len(content) == 0 {
+			continue
+		}
+		contentType := content[len(content)-1]
+		content = content[:len(content)-1]
+		switch contentType {
+		case recordTypeApplicationData:
+			plaintext.append(content, s.timeAt(record.end-1))
+		case recordTypeHandshake:
+			if len(content) > 0 && content[0] == handshakeTypeKeyUpdate {
+				decrypter, err = newTLS13Decrypter(suite, hkdfExpandLabel(suite.hash, decrypter.secret, "traffic upd", suite.hash().Size()))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return plaintext, nil
+}
+
+// decryptTLS12 decrypts the records sent by one side of a TLS 1.2 connection using an AES-GCM cipher suite
+func decryptTLS12(s *stream, records []tlsRecord, key, salt []byte) (*stream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := &stream{}
+	encrypted := false
+	var seq uint64
+	for _, record := range records {
+		if record.recordType == recordTypeChangeCipherSpec {
+			encrypted = true
+			continue
+		}
+		if !encrypted {
+			continue
+		}
+		if len(record.payload) < 8+aead.Overhead() {
+			return plaintext, errors.New("encrypted record too short")
+		}
+
+		nonce := append(append([]byte{}, salt...), record.payload[:8]...)
+		additionalData := make([]byte, 13)
+		binary.BigEndian.PutUint64(additionalData, seq)
+		copy(additionalData[8:11], record.header[:3])
+		binary.BigEndian.PutUint16(additionalData[11:], uint16(len(record.payload)-8-aead.Overhead()))
+		content, err := aead.Open(nil, nonce, record.payload[8:], additionalData)
+		if err != nil {
+			return plaintext, err
+		}
+		seq++
+		if record.recordType == recordTypeApplicationData {
+			plaintext.append(content, s.timeAt(record.end-1))
+		}
+	}
+	return plaintext, nil
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label from RFC 8446 section 7.1 with an empty context
+func hkdfExpandLabel(hash func() hash.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := []byte{byte(length >> 8), byte(length), byte(len(label))}
+	info = append(info, label...)
+	info = append(info, 0)
+
+	var output, previous []byte
+	for counter := byte(1); len(output) < length; counter++ {
+		mac := hmac.New(hash, secret)
+		mac.Write(previous)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+		previous = mac.Sum(nil)
+		output = append(output, previous...)
+	}
+	return output[:length]
+}
+
+// prf12 implements the TLS 1.2 pseudorandom function from RFC 5246 section 5
+func prf12(hash func() hash.Hash, secret, label, seed []byte, length int) []byte {
+	labelAndSeed := append(append([]byte{}, label...), seed...)
+	var output []byte
+	a := labelAndSeed
+	for len(output) < length {
+		mac := hmac.New(hash, secret)
+		mac.Write(a)
+		a = mac.Sum(nil)
+
+		mac = hmac.New(hash, secret)
+		mac.Write(a)
+		mac.Write(labelAndSeed)
+		output = append(output, mac.Sum(nil)...)
+	}
+	return output[:length]
+}
@@ -0,0 +1,265 @@
+package proto_decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+// The JSON form of a google.protobuf.Any includes its payload, so the payload's type must be known to
+// marshal (or unmarshal) it. dynamic.Message only looks for these types in the message's own file and
+// the files it imports, so the payload types are found using the resolvers and added as imports.
+
+const anyMessageName = "google.protobuf.Any"
+
+// messageTypeResolver is implemented by resolvers which can also find message types by name
+type messageTypeResolver interface {
+	resolveMessageType(name string) (*desc.MessageDescriptor, error)
+}
+
+// resolveMessageType finds a message type using the first resolver which knows about it
+func resolveMessageType(resolvers []MessageResolver, name string) (*desc.MessageDescriptor, error) {
+	for _, resolver := range resolvers {
+		typeResolver, ok := resolver.(messageTypeResolver)
+		if !ok {
+			continue
+		}
+		if descriptor, err := typeResolver.resolveMessageType(name); err == nil {
+			return descriptor, nil
+		}
+	}
+	return nil, fmt.Errorf("message type %s not known", name)
+}
+
+// anyTypeName gets the fully qualified message name from an Any's type URL
+func anyTypeName(typeURL string) string {
+	return typeURL[strings.LastIndex(typeURL, "/")+1:]
+}
+
+// findInFile finds a message type in a file or the files it imports
+func findInFile(file *desc.FileDescriptor, name string, checked map[*desc.FileDescriptor]bool) *desc.MessageDescriptor {
+	if checked[file] {
+		return nil
+	}
+	checked[file] = true
+	if message := file.FindMessage(name); message != nil {
+		return message
+	}
+	for _, dependency := range file.GetDependencies() {
+		if message := findInFile(dependency, name, checked); message != nil {
+			return message
+		}
+	}
+	return nil
+}
+
+// withPayloadTypes returns a copy of the descriptor which imports the files defining the payload types
+func withPayloadTypes(descriptor *desc.MessageDescriptor, payloadTypes map[string]*desc.MessageDescriptor) (*desc.MessageDescriptor, error) {
+	if len(payloadTypes) == 0 {
+		return descriptor, nil
+	}
+	message, err := builder.FromMessage(descriptor)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create builder for message")
+	}
+	var names []string
+	for name := range payloadTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		message.GetFile().AddImportedDependency(payloadTypes[name].GetFile())
+	}
+	withPayloads, err := message.Build()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build descriptor with Any payload types")
+	}
+	return withPayloads, nil
+}
+
+// anyPayloadResolver finds the types of Any payloads for both decoding and encoding
+type anyPayloadResolver struct {
+	resolvers      []MessageResolver
+	messageFactory *messageFactory
+	// payloads of unknown types share the unknown field observations of their enclosing messages
+	unknownField *unknownFieldResolver
+}
+
+// withDecodePayloadTypes returns a copy of the descriptor which can expand all the Any payloads in the message
+func (a *anyPayloadResolver) withDecodePayloadTypes(descriptor *desc.MessageDescriptor, message *internal.Message) (*desc.MessageDescriptor, error) {
+	payloadTypes := map[string]*desc.MessageDescriptor{}
+	if err := a.resolveRawPayloads(descriptor, message, payloadTypes); err != nil {
+		return nil, err
+	}
+	return withPayloadTypes(descriptor, payloadTypes)
+}
+
+// withEncodePayloadTypes returns a copy of the descriptor which can unmarshal all the Any payloads in the
+// JSON form of the message. The types of payloads in the raw message (if any) are observed first so that
+// payloads of unknown types can be encoded with the types of their fields.
+func (a *anyPayloadResolver) withEncodePayloadTypes(descriptor *desc.MessageDescriptor, message *internal.Message, jsonMarshalled []byte) (*desc.MessageDescriptor, error) {
+	payloadTypes := map[string]*desc.MessageDescriptor{}
+	if message.RawMessage != nil {
+		if err := a.resolveRawPayloads(descriptor, message, payloadTypes); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.resolveTypeNames(descriptor.GetFile(), jsonMarshalled, payloadTypes); err != nil {
+		return nil, err
+	}
+	return withPayloadTypes(descriptor, payloadTypes)
+}
+
+func (a *anyPayloadResolver) resolveRawPayloads(descriptor *desc.MessageDescriptor, message *internal.Message, payloadTypes map[string]*desc.MessageDescriptor) error {
+	decoded := a.messageFactory.NewDynamicMessage(descriptor)
+	if err := proto.Unmarshal(message.RawMessage, decoded); err != nil {
+		return errors.Wrap(err, "failed to unmarshal message")
+	}
+	return a.resolveAnyPayloads(descriptor.GetFile(), decoded, payloadTypes)
+}
+
+// resolveAnyPayloads finds the types of all the Any payloads in a decoded message which
+// aren't already available from the file. Payloads of unknown types are decoded in the
+// same way as messages without a schema.
+func (a *anyPayloadResolver) resolveAnyPayloads(file *desc.FileDescriptor, message *dynamic.Message, payloadTypes map[string]*desc.MessageDescriptor) error {
+	if message.GetMessageDescriptor().GetFullyQualifiedName() == anyMessageName {
+		return a.resolveAnyPayload(file, message, payloadTypes)
+	}
+
+	var firstErr error
+	resolveNested := func(value interface{}) {
+		nested, ok := value.(proto.Message)
+		if !ok {
+			// a basic type
+			return
+		}
+		dynamicMessage, err := dynamic.AsDynamicMessage(nested)
+		if err != nil || dynamicMessage == nil {
+			return
+		}
+		if err := a.resolveAnyPayloads(file, dynamicMessage, payloadTypes); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, fieldDescriptor := range message.GetKnownFields() {
+		switch field := message.GetField(fieldDescriptor).(type) {
+		case proto.Message:
+			resolveNested(field)
+		case []interface{}:
+			for _, element := range field {
+				resolveNested(element)
+			}
+		case map[interface{}]interface{}:
+			for _, value := range field {
+				resolveNested(value)
+			}
+		}
+	}
+	return firstErr
+}
+
+func (a *anyPayloadResolver) resolveAnyPayload(file *desc.FileDescriptor, any *dynamic.Message, payloadTypes map[string]*desc.MessageDescriptor) error {
+	typeURL, _ := any.GetFieldByName("type_url").(string)
+	value, _ := any.GetFieldByName("value").([]byte)
+	name := anyTypeName(typeURL)
+	if name == "" || proto.MessageType(name) != nil {
+		// compiled in types (e.g. the well-known types) are always available
+		return nil
+	}
+
+	payloadType := findInFile(file, name, map[*desc.FileDescriptor]bool{})
+	if payloadType == nil {
+		var err error
+		payloadType, err = resolveMessageType(a.resolvers, name)
+		if err != nil {
+			// decode it like any other message without a schema
+			payloadType, err = unknownPayloadType(name)
+			if err != nil {
+				return err
+			}
+		}
+		payloadType, err = a.unknownField.enrichDecodeDescriptor(payloadType, &internal.Message{RawMessage: value})
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode Any payload of type %s", name)
+		}
+		payloadTypes[name] = payloadType
+	}
+
+	// the payload may itself contain Any fields
+	payload := a.messageFactory.NewDynamicMessage(payloadType)
+	if err := proto.Unmarshal(value, payload); err != nil {
+		return errors.Wrapf(err, "failed to decode Any payload of type %s", name)
+	}
+	return a.resolveAnyPayloads(file, payload, payloadTypes)
+}
+
+// unknownPayloadType creates an empty message type with the given fully qualified name
+func unknownPayloadType(name string) (*desc.MessageDescriptor, error) {
+	file := builder.NewFile("") // "" == generate unique filename
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		file.SetPackageName(name[:i])
+		name = name[i+1:]
+	}
+	message := builder.NewMessage(name)
+	if err := file.TryAddMessage(message); err != nil {
+		return nil, err
+	}
+	return message.Build()
+}
+
+// resolveTypeNames finds the types of all the Any payloads in the JSON form of a message
+// which aren't already available from the file or in payloadTypes. Payloads of unknown
+// types get the fields observed in previous payloads of the same type.
+func (a *anyPayloadResolver) resolveTypeNames(file *desc.FileDescriptor, message []byte, payloadTypes map[string]*desc.MessageDescriptor) error {
+	var decoded interface{}
+	if err := json.Unmarshal(message, &decoded); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	findAnyTypeNames(decoded, names)
+
+	for name := range names {
+		if payloadTypes[name] != nil || proto.MessageType(name) != nil || findInFile(file, name, map[*desc.FileDescriptor]bool{}) != nil {
+			continue
+		}
+		payloadType, err := resolveMessageType(a.resolvers, name)
+		if err != nil {
+			payloadType, err = unknownPayloadType(name)
+			if err != nil {
+				return err
+			}
+		}
+		a.unknownField.Lock()
+		payloadType, err = a.unknownField.enrichedDescriptor(payloadType)
+		a.unknownField.Unlock()
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve Any payload of type %s", name)
+		}
+		payloadTypes[name] = payloadType
+	}
+	return nil
+}
+
+// findAnyTypeNames finds the type names of all the Any payloads in the JSON form of a message
+func findAnyTypeNames(value interface{}, names map[string]bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if typeURL, ok := value["@type"].(string); ok {
+			names[anyTypeName(typeURL)] = true
+		}
+		for _, nested := range value {
+			findAnyTypeNames(nested, names)
+		}
+	case []interface{}:
+		for _, element := range value {
+			findAnyTypeNames(element, names)
+		}
+	}
+}
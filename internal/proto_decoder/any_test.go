@@ -0,0 +1,136 @@
+package proto_decoder
+
+import (
+	"encoding/json"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+)
+
+var testAnyProtos = map[string]string{
+	"event.proto": `syntax = "proto3";
+package wkt;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/empty.proto";
+import "google/protobuf/struct.proto";
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+
+service Events {
+    rpc Send(Event) returns (google.protobuf.Empty);
+}
+
+message Event {
+    google.protobuf.Any payload = 1;
+    repeated google.protobuf.Any details = 2;
+    google.protobuf.Timestamp time = 3;
+    google.protobuf.Duration elapsed = 4;
+    google.protobuf.Struct attributes = 5;
+    google.protobuf.StringValue label = 6;
+    google.protobuf.Int64Value count = 7;
+}
+`,
+	// not imported by event.proto so only available from the resolver
+	"payload.proto": `syntax = "proto3";
+package wkt;
+
+import "google/protobuf/any.proto";
+
+message Payload {
+    string id = 1;
+    google.protobuf.Any nested = 2;
+}
+`,
+	// not available when decoding at all
+	"secret.proto": `syntax = "proto3";
+package other;
+
+message Secret {
+    string code = 1;
+}
+`,
+}
+
+func TestDecodeAnyAndWellKnownTypes(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(testAnyProtos),
+	}
+	files, err := parser.ParseFiles("event.proto", "payload.proto", "secret.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := dynamic.NewMessage(files[0].FindMessage("wkt.Event"))
+	unmarshaler := jsonpb.Unmarshaler{AnyResolver: dynamic.AnyResolver(nil, files...)}
+	err = unmarshaler.Unmarshal(strings.NewReader(`{
+		"payload": {
+			"@type": "type.googleapis.com/wkt.Payload",
+			"id": "a",
+			"nested": {"@type": "type.googleapis.com/google.protobuf.Duration", "value": "1.500s"}
+		},
+		"details": [{"@type": "type.googleapis.com/other.Secret", "code": "x"}],
+		"time": "2019-06-24T19:19:46Z",
+		"elapsed": "2.500s",
+		"attributes": {"k": [1, "v", true, null]},
+		"label": "hello",
+		"count": "5"
+	}`), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := event.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := newDescriptorResolver(files[:2])
+	decoder := NewDecoder(logrus.New(), resolver)
+	decode := func(raw []byte) map[string]interface{} {
+		decoded, err := decoder.Decode("/wkt.Events/Send", &internal.Message{RawMessage: raw, MessageOrigin: internal.ClientMessage})
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := decoded.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result map[string]interface{}
+		if err := json.Unmarshal(actual, &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	expected := `{"attributes":{"k":[1,"v",true,null]},"count":"5",` +
+		// the payload of an unknown type is decoded without a schema
+		`"details":[{"1":"x","@type":"type.googleapis.com/other.Secret"}],` +
+		`"elapsed":"2.500s","label":"hello",` +
+		`"payload":{"@type":"type.googleapis.com/wkt.Payload","id":"a","nested":{"@type":"type.googleapis.com/google.protobuf.Duration","value":"1.500s"}},` +
+		`"time":"2019-06-24T19:19:46Z"}`
+	decoded := decode(raw)
+	if actual, _ := json.Marshal(decoded); string(actual) != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+
+	// edit the payload of the unknown type so that falling back to the raw message would be noticed
+	decoded["details"].([]interface{})[0].(map[string]interface{})["1"] = "y"
+	expectedJSON, _ := json.Marshal(decoded)
+	for name, message := range map[string]*internal.Message{
+		"hand written": {Message: decoded, MessageOrigin: internal.ClientMessage},
+		"edited":       {Message: decoded, RawMessage: raw, MessageOrigin: internal.ClientMessage},
+	} {
+		encoded, err := NewEncoder(resolver).Encode("/wkt.Events/Send", message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual, _ := json.Marshal(decode(encoded)); string(actual) != string(expectedJSON) {
+			t.Errorf("%s: expected %s, got %s", name, expectedJSON, actual)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/sirupsen/logrus"
+	"sync"
 )
 
 type MessageResolver interface {
@@ -18,24 +19,81 @@ type MessageResolver interface {
 	resolveDecoded(fullMethod string, message *internal.Message) (*desc.MessageDescriptor, error)
 }
 
+// extensionResolver is implemented by resolvers which know about extensions
+// (which may be defined in different files to the messages they extend)
+type extensionResolver interface {
+	// adds the resolver's current extensions to the registry
+	addExtensions(registry *dynamic.ExtensionRegistry)
+	// calls the function whenever the resolver is reloaded
+	onReload(func())
+}
+
+// messageFactory creates messages which include the extensions from all the resolvers.
+// The factory is rebuilt whenever a resolver is reloaded so that removed extensions are forgotten.
+type messageFactory struct {
+	sync.RWMutex
+	resolvers []extensionResolver
+	factory   *dynamic.MessageFactory
+}
+
+func newMessageFactory(resolvers []MessageResolver) *messageFactory {
+	f := &messageFactory{}
+	for _, resolver := range resolvers {
+		if extensionResolver, ok := resolver.(extensionResolver); ok {
+			f.resolvers = append(f.resolvers, extensionResolver)
+			extensionResolver.onReload(f.rebuild)
+		}
+	}
+	f.rebuild()
+	return f
+}
+
+func (f *messageFactory) rebuild() {
+	f.Lock()
+	defer f.Unlock()
+	extensions := &dynamic.ExtensionRegistry{}
+	for _, resolver := range f.resolvers {
+		resolver.addExtensions(extensions)
+	}
+	f.factory = dynamic.NewMessageFactoryWithExtensionRegistry(extensions)
+}
+
+func (f *messageFactory) NewDynamicMessage(descriptor *desc.MessageDescriptor) *dynamic.Message {
+	f.RLock()
+	defer f.RUnlock()
+	return f.factory.NewDynamicMessage(descriptor)
+}
+
+func (f *messageFactory) GetExtensionRegistry() *dynamic.ExtensionRegistry {
+	f.RLock()
+	defer f.RUnlock()
+	return f.factory.GetExtensionRegistry()
+}
+
 type MessageDecoder interface {
 	Decode(fullMethod string, message *internal.Message) (*dynamic.Message, error)
 }
 
 type messageDecoder struct {
-	logger       logrus.FieldLogger
-	resolvers    []MessageResolver
-	unknownField unknownFieldResolver
+	logger         logrus.FieldLogger
+	resolvers      []MessageResolver
+	messageFactory *messageFactory
+	unknownField   *unknownFieldResolver
+	anyPayloads    *anyPayloadResolver
 }
 
 // Chain together a number of resolvers to decode incoming messages.
 // Resolvers are in priority order, the first to return a nil error
 // is used to decode the message.
 func NewDecoder(logger logrus.FieldLogger, resolvers ...MessageResolver) *messageDecoder {
+	messageFactory := newMessageFactory(resolvers)
+	unknownField := newUnknownFieldResolver(messageFactory)
 	return &messageDecoder{
-		logger:       logger.WithField("", "proto_decoder"),
-		resolvers:    append(resolvers, emptyResolver{}),
-		unknownField: unknownFieldResolver{},
+		logger:         logger.WithField("", "proto_decoder"),
+		resolvers:      append(resolvers, emptyResolver{}),
+		messageFactory: messageFactory,
+		unknownField:   unknownField,
+		anyPayloads:    &anyPayloadResolver{resolvers, messageFactory, unknownField},
 	}
 }
 
@@ -60,8 +118,16 @@ func (d *messageDecoder) Decode(fullMethod string, message *internal.Message) (*
 		d.logger.WithError(err).Warn("Failed to search for unknown fields in message")
 	}
 
+	// make sure the payloads of any google.protobuf.Any fields can be expanded
+	anyDescriptor, err := d.anyPayloads.withDecodePayloadTypes(descriptor, message)
+	if err == nil {
+		descriptor = anyDescriptor
+	} else {
+		d.logger.WithError(err).Warn("Failed to resolve types of google.protobuf.Any payloads in message")
+	}
+
 	// now unmarshal using the resolved message type
-	dyn := dynamic.NewMessage(descriptor)
+	dyn := d.messageFactory.NewDynamicMessage(descriptor)
 	err = proto.Unmarshal(message.RawMessage, dyn)
 	if err == nil {
 		return dyn, nil
@@ -7,11 +7,13 @@ import (
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 	"github.com/jhump/protoreflect/desc"
-	"github.com/jhump/protoreflect/dynamic"
 )
 
 type messageEncoder struct {
-	resolvers []MessageResolver
+	resolvers      []MessageResolver
+	messageFactory *messageFactory
+	unknownField   *unknownFieldResolver
+	anyPayloads    *anyPayloadResolver
 }
 
 type MessageEncoder interface {
@@ -21,11 +23,15 @@ type MessageEncoder interface {
 // Chain together a number of resolvers to decode incoming messages.
 // Resolvers are in priority order, the first to return a nil error
 // is used to decode the message. If no resolvers are successful,
-// a default resolver is used that always returns empty.Empty
+// messages are encoded using the same heuristics as decoding messages without a schema.
 func NewEncoder(resolvers ...MessageResolver) *messageEncoder {
+	messageFactory := newMessageFactory(resolvers)
+	unknownField := newUnknownFieldResolver(messageFactory)
 	return &messageEncoder{
-		resolvers: append(resolvers),
-		// TODO: include an unknown message encoder here
+		resolvers:      resolvers,
+		messageFactory: messageFactory,
+		unknownField:   unknownField,
+		anyPayloads:    &anyPayloadResolver{resolvers, messageFactory, unknownField},
 	}
 }
 
@@ -52,17 +58,17 @@ func (d *messageEncoder) Encode(fullMethod string, message *internal.Message) ([
 }
 
 func (d *messageEncoder) encodeFromHumanReadable(fullMethod string, message *internal.Message) ([]byte, error) {
-	if len(d.resolvers) == 0 {
-		return nil, fmt.Errorf("no resolvers available")
-	}
-
 	var err error
+	var resolved *desc.MessageDescriptor
 	for _, resolver := range d.resolvers {
 		var descriptor *desc.MessageDescriptor
 		descriptor, err = resolver.resolveDecoded(fullMethod, message)
 		if err != nil {
 			continue
 		}
+		if resolved == nil {
+			resolved = descriptor
+		}
 
 		var jsonMarshalled []byte
 		jsonMarshalled, err = json.Marshal(message.Message)
@@ -70,8 +76,14 @@ func (d *messageEncoder) encodeFromHumanReadable(fullMethod string, message *int
 			continue
 		}
 
+		// the payloads of any google.protobuf.Any fields must be known to unmarshal them
+		descriptor, err = d.anyPayloads.withEncodePayloadTypes(descriptor, message, jsonMarshalled)
+		if err != nil {
+			continue
+		}
+
 		// now unmarshal again using the new generated message type
-		dyn := dynamic.NewMessage(descriptor)
+		dyn := d.messageFactory.NewDynamicMessage(descriptor)
 		err = jsonpb.UnmarshalString(string(jsonMarshalled), dyn)
 		if err != nil {
 			continue
@@ -82,5 +94,7 @@ func (d *messageEncoder) encodeFromHumanReadable(fullMethod string, message *int
 			return b, nil
 		}
 	}
-	return nil, err
+
+	// the message may have been decoded without a (complete) schema
+	return d.encodeUnknownFields(fullMethod, message, resolved)
 }
@@ -0,0 +1,100 @@
+package proto_decoder
+
+import (
+	"encoding/json"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/sirupsen/logrus"
+	"testing"
+)
+
+var testExtensionProtos = map[string]string{
+	"base.proto": `syntax = "proto2";
+package ext;
+
+service Service {
+    rpc Call(Request) returns (Response);
+}
+
+message Request {
+    optional string name = 1;
+    extensions 100 to 200;
+}
+
+message Response {}
+`,
+	// not imported by base.proto so the extensions are only known from the resolver
+	"more.proto": `syntax = "proto2";
+package ext.more;
+
+import "base.proto";
+
+extend ext.Request {
+    optional string tag = 100;
+    optional Detail detail = 101;
+}
+
+message Detail {
+    optional int64 id = 1;
+}
+`,
+	"optional.proto": `syntax = "proto3";
+package opt;
+
+service Service {
+    rpc Call(Request) returns (Request);
+}
+
+message Request {
+    optional int64 count = 1;
+    int64 total = 2;
+}
+`,
+}
+
+func TestDecodeExtensionsAndProto3Optional(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(testExtensionProtos),
+	}
+	files, err := parser.ParseFiles("base.proto", "more.proto", "optional.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := newDescriptorResolver(files)
+	decoder := NewDecoder(logrus.New(), resolver)
+	encoder := NewEncoder(resolver)
+
+	for _, test := range []struct {
+		method, message string
+	}{
+		{"/ext.Service/Call", `{"name":"a","[ext.more.tag]":"t","[ext.more.detail]":{"id":"5"}}`},
+		// proto3 optional fields keep track of whether they were set to their default value
+		{"/opt.Service/Call", `{"count":"0"}`},
+	} {
+		t.Run(test.method, func(t *testing.T) {
+			var message map[string]interface{}
+			if err := json.Unmarshal([]byte(test.message), &message); err != nil {
+				t.Fatal(err)
+			}
+			raw, err := encoder.Encode(test.method, &internal.Message{Message: message, MessageOrigin: internal.ClientMessage})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := decoder.Decode(test.method, &internal.Message{RawMessage: raw, MessageOrigin: internal.ClientMessage})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded.GetUnknownFields()) > 0 {
+				t.Errorf("unexpected unknown fields %v", decoded.GetUnknownFields())
+			}
+			actual, err := decoded.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(actual) != test.message {
+				t.Errorf("expected %s, got %s", test.message, actual)
+			}
+		})
+	}
+}
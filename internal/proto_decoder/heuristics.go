@@ -0,0 +1,302 @@
+package proto_decoder
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/dynamic"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The heuristics used to guess the type of an unknown field from the values seen on the wire.
+// Every value of a field is checked against each possible type and the most likely type
+// consistent with all of them is chosen, along with a rough confidence in that guess.
+
+const (
+	// field numbers are limited to 29 bits and 19000-19999 are reserved for the protobuf implementation
+	maxFieldNumber           = 1<<29 - 1
+	firstReservedFieldNumber = 19000
+	lastReservedFieldNumber  = 19999
+
+	// messages using field numbers larger than this are valid but unusual
+	plausibleFieldNumber = 1000
+)
+
+// typeGuess is the most likely type of an unknown field
+type typeGuess struct {
+	fieldType dpb.FieldDescriptorProto_Type
+	// repeated scalar values packed into a single length delimited field
+	packed bool
+	// from 0 to 1
+	confidence float64
+	reason     string
+}
+
+func (g typeGuess) String() string {
+	name := strings.ToLower(strings.TrimPrefix(g.fieldType.String(), "TYPE_"))
+	if g.packed {
+		name = "packed " + name
+	}
+	return fmt.Sprintf("%s (%.0f%% confidence): %s", name, g.confidence*100, g.reason)
+}
+
+// varintStats summarises the varint values of a field (including those in packed fields)
+type varintStats struct {
+	count int
+	odd   int
+	// values which fit in a single byte
+	small int
+	// any value which doesn't fit in 32 bits
+	large bool
+	// any value other than 0 or 1
+	notBool bool
+	// any value which is negative when interpreted as an int64
+	negative bool
+}
+
+func (s *varintStats) observe(value uint64) {
+	s.count++
+	if value%2 == 1 {
+		s.odd++
+	}
+	if value < 1<<7 {
+		s.small++
+	}
+	if value >= 1<<32 {
+		s.large = true
+	}
+	if value > 1 {
+		s.notBool = true
+	}
+	if int64(value) < 0 {
+		s.negative = true
+	}
+}
+
+// fixedStats summarises the fixed width values of a field (including those in packed fields)
+type fixedStats struct {
+	count int
+	// any value which isn't a plausible floating point number
+	notFloat bool
+	// any value which is negative when interpreted as a signed integer
+	negative bool
+}
+
+func (s *fixedStats) observe32(value uint32) {
+	s.count++
+	if !plausibleFloat(float64(math.Float32frombits(value))) {
+		s.notFloat = true
+	}
+	if int32(value) < 0 {
+		s.negative = true
+	}
+}
+
+func (s *fixedStats) observe64(value uint64) {
+	s.count++
+	if !plausibleFloat(math.Float64frombits(value)) {
+		s.notFloat = true
+	}
+	if int64(value) < 0 {
+		s.negative = true
+	}
+}
+
+// Integers reinterpreted as floats are almost always tiny denormals, huge or NaN
+func plausibleFloat(f float64) bool {
+	if f == 0 {
+		return true
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return false
+	}
+	f = math.Abs(f)
+	return f > 1e-9 && f < 1e15
+}
+
+// bytesStats records which types every length delimited value of a field could be
+type bytesStats struct {
+	count         int
+	notString     bool
+	notMessage    bool
+	notPacked     bool
+	notPacked32   bool
+	notPacked64   bool
+	largestNumber int32
+	sample        []byte
+	packedVarints varintStats
+	packedFixed32 fixedStats
+	packedFixed64 fixedStats
+}
+
+func (s *bytesStats) observe(value []byte) {
+	if s.count == 0 {
+		s.sample = value
+	}
+	s.count++
+	if !printableString(value) {
+		s.notString = true
+	}
+	if !s.notPacked && !observePackedVarints(value, &s.packedVarints) {
+		s.notPacked = true
+	}
+	if s.notPacked32 = s.notPacked32 || len(value)%4 != 0; !s.notPacked32 {
+		for i := 0; i < len(value); i += 4 {
+			s.packedFixed32.observe32(binary.LittleEndian.Uint32(value[i:]))
+		}
+	}
+	if s.notPacked64 = s.notPacked64 || len(value)%8 != 0; !s.notPacked64 {
+		for i := 0; i < len(value); i += 8 {
+			s.packedFixed64.observe64(binary.LittleEndian.Uint64(value[i:]))
+		}
+	}
+}
+
+// printableString checks that the value is UTF-8 text without any control characters (other than whitespace)
+func printableString(value []byte) bool {
+	if !utf8.Valid(value) {
+		return false
+	}
+	for _, r := range string(value) {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+func observePackedVarints(value []byte, stats *varintStats) bool {
+	for len(value) > 0 {
+		v, n := proto.DecodeVarint(value)
+		if n == 0 || (n > 1 && value[n-1] == 0) {
+			// encoders never add trailing zero bytes to varints
+			return false
+		}
+		stats.observe(v)
+		value = value[n:]
+	}
+	return true
+}
+
+// Most bytes can be decoded as varints so only assume that a field is packed
+// if the values look like the small integers (or enums) which are usually packed.
+func (s varintStats) plausiblyPacked() bool {
+	return !s.large && s.small*4 >= s.count*3
+}
+
+// validFieldNumber checks that a decoded message only uses field numbers which can appear in real messages
+func validFieldNumber(fieldNum int32) bool {
+	return fieldNum >= 1 && fieldNum <= maxFieldNumber &&
+		(fieldNum < firstReservedFieldNumber || fieldNum > lastReservedFieldNumber)
+}
+
+// validMessage checks that a value decoded as a message only has fields which could appear in a real message
+func (s *bytesStats) validMessage(message *dynamic.Message) bool {
+	for _, fieldNum := range message.GetUnknownFields() {
+		if !validFieldNumber(fieldNum) {
+			return false
+		}
+		for _, value := range message.GetUnknownField(fieldNum) {
+			if value.Encoding == proto.WireStartGroup {
+				// groups are deprecated so it's much more likely that this isn't a message
+				return false
+			}
+		}
+		if fieldNum > s.largestNumber {
+			s.largestNumber = fieldNum
+		}
+	}
+	return true
+}
+
+// guessType guesses the type of an unknown field from all of its values
+func (f *observedField) guessType() typeGuess {
+	switch f.encoding {
+	// Used for: int32, int64, uint32, uint64, sint32, sint64, bool, enum
+	case proto.WireVarint:
+		return f.varints.guess()
+
+	// Used for: fixed32, sfixed32, float
+	case proto.WireFixed32:
+		return f.fixed32s.guess(dpb.FieldDescriptorProto_TYPE_FLOAT, dpb.FieldDescriptorProto_TYPE_FIXED32, dpb.FieldDescriptorProto_TYPE_SFIXED32)
+
+	// Used for: fixed64, sfixed64, double
+	case proto.WireFixed64:
+		return f.fixed64s.guess(dpb.FieldDescriptorProto_TYPE_DOUBLE, dpb.FieldDescriptorProto_TYPE_FIXED64, dpb.FieldDescriptorProto_TYPE_SFIXED64)
+
+	// Used for: string, bytes, embedded messages, packed repeated fields
+	default:
+		return f.bytes.guess(f.message != nil)
+	}
+}
+
+func (s varintStats) guess() typeGuess {
+	switch {
+	case s.negative:
+		// negative int32/int64 values are sign extended to 10 bytes
+		return typeGuess{dpb.FieldDescriptorProto_TYPE_INT64, false, 0.9, "negative values seen"}
+	case !s.notBool && s.count >= 3:
+		// could just as well be an integer (or enum) that has only been seen as 0 or 1
+		return typeGuess{dpb.FieldDescriptorProto_TYPE_BOOL, false, confidenceFromSamples(0.4, 0.8, s.count), "only 0 and 1 seen"}
+	case s.count >= 4 && s.odd*4 >= s.count*3:
+		// Zigzag encoding makes negative values odd, but so are plenty of ordinary integers and enums
+		// and the values alone can't tell them apart. Guessing sint64 would show them as negative
+		// (and could change the type partway through a dump) so it's only suggested.
+		return typeGuess{dpb.FieldDescriptorProto_TYPE_INT64, false, 0.7, "integer values (mostly odd so could be a zigzag encoded sint64)"}
+	default:
+		return typeGuess{dpb.FieldDescriptorProto_TYPE_INT64, false, 0.7, "integer values"}
+	}
+}
+
+func (s fixedStats) guess(floatType, unsignedType, signedType dpb.FieldDescriptorProto_Type) typeGuess {
+	switch {
+	case !s.notFloat:
+		return typeGuess{floatType, false, confidenceFromSamples(0.6, 0.9, s.count), "plausible floating point values"}
+	case s.negative:
+		return typeGuess{signedType, false, 0.5, "not floating point and negative values seen"}
+	default:
+		return typeGuess{unsignedType, false, 0.5, "not floating point"}
+	}
+}
+
+func (s bytesStats) guess(parsedAsMessage bool) typeGuess {
+	switch {
+	case !s.notString:
+		// highly unlikely that printable text is actually an embedded proto message
+		return typeGuess{dpb.FieldDescriptorProto_TYPE_STRING, false, confidenceFromSamples(0.7, 0.95, s.count), "printable UTF-8"}
+	case !s.notMessage && parsedAsMessage:
+		confidence := confidenceFromSamples(0.6, 0.9, s.count)
+		if s.largestNumber > plausibleFieldNumber {
+			confidence /= 2
+		}
+		return typeGuess{dpb.FieldDescriptorProto_TYPE_MESSAGE, false, confidence, "valid embedded message"}
+	case !s.notPacked && s.packedVarints.plausiblyPacked():
+		guess := s.packedVarints.guess()
+		guess.packed, guess.confidence, guess.reason = true, guess.confidence*0.6, "packed varints, "+guess.reason
+		return guess
+	case !s.notPacked32 && !s.packedFixed32.notFloat:
+		return typeGuess{dpb.FieldDescriptorProto_TYPE_FLOAT, true, 0.4, "packed floating point values"}
+	case !s.notPacked64 && !s.packedFixed64.notFloat:
+		return typeGuess{dpb.FieldDescriptorProto_TYPE_DOUBLE, true, 0.4, "packed floating point values"}
+	default:
+		return typeGuess{dpb.FieldDescriptorProto_TYPE_BYTES, false, 0.6, "binary data e.g. " + hexSample(s.sample)}
+	}
+}
+
+// confidenceFromSamples increases the confidence of a guess towards max as more values are seen
+func confidenceFromSamples(min, max float64, samples int) float64 {
+	return max - (max-min)/float64(samples)
+}
+
+func hexSample(sample []byte) string {
+	const maxSample = 16
+	if len(sample) > maxSample {
+		return hex.EncodeToString(sample[:maxSample]) + "..."
+	}
+	return hex.EncodeToString(sample)
+}
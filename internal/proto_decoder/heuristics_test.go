@@ -0,0 +1,161 @@
+package proto_decoder
+
+import (
+	"encoding/binary"
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/dynamic"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func varintValues(values ...uint64) []dynamic.UnknownField {
+	var fields []dynamic.UnknownField
+	for _, value := range values {
+		fields = append(fields, dynamic.UnknownField{Encoding: proto.WireVarint, Value: value})
+	}
+	return fields
+}
+
+func bytesValues(values ...[]byte) []dynamic.UnknownField {
+	var fields []dynamic.UnknownField
+	for _, value := range values {
+		fields = append(fields, dynamic.UnknownField{Encoding: proto.WireBytes, Contents: value})
+	}
+	return fields
+}
+
+func packedFloats(values ...float32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(value))
+	}
+	return b
+}
+
+func packedDoubles(values ...float64) []byte {
+	b := make([]byte, 8*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(value))
+	}
+	return b
+}
+
+// messageWithField encodes a message with a single varint field
+func messageWithField(fieldNum uint64, value uint64) []byte {
+	return append(proto.EncodeVarint(fieldNum<<3|proto.WireVarint), proto.EncodeVarint(value)...)
+}
+
+func randomBytes(seed int64) []byte {
+	b := make([]byte, 64)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestGuessType(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []dynamic.UnknownField
+		fieldType dpb.FieldDescriptorProto_Type
+		packed    bool
+	}{
+		{"integers", varintValues(150, 3, 7), dpb.FieldDescriptorProto_TYPE_INT64, false},
+		{"only 0 and 1", varintValues(0, 1, 1), dpb.FieldDescriptorProto_TYPE_BOOL, false},
+		{"not bool once 2 is seen", varintValues(0, 1, 1, 2), dpb.FieldDescriptorProto_TYPE_INT64, false},
+		{"too few values to be bool", varintValues(0, 1), dpb.FieldDescriptorProto_TYPE_INT64, false},
+		{"negative", varintValues(math.MaxUint64), dpb.FieldDescriptorProto_TYPE_INT64, false},
+		{"mostly odd", varintValues(1, 3, 5, 7), dpb.FieldDescriptorProto_TYPE_INT64, false},
+		{"small odd enum values", varintValues(1, 3, 3, 1), dpb.FieldDescriptorProto_TYPE_INT64, false},
+		{"float", []dynamic.UnknownField{{Encoding: proto.WireFixed32, Value: uint64(math.Float32bits(1.5))}}, dpb.FieldDescriptorProto_TYPE_FLOAT, false},
+		{"fixed32", []dynamic.UnknownField{{Encoding: proto.WireFixed32, Value: 1}}, dpb.FieldDescriptorProto_TYPE_FIXED32, false},
+		{"sfixed32", []dynamic.UnknownField{{Encoding: proto.WireFixed32, Value: uint64(uint32(math.MaxUint32 - 5))}}, dpb.FieldDescriptorProto_TYPE_SFIXED32, false},
+		{"double", []dynamic.UnknownField{{Encoding: proto.WireFixed64, Value: math.Float64bits(-273.15)}}, dpb.FieldDescriptorProto_TYPE_DOUBLE, false},
+		{"fixed64", []dynamic.UnknownField{{Encoding: proto.WireFixed64, Value: 12345}}, dpb.FieldDescriptorProto_TYPE_FIXED64, false},
+		{"string", bytesValues([]byte("hello\nworld")), dpb.FieldDescriptorProto_TYPE_STRING, false},
+		{"message", bytesValues(messageWithField(1, 150)), dpb.FieldDescriptorProto_TYPE_MESSAGE, false},
+		{"reserved field number isn't a message", bytesValues(messageWithField(firstReservedFieldNumber, 1)), dpb.FieldDescriptorProto_TYPE_BYTES, false},
+		{"out of range field number isn't a message", bytesValues(messageWithField(maxFieldNumber+1, 1)), dpb.FieldDescriptorProto_TYPE_BYTES, false},
+		{"packed varints", bytesValues([]byte{0x80, 0x01, 2, 3, 4, 5, 6, 7, 8, 9, 10}), dpb.FieldDescriptorProto_TYPE_INT64, true},
+		{"packed fixed32", bytesValues(packedFloats(1.5, 2.25, -3)), dpb.FieldDescriptorProto_TYPE_FLOAT, true},
+		{"packed fixed64", bytesValues(packedDoubles(0.1, -123.456)), dpb.FieldDescriptorProto_TYPE_DOUBLE, true},
+		{"random bytes", bytesValues(randomBytes(1)), dpb.FieldDescriptorProto_TYPE_BYTES, false},
+		{"more random bytes", bytesValues(randomBytes(2), randomBytes(3)), dpb.FieldDescriptorProto_TYPE_BYTES, false},
+	}
+	for _, test := range tests {
+		field := &observedField{encoding: test.values[0].Encoding}
+		field.observe(test.values)
+		guess := field.guessType()
+		if guess.fieldType != test.fieldType || guess.packed != test.packed {
+			t.Errorf("%s: expected %v (packed %v), got %s", test.name, test.fieldType, test.packed, guess)
+		}
+		if guess.confidence <= 0 || guess.confidence >= 1 {
+			t.Errorf("%s: confidence out of range in %s", test.name, guess)
+		}
+	}
+}
+
+func TestSmallOddValuesStayInt64(t *testing.T) {
+	// e.g. an enum only seen with odd values, which zigzag decoding would show as negative
+	field := &observedField{encoding: proto.WireVarint}
+	field.observe(varintValues(1, 3, 3, 1))
+	guess := field.guessType()
+	if guess.fieldType != dpb.FieldDescriptorProto_TYPE_INT64 || !strings.Contains(guess.reason, "sint64") {
+		t.Errorf("expected int64 suggesting sint64, got %s", guess)
+	}
+}
+
+func TestGuessConfidenceIncreasesWithSamples(t *testing.T) {
+	few := &observedField{encoding: proto.WireBytes}
+	few.observe(bytesValues([]byte("a")))
+	many := &observedField{encoding: proto.WireBytes}
+	many.observe(bytesValues([]byte("a"), []byte("b"), []byte("c"), []byte("d")))
+	if few.guessType().confidence >= many.guessType().confidence {
+		t.Errorf("expected more samples to increase confidence: %s vs %s", few.guessType(), many.guessType())
+	}
+}
+
+func TestValidFieldNumber(t *testing.T) {
+	tests := map[int32]bool{
+		-1:                           false,
+		0:                            false,
+		1:                            true,
+		firstReservedFieldNumber - 1: true,
+		firstReservedFieldNumber:     false,
+		lastReservedFieldNumber:      false,
+		lastReservedFieldNumber + 1:  true,
+		maxFieldNumber:               true,
+		maxFieldNumber + 1:           false,
+	}
+	for fieldNum, expected := range tests {
+		if actual := validFieldNumber(fieldNum); actual != expected {
+			t.Errorf("validFieldNumber(%d) = %v, expected %v", fieldNum, actual, expected)
+		}
+	}
+}
+
+func TestPlausibleFloat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected bool
+	}{
+		{0, true},
+		{1.5, true},
+		{-273.15, true},
+		{1e14, true},
+		{1e-8, true},
+		{1e-10, false},
+		{1e16, false},
+		{math.NaN(), false},
+		{math.Inf(-1), false},
+		// small integers reinterpreted as floats are denormals
+		{float64(math.Float32frombits(1)), false},
+		{math.Float64frombits(1000), false},
+	}
+	for _, test := range tests {
+		if actual := plausibleFloat(test.value); actual != test.expected {
+			t.Errorf("plausibleFloat(%v) = %v, expected %v", test.value, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package proto_decoder
+
+import (
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+// SchemaInferrer infers proto definitions for gRPC services from the messages sent to and from them.
+// It uses the same heuristics as decoding messages without a schema, merged across every message observed.
+type SchemaInferrer struct {
+	unknownField *unknownFieldResolver
+	methods      map[string]*inferredMethod
+	// message names already used in each package
+	messageNames map[string]map[string]bool
+}
+
+type inferredMethod struct {
+	pkg, service, name string
+	request, response  *desc.MessageDescriptor
+	clientStreaming    bool
+	serverStreaming    bool
+}
+
+func NewSchemaInferrer() *SchemaInferrer {
+	return &SchemaInferrer{
+		unknownField: newUnknownFieldResolver(newMessageFactory(nil)),
+		methods:      map[string]*inferredMethod{},
+		messageNames: map[string]map[string]bool{},
+	}
+}
+
+// Observe records the messages of an RPC
+func (s *SchemaInferrer) Observe(rpc *internal.RPC) error {
+	method, err := s.method(rpc.Service, rpc.Method)
+	if err != nil {
+		return err
+	}
+
+	var clientMessages, serverMessages int
+	var firstErr error
+	for _, message := range rpc.Messages {
+		resolved := method.request
+		if message.MessageOrigin == internal.ServerMessage {
+			resolved = method.response
+			serverMessages++
+		} else {
+			clientMessages++
+		}
+		if len(message.RawMessage) == 0 {
+			continue
+		}
+		if err := s.unknownField.observe(resolved, message); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "failed to observe message of %s", rpc.StreamName())
+		}
+	}
+
+	// a single RPC can't prove that a method isn't streaming but more than one message proves that it is
+	method.clientStreaming = method.clientStreaming || clientMessages > 1
+	method.serverStreaming = method.serverStreaming || serverMessages > 1
+	return firstErr
+}
+
+func (s *SchemaInferrer) method(service, name string) (*inferredMethod, error) {
+	fullMethod := fmt.Sprintf("/%s/%s", service, name)
+	if method, ok := s.methods[fullMethod]; ok {
+		return method, nil
+	}
+
+	method := &inferredMethod{service: service, name: name}
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		method.pkg, method.service = service[:i], service[i+1:]
+	}
+	var err error
+	method.request, err = s.newMessage(method, "Request")
+	if err != nil {
+		return nil, err
+	}
+	method.response, err = s.newMessage(method, "Response")
+	if err != nil {
+		return nil, err
+	}
+	s.methods[fullMethod] = method
+	return method, nil
+}
+
+// newMessage creates an empty message type for a method's requests or responses
+func (s *SchemaInferrer) newMessage(method *inferredMethod, suffix string) (*desc.MessageDescriptor, error) {
+	names := s.messageNames[method.pkg]
+	if names == nil {
+		names = map[string]bool{}
+		s.messageNames[method.pkg] = names
+	}
+	name := method.name + suffix
+	if names[name] {
+		// another service in this package has a method with the same name
+		name = method.service + name
+	}
+	names[name] = true
+
+	file := builder.NewFile("").SetPackageName(method.pkg)
+	message := builder.NewMessage(name)
+	if err := file.TryAddMessage(message); err != nil {
+		return nil, err
+	}
+	return message.Build()
+}
+
+// Files returns a proto3 file for each service observed, named after the service.
+// Each file contains the service definition and the inferred request and response messages of its methods.
+func (s *SchemaInferrer) Files() ([]*desc.FileDescriptor, error) {
+	services := map[string][]*inferredMethod{}
+	for _, method := range s.methods {
+		fullService := method.service
+		if method.pkg != "" {
+			fullService = method.pkg + "." + method.service
+		}
+		services[fullService] = append(services[fullService], method)
+	}
+	var serviceNames []string
+	for service := range services {
+		serviceNames = append(serviceNames, service)
+	}
+	sort.Strings(serviceNames)
+
+	var files []*desc.FileDescriptor
+	for _, service := range serviceNames {
+		file, err := s.serviceFile(service, services[service])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to infer definition of %s", service)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (s *SchemaInferrer) serviceFile(fullService string, methods []*inferredMethod) (*desc.FileDescriptor, error) {
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].name < methods[j].name
+	})
+	file := builder.NewFile(fullService + ".proto").
+		SetProto3(true).
+		SetPackageName(methods[0].pkg)
+	service := builder.NewService(methods[0].service)
+
+	for _, method := range methods {
+		request, err := s.inferredMessage(file, method.request)
+		if err != nil {
+			return nil, err
+		}
+		response, err := s.inferredMessage(file, method.response)
+		if err != nil {
+			return nil, err
+		}
+		service.AddMethod(builder.NewMethod(method.name,
+			builder.RpcTypeMessage(request, method.clientStreaming),
+			builder.RpcTypeMessage(response, method.serverStreaming),
+		))
+	}
+	if err := file.TryAddService(service); err != nil {
+		return nil, err
+	}
+
+	// the generated names are only unique to make decoding work so give the fields simpler names for editing
+	for _, child := range file.GetChildren() {
+		if message, ok := child.(*builder.MessageBuilder); ok {
+			renameFields(message)
+		}
+	}
+	return file.Build()
+}
+
+// inferredMessage adds a message type, and the nested messages inferred from its fields, to the file
+func (s *SchemaInferrer) inferredMessage(file *builder.FileBuilder, resolved *desc.MessageDescriptor) (*builder.MessageBuilder, error) {
+	message := builder.NewMessage(resolved.GetName())
+	if err := file.TryAddMessage(message); err != nil {
+		return nil, err
+	}
+	if observed := s.unknownField.observed[resolved.GetFullyQualifiedName()]; observed != nil {
+		if err := s.unknownField.addUnknownFields(message, observed); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+func renameFields(message *builder.MessageBuilder) {
+	for _, child := range message.GetChildren() {
+		if field, ok := child.(*builder.FieldBuilder); ok {
+			field.SetName(fmt.Sprintf("field_%d", field.GetNumber())).SetJsonName("")
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package proto_decoder
+
+import (
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/desc/protoprint"
+	"github.com/jhump/protoreflect/dynamic"
+	"testing"
+)
+
+const testInferProto = `syntax = "proto3";
+package infer;
+
+message Request {
+    string query = 1;
+    map<string, int64> counts = 2;
+    Page page = 3;
+    repeated int32 codes = 4;
+    sint64 delta = 5;
+    bool exact = 6;
+    string title = 7;
+}
+
+message Page {
+    int64 size = 1;
+    Cursor cursor = 2;
+}
+
+message Cursor {
+    bytes token = 1;
+    int64 offset = 2;
+}
+
+message Result {
+    string name = 1;
+    double score = 2;
+}
+`
+
+func TestSchemaInferrer(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"infer.proto": testInferProto}),
+	}
+	files, err := parser.ParseFiles("infer.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(messageType, message string) []byte {
+		dyn := dynamic.NewMessage(files[0].FindMessage(messageType))
+		if err := dyn.UnmarshalJSON([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := dyn.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	inferrer := NewSchemaInferrer()
+	requests := []string{
+		`{"query": "cats", "counts": {"a": 1, "b": 2}, "page": {"size": 10, "cursor": {"token": "AP8=", "offset": 5}}, "codes": [1, 2, 3], "delta": -3, "exact": true, "title": "héllo ✓"}`,
+		`{"delta": -10, "exact": true}`,
+		`{"delta": -1, "exact": true}`,
+		`{"delta": 4, "exact": true}`,
+	}
+	for _, request := range requests {
+		err = inferrer.Observe(&internal.RPC{
+			Service: "infer.Search",
+			Method:  "Find",
+			Messages: []*internal.Message{
+				{MessageOrigin: internal.ClientMessage, RawMessage: encode("infer.Request", request)},
+				{MessageOrigin: internal.ServerMessage, RawMessage: encode("infer.Result", `{"name": "tabby", "score": 0.5}`)},
+				{MessageOrigin: internal.ServerMessage, RawMessage: encode("infer.Result", `{"name": "siamese"}`)},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inferred, err := inferrer.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inferred) != 1 {
+		t.Fatalf("expected a single file, got %d", len(inferred))
+	}
+	printed, err := (&protoprint.Printer{}).PrintProtoToString(inferred[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `syntax = "proto3";
+
+package infer;
+
+message FindRequest {
+  // string (70% confidence): printable UTF-8
+  string field_1 = 1;
+
+  // map of int64 (70% confidence): integer values
+  map<string, int64> field_2 = 2;
+
+  // message (60% confidence): valid embedded message
+  FindRequest_3 field_3 = 3;
+
+  // packed int64 (42% confidence): packed varints, integer values
+  repeated int64 field_4 = 4;
+
+  // int64 (70% confidence): integer values (mostly odd so could be a zigzag encoded sint64)
+  int64 field_5 = 5;
+
+  // bool (70% confidence): only 0 and 1 seen
+  bool field_6 = 6;
+
+  // string (70% confidence): printable UTF-8
+  string field_7 = 7;
+}
+
+message FindRequest_3 {
+  // int64 (70% confidence): integer values
+  int64 field_1 = 1;
+
+  // message (60% confidence): valid embedded message
+  FindRequest_3_2 field_2 = 2;
+}
+
+message FindRequest_3_2 {
+  // bytes (60% confidence): binary data e.g. 00ff
+  bytes field_1 = 1;
+
+  // int64 (70% confidence): integer values
+  int64 field_2 = 2;
+}
+
+message FindResponse {
+  // string (92% confidence): printable UTF-8
+  string field_1 = 1;
+
+  // double (82% confidence): plausible floating point values
+  double field_2 = 2;
+}
+
+service Search {
+  rpc Find ( FindRequest ) returns ( stream FindResponse );
+}
+`
+	if printed != expected {
+		t.Errorf("unexpected definition:\n%s", printed)
+	}
+
+	// the inferred definition must be usable to decode the messages again
+	reparsed, err := (&protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"infer.Search.proto": printed}),
+	}).ParseFiles("infer.Search.proto")
+	if err != nil {
+		t.Fatalf("failed to parse inferred definition: %v\n%s", err, printed)
+	}
+	method := reparsed[0].FindService("infer.Search").FindMethodByName("Find")
+	if method.IsClientStreaming() || !method.IsServerStreaming() {
+		t.Errorf("unexpected streaming types for %s", method.GetName())
+	}
+}
@@ -7,11 +7,48 @@ import (
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/builder"
+	"github.com/jhump/protoreflect/dynamic"
+	"log"
 	"strings"
+	"sync"
 )
 
 type descriptorResolver struct {
+	sync.RWMutex
 	methodDescriptors map[string]*desc.MethodDescriptor
+	messageTypes      map[string]*desc.MessageDescriptor
+	extensions        []*desc.FieldDescriptor
+	// called after reloading (e.g. to rebuild the message factories using the extensions)
+	reloaded []func()
+
+	// load loads the files again (nil if the resolver can't be reloaded)
+	load func() ([]*desc.FileDescriptor, error)
+	// sourceFiles lists the files that are watched for changes
+	sourceFiles func() []string
+}
+
+func newDescriptorResolver(files []*desc.FileDescriptor) *descriptorResolver {
+	d := &descriptorResolver{}
+	d.setFiles(files)
+	return d
+}
+
+// setFiles replaces all the definitions known to the resolver at once
+func (d *descriptorResolver) setFiles(files []*desc.FileDescriptor) {
+	methodDescriptors := proto_descriptor.MethodDescriptors(files)
+	messageTypes := proto_descriptor.MessageTypes(files)
+	extensions := proto_descriptor.Extensions(files)
+
+	d.Lock()
+	d.methodDescriptors = methodDescriptors
+	d.messageTypes = messageTypes
+	d.extensions = extensions
+	reloaded := d.reloaded
+	d.Unlock()
+	// called without the lock held as they read the new definitions
+	for _, f := range reloaded {
+		f()
+	}
 }
 
 func (d *descriptorResolver) resolveEncoded(fullMethod string, message *internal.Message) (*desc.MessageDescriptor, error) {
@@ -23,6 +60,8 @@ func (d *descriptorResolver) resolveDecoded(fullMethod string, message *internal
 }
 
 func (d *descriptorResolver) resolve(fullMethod string, direction internal.MessageOrigin) (*desc.MessageDescriptor, error) {
+	d.RLock()
+	defer d.RUnlock()
 	if descriptor, ok := d.methodDescriptors[fullMethod]; ok {
 		switch direction {
 		case internal.ClientMessage:
@@ -34,26 +73,111 @@ func (d *descriptorResolver) resolve(fullMethod string, direction internal.Messa
 	return nil, fmt.Errorf("method not known")
 }
 
-func NewFileResolver(protoFileRoots ...string) (*descriptorResolver, error) {
-	descs, err := proto_descriptor.LoadProtoDirectories(protoFileRoots...)
+func (d *descriptorResolver) resolveMessageType(name string) (*desc.MessageDescriptor, error) {
+	d.RLock()
+	defer d.RUnlock()
+	if descriptor, ok := d.messageTypes[name]; ok {
+		return descriptor, nil
+	}
+	return nil, fmt.Errorf("message type not known")
+}
+
+// addExtensions adds the extensions to the registry, replacing any extension added earlier
+// (e.g. by another resolver) with the same number on the same message
+func (d *descriptorResolver) addExtensions(registry *dynamic.ExtensionRegistry) {
+	d.RLock()
+	defer d.RUnlock()
+	if err := registry.AddExtension(d.extensions...); err != nil {
+		log.Printf("failed to register extensions: %v", err)
+	}
+}
+
+func (d *descriptorResolver) onReload(f func()) {
+	d.Lock()
+	defer d.Unlock()
+	d.reloaded = append(d.reloaded, f)
+}
+
+// MethodDescriptorSource is implemented by the file and descriptor resolvers
+type MethodDescriptorSource interface {
+	MethodDescriptor(fullMethod string) (*desc.MethodDescriptor, bool)
+}
+
+// MethodDescriptor returns the descriptor for a method in gRPC "info.FullMethod" format
+func (d *descriptorResolver) MethodDescriptor(fullMethod string) (*desc.MethodDescriptor, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	descriptor, ok := d.methodDescriptors[fullMethod]
+	return descriptor, ok
+}
+
+func NewFileResolver(options proto_descriptor.LoadOptions, protoFileRoots ...string) (*descriptorResolver, error) {
+	load := func() ([]*desc.FileDescriptor, error) {
+		files, report, err := proto_descriptor.LoadProtoDirectories(options, protoFileRoots...)
+		log.Print(report)
+		return files, err
+	}
+	files, err := load()
 	if err != nil {
 		return nil, err
 	}
 
-	return &descriptorResolver{
-		descs,
-	}, nil
+	d := newDescriptorResolver(files)
+	d.load = load
+	d.sourceFiles = func() []string {
+		return proto_descriptor.ProtoFiles(append(append([]string{}, protoFileRoots...), options.ImportPaths...)...)
+	}
+	return d, nil
 }
 
 func NewDescriptorResolver(protoFileDescriptors ...string) (*descriptorResolver, error) {
-	descs, err := proto_descriptor.LoadProtoDescriptors(protoFileDescriptors...)
+	load := func() ([]*desc.FileDescriptor, error) {
+		return proto_descriptor.LoadProtoDescriptors(protoFileDescriptors...)
+	}
+	files, err := load()
 	if err != nil {
 		return nil, err
 	}
 
-	return &descriptorResolver{
-		descs,
-	}, nil
+	d := newDescriptorResolver(files)
+	d.load = load
+	d.sourceFiles = func() []string {
+		return protoFileDescriptors
+	}
+	return d, nil
+}
+
+// ProtoSources are the comma separated lists of places to load message definitions from
+type ProtoSources struct {
+	Roots       string // directories to search for .proto files
+	ImportPaths string // directories to search for files imported by the .proto files
+	Descriptors string // descriptor set files
+	Strict      bool   // fail if any .proto file can't be parsed
+	Watch       bool   // reload the definitions whenever the files change (see Watch)
+}
+
+// NewResolvers creates resolvers for all the message definitions in the given sources
+func NewResolvers(sources ProtoSources) ([]MessageResolver, error) {
+	var resolvers []MessageResolver
+	if sources.Roots != "" {
+		options := proto_descriptor.LoadOptions{Strict: sources.Strict}
+		if sources.ImportPaths != "" {
+			options.ImportPaths = strings.Split(sources.ImportPaths, ",")
+		}
+		r, err := NewFileResolver(options, strings.Split(sources.Roots, ",")...)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, r)
+	}
+	if sources.Descriptors != "" {
+		r, err := NewDescriptorResolver(strings.Split(sources.Descriptors, ",")...)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, r)
+	}
+	return resolvers, nil
 }
 
 var messageName = strings.NewReplacer(
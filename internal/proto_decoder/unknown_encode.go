@@ -0,0 +1,395 @@
+package proto_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Messages decoded without a (complete) schema only have field numbers in their JSON form
+// (e.g. {"1": "name", "2": {"1": "5"}}) so can't be encoded using the resolved descriptor alone.
+//
+// The types of these fields are recovered from the original raw message, using the same
+// heuristics as decoding. Any fields which still aren't known (e.g. because they were added
+// by hand) are encoded directly from their JSON values. This works because protobuf fields
+// can be encoded separately and concatenated to make the full message.
+
+// encodeUnknownFields encodes a message using the resolved descriptor (if any) and the types
+// of the unknown fields observed in the raw message and any previous messages of the same type
+func (d *messageEncoder) encodeUnknownFields(fullMethod string, message *internal.Message, resolved *desc.MessageDescriptor) ([]byte, error) {
+	var err error
+	if resolved == nil {
+		resolved, err = emptyResolver{}.resolveEncoded(fullMethod, message)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var descriptor *desc.MessageDescriptor
+	if message.RawMessage != nil {
+		descriptor, err = d.unknownField.enrichDecodeDescriptor(resolved, message)
+	} else {
+		d.unknownField.Lock()
+		descriptor, err = d.unknownField.enrichedDescriptor(resolved)
+		d.unknownField.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jsonMarshalled, err := json.Marshal(message.Message)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, err = d.anyPayloads.withEncodePayloadTypes(descriptor, message, jsonMarshalled)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep numbers as they are so that integers aren't converted to floats
+	decoder := json.NewDecoder(bytes.NewReader(jsonMarshalled))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, errors.Wrap(err, "message isn't a JSON object")
+	}
+	d.unknownField.Lock()
+	defer d.unknownField.Unlock()
+	encoder := &jsonObjectEncoder{messageFactory: d.messageFactory, file: descriptor.GetFile(), observed: d.unknownField.observed}
+	return encoder.encodeJSONObject(descriptor, object)
+}
+
+// jsonObjectEncoder encodes the JSON form of messages which may have fields that aren't in their descriptors
+type jsonObjectEncoder struct {
+	messageFactory *messageFactory
+	// the file of the message being encoded, which imports the types of all its Any payloads
+	file *desc.FileDescriptor
+	// the unknown fields observed in each message type (the unknownFieldResolver must be locked)
+	observed map[string]*observedMessage
+}
+
+// encodeJSONObject encodes the JSON form of a message, including any fields not in the descriptor
+func (e *jsonObjectEncoder) encodeJSONObject(descriptor *desc.MessageDescriptor, object map[string]interface{}) ([]byte, error) {
+	buffer := proto.NewBuffer(nil)
+	known := map[string]interface{}{}
+	for _, key := range sortedKeys(object) {
+		value := object[key]
+		field := findFieldByKey(descriptor, key)
+		switch {
+		case field == nil && strings.HasPrefix(key, "["):
+			// extensions are written as [full.name]
+			known[key] = value
+
+		case field == nil:
+			fieldNum, ok := fieldNumberFromKey(key)
+			if !ok {
+				return nil, fmt.Errorf("unknown field %s in message %s", key, descriptor.GetFullyQualifiedName())
+			}
+			if err := encodeUnknownField(buffer, fieldNum, value, e.observedField(descriptor, fieldNum)); err != nil {
+				return nil, errors.Wrapf(err, "failed to encode field %s", key)
+			}
+
+		case field.GetMessageType() != nil && field.GetMessageType().GetFullyQualifiedName() == anyMessageName:
+			// the payload may have unknown fields too
+			if err := e.encodeAnyField(buffer, field, value); err != nil {
+				return nil, errors.Wrapf(err, "failed to encode field %s", key)
+			}
+
+		case field.GetMessageType() != nil && !isWellKnownType(field.GetMessageType()):
+			// the nested message may have unknown fields too
+			if err := e.encodeMessageField(buffer, field, value); err != nil {
+				return nil, errors.Wrapf(err, "failed to encode field %s", key)
+			}
+
+		default:
+			known[field.GetName()] = value
+		}
+	}
+
+	knownJSON, err := json.Marshal(known)
+	if err != nil {
+		return nil, err
+	}
+	dyn := e.messageFactory.NewDynamicMessage(descriptor)
+	if err := jsonpb.Unmarshal(bytes.NewReader(knownJSON), dyn); err != nil {
+		return nil, err
+	}
+	knownBytes, err := proto.Marshal(dyn)
+	if err != nil {
+		return nil, err
+	}
+	return append(knownBytes, buffer.Bytes()...), nil
+}
+
+// observedField gets the observations of an unknown field in a message type, if it has been seen
+func (e *jsonObjectEncoder) observedField(descriptor *desc.MessageDescriptor, fieldNum int32) *observedField {
+	if observed := e.observed[descriptor.GetFullyQualifiedName()]; observed != nil {
+		return observed.fields[fieldNum]
+	}
+	return nil
+}
+
+// findFieldByKey finds the field for a JSON key: either its name, JSON name or a key ending in its number
+func findFieldByKey(descriptor *desc.MessageDescriptor, key string) *desc.FieldDescriptor {
+	if field := descriptor.FindFieldByName(key); field != nil {
+		return field
+	}
+	if field := descriptor.FindFieldByJSONName(key); field != nil {
+		return field
+	}
+	if fieldNum, ok := fieldNumberFromKey(key); ok {
+		return descriptor.FindFieldByNumber(fieldNum)
+	}
+	return nil
+}
+
+// fieldNumberFromKey gets the field number from the name of a generated field (e.g. "7" or "Name_7")
+func fieldNumberFromKey(key string) (int32, bool) {
+	fieldNum, err := strconv.ParseInt(key[strings.LastIndex(key, "_")+1:], 10, 32)
+	if err != nil || !validFieldNumber(int32(fieldNum)) {
+		return 0, false
+	}
+	return int32(fieldNum), true
+}
+
+func isWellKnownType(message *desc.MessageDescriptor) bool {
+	return strings.HasPrefix(message.GetFullyQualifiedName(), "google.protobuf.")
+}
+
+// fieldElements splits the JSON value of a message field into the JSON objects of each message
+func fieldElements(field *desc.FieldDescriptor, value interface{}) ([]map[string]interface{}, error) {
+	var elements []interface{}
+	switch {
+	case value == nil:
+		return nil, nil
+	case field.IsMap():
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object but got %T", value)
+		}
+		// map entries are messages with the key in field 1 and the value in field 2
+		for _, key := range sortedKeys(object) {
+			elements = append(elements, map[string]interface{}{"key": mapKey(field.GetMapKeyType(), key), "value": object[key]})
+		}
+	case field.IsRepeated():
+		array, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an array but got %T", value)
+		}
+		elements = array
+	default:
+		elements = []interface{}{value}
+	}
+
+	var objects []map[string]interface{}
+	for _, element := range elements {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object but got %T", element)
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+func (e *jsonObjectEncoder) encodeMessageField(buffer *proto.Buffer, field *desc.FieldDescriptor, value interface{}) error {
+	objects, err := fieldElements(field, value)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		encoded, err := e.encodeJSONObject(field.GetMessageType(), object)
+		if err != nil {
+			return err
+		}
+		buffer.EncodeVarint(uint64(field.GetNumber())<<3 | proto.WireBytes)
+		buffer.EncodeRawBytes(encoded)
+	}
+	return nil
+}
+
+// encodeAnyField encodes Any messages whose payloads may have fields that aren't in the payload type
+func (e *jsonObjectEncoder) encodeAnyField(buffer *proto.Buffer, field *desc.FieldDescriptor, value interface{}) error {
+	objects, err := fieldElements(field, value)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		typeURL, _ := object["@type"].(string)
+		var payloadType *desc.MessageDescriptor
+		if name := anyTypeName(typeURL); proto.MessageType(name) == nil {
+			payloadType = findInFile(e.file, name, map[*desc.FileDescriptor]bool{})
+		}
+
+		any := proto.NewBuffer(nil)
+		if payloadType == nil {
+			// compiled in types (e.g. the well-known types) can have their own JSON form
+			objectJSON, err := json.Marshal(object)
+			if err != nil {
+				return err
+			}
+			dyn := e.messageFactory.NewDynamicMessage(field.GetMessageType())
+			if err := jsonpb.Unmarshal(bytes.NewReader(objectJSON), dyn); err != nil {
+				return err
+			}
+			if err := any.Marshal(dyn); err != nil {
+				return err
+			}
+		} else {
+			payload := map[string]interface{}{}
+			for key, value := range object {
+				if key != "@type" {
+					payload[key] = value
+				}
+			}
+			encoded, err := e.encodeJSONObject(payloadType, payload)
+			if err != nil {
+				return err
+			}
+			// type_url is field 1 and value is field 2
+			any.EncodeVarint(1<<3 | proto.WireBytes)
+			any.EncodeStringBytes(typeURL)
+			any.EncodeVarint(2<<3 | proto.WireBytes)
+			any.EncodeRawBytes(encoded)
+		}
+		buffer.EncodeVarint(uint64(field.GetNumber())<<3 | proto.WireBytes)
+		buffer.EncodeRawBytes(any.Bytes())
+	}
+	return nil
+}
+
+// mapKey converts a JSON object key into the JSON value of a map entry's key
+func mapKey(keyField *desc.FieldDescriptor, key string) interface{} {
+	if keyField.GetType() == dpb.FieldDescriptorProto_TYPE_BOOL {
+		return key == "true"
+	}
+	// integer keys can be quoted
+	return key
+}
+
+// encodeUnknownField encodes a field which isn't in the descriptor using the type that its JSON value looks like.
+// The observations of the field (which may be nil) decide how values which could be of several types are encoded.
+func encodeUnknownField(buffer *proto.Buffer, fieldNum int32, value interface{}, observed *observedField) error {
+	tag := func(wireType int) uint64 {
+		return uint64(fieldNum)<<3 | uint64(wireType)
+	}
+	switch value := value.(type) {
+	case nil:
+		return nil
+
+	case bool:
+		buffer.EncodeVarint(tag(proto.WireVarint))
+		if value {
+			buffer.EncodeVarint(1)
+		} else {
+			buffer.EncodeVarint(0)
+		}
+
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			buffer.EncodeVarint(tag(proto.WireVarint))
+			buffer.EncodeVarint(uint64(integer))
+			return nil
+		}
+		float, err := value.Float64()
+		if err != nil {
+			return err
+		}
+		buffer.EncodeVarint(tag(proto.WireFixed64))
+		buffer.EncodeFixed64(math.Float64bits(float))
+
+	case string:
+		// 64-bit integers are quoted in JSON but strings of digits are only
+		// encoded as integers if the field has been seen as a varint
+		if integer, err := strconv.ParseInt(value, 10, 64); err == nil && observed != nil && observed.encoding == proto.WireVarint {
+			buffer.EncodeVarint(tag(proto.WireVarint))
+			buffer.EncodeVarint(uint64(integer))
+			return nil
+		}
+		buffer.EncodeVarint(tag(proto.WireBytes))
+		buffer.EncodeStringBytes(value)
+
+	case []interface{}:
+		// encoded as separate (unpacked) values of the same field
+		for _, element := range value {
+			if _, ok := element.([]interface{}); ok {
+				return fmt.Errorf("nested arrays can't be encoded")
+			}
+			if err := encodeUnknownField(buffer, fieldNum, element, observed); err != nil {
+				return err
+			}
+		}
+
+	case map[string]interface{}:
+		if !isUnknownMessage(value, observed) {
+			// map entries are messages with the key in field 1 and the value in field 2
+			for _, key := range sortedKeys(value) {
+				entry := proto.NewBuffer(nil)
+				if err := encodeUnknownField(entry, 1, key, observed.nested(1)); err != nil {
+					return err
+				}
+				if err := encodeUnknownField(entry, 2, value[key], observed.nested(2)); err != nil {
+					return err
+				}
+				buffer.EncodeVarint(tag(proto.WireBytes))
+				buffer.EncodeRawBytes(entry.Bytes())
+			}
+			return nil
+		}
+		message := proto.NewBuffer(nil)
+		for _, key := range sortedKeys(value) {
+			nestedNum, _ := fieldNumberFromKey(key)
+			if err := encodeUnknownField(message, nestedNum, value[key], observed.nested(nestedNum)); err != nil {
+				return err
+			}
+		}
+		buffer.EncodeVarint(tag(proto.WireBytes))
+		buffer.EncodeRawBytes(message.Bytes())
+
+	default:
+		return fmt.Errorf("unexpected JSON value %T", value)
+	}
+	return nil
+}
+
+// isUnknownMessage checks whether an object is a message rather than a map. Without observations of the field,
+// objects whose keys are all field numbers are assumed to be messages as they look the same as integer keyed maps.
+func isUnknownMessage(object map[string]interface{}, observed *observedField) bool {
+	if observed != nil && observed.message != nil {
+		_, _, isMap := observed.mapEntry()
+		return !isMap
+	}
+	for key := range object {
+		if _, ok := fieldNumberFromKey(key); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// nested gets the observations of a field of the message (or map entry) that this field contains
+func (f *observedField) nested(fieldNum int32) *observedField {
+	if f == nil || f.message == nil {
+		return nil
+	}
+	return f.message.fields[fieldNum]
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	var keys []string
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,182 @@
+package proto_decoder
+
+import (
+	"encoding/json"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"testing"
+)
+
+const testHandWrittenProto = `syntax = "proto3";
+package unknown;
+
+message Hand {
+    string text = 1;
+    int64 count = 2;
+    Nested nested = 3;
+    repeated int64 values = 4;
+    double ratio = 5;
+    map<string, string> labels = 6;
+    string code = 7;
+}
+
+message Observed {
+    map<int64, string> names = 8;
+    int64 number = 9;
+}
+
+message Nested {
+    bool flag = 1;
+}
+`
+
+// decodeJSON decodes a message with its full schema
+func decodeJSON(t *testing.T, descriptor *desc.MessageDescriptor, raw []byte) string {
+	dyn := dynamic.NewMessage(descriptor)
+	if err := dyn.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := dyn.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(actual)
+}
+
+func TestEncodeEditedUnknownMessage(t *testing.T) {
+	file := loadTestMessages(t)
+	outer := dynamic.NewMessage(file.FindMessage("unknown.Outer"))
+	err := outer.UnmarshalJSON([]byte(`{
+		"items": {"first": {"name": "a", "count": 1}},
+		"counts": {"x": 1},
+		"inner": {"name": "c", "count": 3}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := outer.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, resolvers := range map[string][]MessageResolver{
+		"no schema":      nil,
+		"partial schema": {staticResolver{file.FindMessage("unknown.PartialOuter")}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			message := &internal.Message{RawMessage: raw, MessageOrigin: internal.ClientMessage}
+			decoded, err := NewDecoder(logrus.New(), resolvers...).Decode("/unknown.Service/Method", message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decodedJSON, err := decoded.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// edit the decoded message like a user would in a fixture
+			var edited map[string]interface{}
+			if err := json.Unmarshal(decodedJSON, &edited); err != nil {
+				t.Fatal(err)
+			}
+			inner := edited["4"]
+			if inner == nil {
+				inner = edited["inner"]
+			}
+			inner.(map[string]interface{})["2"] = "42"
+			message.Message = edited
+
+			encoded, err := NewEncoder(resolvers...).Encode("/unknown.Service/Method", message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := `{"items":{"first":{"name":"a","count":"1"}},"counts":{"x":"1"},"inner":{"name":"c","count":"42"}}`
+			if actual := decodeJSON(t, outer.GetMessageDescriptor(), encoded); actual != expected {
+				t.Errorf("expected %s, got %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestEncodeHandWrittenUnknownMessage(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"hand.proto": testHandWrittenProto}),
+	}
+	files, err := parser.ParseFiles("hand.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a raw message the field types can only come from the JSON values
+	message := &internal.Message{
+		MessageOrigin: internal.ServerMessage,
+		Message: map[string]interface{}{
+			"1":      "text",
+			"Hand_2": -5,
+			"3":      map[string]interface{}{"1": true},
+			"4":      []interface{}{1, 2},
+			"5":      1.5,
+			"6":      map[string]interface{}{"a": "b"},
+			// only fields seen as varints are encoded as integers
+			"7": "12345",
+		},
+	}
+	encoded, err := NewEncoder().Encode("/unknown.Service/Method", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"text":"text","count":"-5","nested":{"flag":true},"values":["1","2"],"ratio":1.5,"labels":{"a":"b"},"code":"12345"}`
+	if actual := decodeJSON(t, files[0].FindMessage("unknown.Hand"), encoded); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestEncodeUnknownFieldsWithObservedTypes(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"hand.proto": testHandWrittenProto}),
+	}
+	files, err := parser.ParseFiles("hand.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fields seen with more than one wire type can't be decoded so are left out of the decoded message
+	raw := proto.NewBuffer(nil)
+	for i, name := range []string{"a", "b"} {
+		entry := proto.NewBuffer(nil)
+		entry.EncodeVarint(1<<3 | proto.WireVarint)
+		entry.EncodeVarint(uint64(i + 1))
+		entry.EncodeVarint(2<<3 | proto.WireBytes)
+		entry.EncodeStringBytes(name)
+		raw.EncodeVarint(8<<3 | proto.WireBytes)
+		raw.EncodeRawBytes(entry.Bytes())
+	}
+	raw.EncodeVarint(8<<3 | proto.WireVarint)
+	raw.EncodeVarint(7)
+	raw.EncodeVarint(9<<3 | proto.WireVarint)
+	raw.EncodeVarint(5)
+	raw.EncodeVarint(9<<3 | proto.WireBytes)
+	raw.EncodeStringBytes("five")
+
+	message := &internal.Message{
+		MessageOrigin: internal.ClientMessage,
+		RawMessage:    raw.Bytes(),
+		// added back by hand with values that could be of several types
+		Message: map[string]interface{}{
+			"8": map[string]interface{}{"3": "c"},
+			"9": "12345",
+		},
+	}
+	encoded, err := NewEncoder().Encode("/unknown.Service/Method", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"names":{"3":"c"},"number":"12345"}`
+	if actual := decodeJSON(t, files[0].FindMessage("unknown.Observed"), encoded); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
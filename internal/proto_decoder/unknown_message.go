@@ -4,32 +4,96 @@ import (
 	"fmt"
 	"github.com/bradleyjkemp/grpc-tools/internal"
 	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/builder"
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/pkg/errors"
-	"regexp"
+	"sort"
+	"strings"
+	"sync"
 )
 
 // When we don't have an actual proto message descriptor, this takes a best effort
 // approach to generating one. It's definitely not perfect but is more useful than nothing.
+//
+// Unknown fields are observed in every message decoded and the observations are merged
+// so that fields which are only sometimes present still get a stable type.
+// Observations are shared by all messages of the same type: for schema-less messages
+// this means every message sent in the same direction of a method.
+type unknownFieldResolver struct {
+	sync.Mutex
+	// the unknown fields seen in each message type, by fully qualified type name
+	observed map[string]*observedMessage
+	// used to decode messages so that extensions aren't mistaken for unknown fields (may be nil)
+	messageFactory *messageFactory
+}
+
+func newUnknownFieldResolver(messageFactory *messageFactory) *unknownFieldResolver {
+	return &unknownFieldResolver{
+		observed:       map[string]*observedMessage{},
+		messageFactory: messageFactory,
+	}
+}
+
+// observedMessage records the unknown fields seen in all instances of a message
+type observedMessage struct {
+	fields map[int32]*observedField
+}
+
+func newObservedMessage() *observedMessage {
+	return &observedMessage{fields: map[int32]*observedField{}}
+}
 
-type unknownFieldResolver struct{}
+// observedField merges everything seen about the values of an unknown field
+type observedField struct {
+	encoding int8
+	// seen with a different wire type so can't be decoded with a single type
+	conflicting bool
+	// seen more than once in a single message
+	repeated bool
+
+	varints  varintStats
+	fixed32s fixedStats
+	fixed64s fixedStats
+	bytes    bytesStats
+	// for length delimited fields which can be decoded as messages
+	message *observedMessage
+	// seen with repeated values of field 1, so this can't be a map
+	duplicateKeys bool
+}
 
-// This takes a message descriptor and enriches it to add any unknown fields present.
+// This takes a message descriptor and enriches it to add any unknown fields present in this or previous messages.
 // This means that all unknown fields will show up in the dump.
 func (u *unknownFieldResolver) enrichDecodeDescriptor(resolved *desc.MessageDescriptor, message *internal.Message) (*desc.MessageDescriptor, error) {
-	decoded := dynamic.NewMessage(resolved)
+	u.Lock()
+	defer u.Unlock()
+	if err := u.observe(resolved, message); err != nil {
+		return nil, err
+	}
+	return u.enrichedDescriptor(resolved)
+}
+
+// observe records the unknown fields in a message of the resolved type
+func (u *unknownFieldResolver) observe(resolved *desc.MessageDescriptor, message *internal.Message) error {
+	decoded := u.messageFactory.NewDynamicMessage(resolved)
 	err := proto.Unmarshal(message.RawMessage, decoded)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal message")
+		return errors.Wrap(err, "failed to unmarshal message")
 	}
+	u.observeMessage(decoded)
+	return nil
+}
+
+// enrichedDescriptor adds all the unknown fields observed so far to the resolved type
+func (u *unknownFieldResolver) enrichedDescriptor(resolved *desc.MessageDescriptor) (*desc.MessageDescriptor, error) {
 	descriptor, err := builder.FromMessage(resolved)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create builder for message")
 	}
-	err = u.enrichMessage(descriptor, decoded)
+	enriched := map[string]*builder.MessageBuilder{resolved.GetFullyQualifiedName(): descriptor}
+	err = u.enrichMessage(descriptor, resolved, enriched)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to enrich decode descriptor")
 	}
@@ -41,147 +105,383 @@ func (u *unknownFieldResolver) enrichDecodeDescriptor(resolved *desc.MessageDesc
 	return decodeDescriptor, nil
 }
 
-func (u *unknownFieldResolver) enrichMessage(descriptor *builder.MessageBuilder, message *dynamic.Message) error {
-	if message == nil {
-		// TODO: I can't seem to reproduce this except in real usage.
-		// Putting a mutex in the dump interceptor also prevents this from occuring
-		// So it seems like a gnarly race condition
-		return errors.New("internal: got nil message")
-	}
-	for _, fieldNum := range message.GetUnknownFields() {
-		generatedFieldName := fmt.Sprintf("%s_%d", descriptor.GetName(), fieldNum)
-		unknownFieldContents := message.GetUnknownField(fieldNum)
-		fieldType, err := u.detectUnknownFieldType(descriptor.GetFile(), generatedFieldName, unknownFieldContents)
-		if err != nil {
-			return errors.Wrap(err, "failed to detect field type")
-		}
-
-		field := builder.NewField(generatedFieldName, fieldType)
-		if err := field.TrySetNumber(fieldNum); err != nil {
-			return errors.Wrap(err, "failed to set field number")
-		}
-		field.SetJsonName(fmt.Sprintf("%d", fieldNum))
-		if len(unknownFieldContents) > 1 {
-			field.SetRepeated()
-		}
-		if err = descriptor.TryAddField(field); err != nil {
-			return errors.Wrap(err, "failed to add field")
-		}
+// observeMessage records the unknown fields of a message and of all the messages nested within it
+func (u *unknownFieldResolver) observeMessage(message *dynamic.Message) {
+	typeName := message.GetMessageDescriptor().GetFullyQualifiedName()
+	observed := u.observed[typeName]
+	if observed == nil {
+		observed = newObservedMessage()
+		u.observed[typeName] = observed
 	}
+	observed.observe(message)
 
 	// recurse into the known fields to check for nested unknown fields
 	for _, fieldDescriptor := range message.GetKnownFields() {
-		if fieldDescriptor.GetMessageType() == nil {
-			// either this is a basic type
-			// or a map which is not yet supported
-			// TODO: support maps
-			continue
-		}
-
-		var nestedMessage proto.Message
 		switch field := message.GetField(fieldDescriptor).(type) {
 		case proto.Message:
-			nestedMessage = field
+			u.observeNested(field)
 
 		// Repeated field: fieldDescriptor.IsRepeated() == true
 		case []interface{}:
-			if len(field) == 0 {
-				// TODO: is this case possible?
-				// Field has no items to analyse
-				continue
+			for _, element := range field {
+				u.observeNested(element)
 			}
-			var ok bool
-			// TODO: should iterate over all the repeated messages and merge the information
-			nestedMessage, ok = field[0].(proto.Message)
-			if !ok {
-				return fmt.Errorf("unknown: repeated field is not of type proto.Message")
+
+		// Map field: fieldDescriptor.IsMap() == true
+		case map[interface{}]interface{}:
+			for _, value := range field {
+				u.observeNested(value)
 			}
+		}
+	}
+}
 
-		default:
-			return fmt.Errorf("unknown nested field type %T", field)
+func (u *unknownFieldResolver) observeNested(value interface{}) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		// a basic type
+		return
+	}
+	dynamicMessage, err := dynamic.AsDynamicMessage(message)
+	if err != nil || dynamicMessage == nil {
+		return
+	}
+	u.observeMessage(dynamicMessage)
+}
+
+func (m *observedMessage) observe(message *dynamic.Message) {
+	for _, fieldNum := range message.GetUnknownFields() {
+		field := m.fields[fieldNum]
+		if field == nil {
+			field = &observedField{encoding: message.GetUnknownField(fieldNum)[0].Encoding}
+			m.fields[fieldNum] = field
 		}
+		field.observe(message.GetUnknownField(fieldNum))
+	}
+}
 
-		nestedMessageDescriptor, err := builder.FromMessage(fieldDescriptor.GetMessageType())
-		if err != nil {
-			return errors.Wrap(err, "failed to create builder")
+func (f *observedField) observe(values []dynamic.UnknownField) {
+	if len(values) > 1 {
+		f.repeated = true
+	}
+
+	seenKeys := map[string]bool{}
+	for _, value := range values {
+		if value.Encoding != f.encoding {
+			f.conflicting = true
+			continue
+		}
+		switch value.Encoding {
+		case proto.WireVarint:
+			f.varints.observe(value.Value)
+		case proto.WireFixed32:
+			f.fixed32s.observe32(uint32(value.Value))
+		case proto.WireFixed64:
+			f.fixed64s.observe64(value.Value)
 		}
+		if value.Encoding != proto.WireBytes {
+			continue
+		}
+		f.bytes.observe(value.Contents)
 
-		dynamicNestedMessage, err := dynamic.AsDynamicMessage(nestedMessage)
-		if err != nil {
-			return errors.Wrap(err, "failed to convert nested message to dynamic")
+		// embedded messages are encoded on the wire as strings
+		// so try to decode this string as a message
+		if f.bytes.notMessage {
+			continue
+		}
+		nested, err := decodeUnknownMessage(value.Contents)
+		if err != nil || !f.bytes.validMessage(nested) {
+			// looks like it wasn't a valid proto message
+			f.bytes.notMessage = true
+			continue
 		}
+		if f.message == nil {
+			f.message = newObservedMessage()
+		}
+		f.message.observe(nested)
 
-		err = u.enrichMessage(nestedMessageDescriptor, dynamicNestedMessage)
-		if err != nil {
-			return errors.Wrapf(err, "failed to search nested field %s", fieldDescriptor.GetName())
+		// maps are encoded as repeated messages with unique keys in field 1
+		if key := nested.GetUnknownField(1); len(key) == 1 {
+			keyString := fmt.Sprint(key[0])
+			if seenKeys[keyString] {
+				f.duplicateKeys = true
+			}
+			seenKeys[keyString] = true
+		}
+	}
+}
+
+// enrichMessage adds the observed unknown fields to a message and to the message types of its fields.
+// enriched records the builders already used for each message type so that each is only enriched once.
+func (u *unknownFieldResolver) enrichMessage(descriptor *builder.MessageBuilder, messageDescriptor *desc.MessageDescriptor, enriched map[string]*builder.MessageBuilder) error {
+	if observed := u.observed[messageDescriptor.GetFullyQualifiedName()]; observed != nil {
+		if err := u.addUnknownFields(descriptor, observed); err != nil {
+			return err
+		}
+	}
+
+	for _, fieldDescriptor := range messageDescriptor.GetFields() {
+		messageType := fieldDescriptor.GetMessageType()
+		if fieldDescriptor.IsMap() {
+			messageType = fieldDescriptor.GetMapValueType().GetMessageType()
 		}
-		fieldDescriptorBuilder, err := builder.FromField(fieldDescriptor)
+		if messageType == nil || u.observed[messageType.GetFullyQualifiedName()] == nil {
+			// either this is a basic type (or a map of basic types)
+			// or a message that has never been seen
+			continue
+		}
+
+		nestedMessageDescriptor, ok := enriched[messageType.GetFullyQualifiedName()]
+		if !ok {
+			var err error
+			nestedMessageDescriptor, err = messageBuilder(descriptor.GetFile(), messageType)
+			if err != nil {
+				return errors.Wrap(err, "failed to create builder")
+			}
+			enriched[messageType.GetFullyQualifiedName()] = nestedMessageDescriptor
+			err = u.enrichMessage(nestedMessageDescriptor, messageType, enriched)
+			if err != nil {
+				return errors.Wrapf(err, "failed to search nested field %s", fieldDescriptor.GetName())
+			}
+		}
+
+		fieldDescriptorBuilder, err := enrichedField(fieldDescriptor, nestedMessageDescriptor)
 		if err != nil {
 			return errors.Wrapf(err, "failed to create builder for field %s", fieldDescriptor.GetName())
 		}
-		fieldDescriptorBuilder.SetType(builder.FieldTypeMessage(nestedMessageDescriptor))
-		descriptor.RemoveField(fieldDescriptor.GetName()).AddField(fieldDescriptorBuilder)
+		descriptor.RemoveField(fieldDescriptor.GetName())
+		if fieldDescriptor.IsMap() {
+			// the map entry type is replaced by the one created with the new field
+			descriptor.RemoveNestedMessage(fieldDescriptor.GetMessageType().GetName())
+		}
+		descriptor.AddField(fieldDescriptorBuilder)
 	}
 
 	return nil
 }
 
-var (
-	asciiPattern = regexp.MustCompile(`^[ -~]*$`)
-)
+// addUnknownFields adds generated fields for all the unknown fields observed in a message
+func (u *unknownFieldResolver) addUnknownFields(descriptor *builder.MessageBuilder, observed *observedMessage) error {
+	var fieldNums []int
+	for fieldNum := range observed.fields {
+		fieldNums = append(fieldNums, int(fieldNum))
+	}
+	sort.Ints(fieldNums)
 
-func (u *unknownFieldResolver) detectUnknownFieldType(file *builder.FileBuilder, fieldName string, fields []dynamic.UnknownField) (*builder.FieldType, error) {
-	field := fields[0]
-	switch field.Encoding {
-	// Used for: int32, int64, uint32, uint64, sint32, sint64, bool, enum
-	case proto.WireVarint:
-		return builder.FieldTypeInt64(), nil
+	for _, fieldNum := range fieldNums {
+		generatedFieldName := fmt.Sprintf("%s_%d", descriptor.GetName(), fieldNum)
+		observedField := observed.fields[int32(fieldNum)]
+		if observedField.conflicting || descriptor.GetField(generatedFieldName) != nil {
+			// no single type can decode all of the values so leave this as an unknown field
+			continue
+		}
+		if hasFieldNumber(descriptor, int32(fieldNum)) ||
+			u.messageFactory.GetExtensionRegistry().FindExtension(builder.GetFullyQualifiedName(descriptor), int32(fieldNum)) != nil {
+			// the field was unknown until the message's definition (or its extensions) were reloaded
+			continue
+		}
 
-	// Used for: fixed32, sfixed32, float
-	case proto.WireFixed32:
-		return builder.FieldTypeFloat(), nil
+		var field *builder.FieldBuilder
+		if key, value, ok := observedField.mapEntry(); ok {
+			keyType := builder.FieldTypeInt64()
+			if key.encoding == proto.WireBytes {
+				keyType = builder.FieldTypeString()
+			}
+			valueType := builder.FieldTypeString()
+			comment := " map with no values seen"
+			if value != nil {
+				var err error
+				var guess typeGuess
+				valueType, guess, err = u.detectUnknownFieldType(descriptor.GetFile(), generatedFieldName+"_value", value)
+				if err != nil {
+					return errors.Wrap(err, "failed to detect map value type")
+				}
+				comment = " map of " + guess.String()
+			}
+			field = builder.NewMapField(generatedFieldName, keyType, valueType)
+			field.SetComments(builder.Comments{LeadingComment: comment})
+		} else {
+			fieldType, guess, err := u.detectUnknownFieldType(descriptor.GetFile(), generatedFieldName, observedField)
+			if err != nil {
+				return errors.Wrap(err, "failed to detect field type")
+			}
+			field = builder.NewField(generatedFieldName, fieldType)
+			if observedField.repeated || guess.packed {
+				field.SetRepeated()
+			}
+			field.SetComments(builder.Comments{LeadingComment: " " + guess.String()})
+		}
+
+		if err := field.TrySetNumber(int32(fieldNum)); err != nil {
+			return errors.Wrap(err, "failed to set field number")
+		}
+		field.SetJsonName(fmt.Sprintf("%d", fieldNum))
+		if err := descriptor.TryAddField(field); err != nil {
+			return errors.Wrap(err, "failed to add field")
+		}
+	}
+	return nil
+}
 
-	// Used for: fixed64, sfixed64, double
-	case proto.WireFixed64:
-		return builder.FieldTypeDouble(), nil
+// UnknownFieldTypes returns the guessed type of each field of a decoded message that wasn't in its definition,
+// keyed by the path of JSON field names to the field (e.g. "details.5").
+func UnknownFieldTypes(message *dynamic.Message) map[string]string {
+	types := map[string]string{}
+	addUnknownFieldTypes(types, "", message)
+	if len(types) == 0 {
+		return nil
+	}
+	return types
+}
 
-	// Used for: string, bytes, embedded messages, packed repeated fields
-	case proto.WireBytes:
-		if asciiPattern.Match(field.Contents) {
-			// highly unlikely that an entirely ASCII string is actually an embedded proto message
-			// TODO: make this heuristic cleverer
-			return builder.FieldTypeString(), nil
+func addUnknownFieldTypes(types map[string]string, prefix string, message *dynamic.Message) {
+	if message == nil {
+		return
+	}
+	for _, field := range message.GetKnownFields() {
+		if !message.HasField(field) {
+			continue
 		}
-		// embedded messages are encoded on the wire as strings
-		// so try to decode this string as a message
-		dyn, err := dynamic.AsDynamicMessage(&empty.Empty{})
-		if err != nil {
-			panic(err)
+		path := prefix + field.GetJSONName()
+		// generated fields are named by their number and commented with the guess used to create them
+		if field.GetJSONName() == fmt.Sprint(field.GetNumber()) {
+			if comment := strings.TrimSpace(field.GetSourceInfo().GetLeadingComments()); comment != "" {
+				types[path] = comment
+			}
 		}
-		err = proto.Unmarshal(field.Contents, dyn)
-		if err != nil {
-			// looks like it wasn't a valid proto message
-			return builder.FieldTypeString(), nil
+
+		var values []interface{}
+		switch value := message.GetField(field).(type) {
+		case []interface{}:
+			values = value
+		case map[interface{}]interface{}:
+			for _, element := range value {
+				values = append(values, element)
+			}
+		default:
+			values = []interface{}{value}
 		}
-		// TODO: check that the unmarshalled message doesn't have any illegal field numbers
+		for _, value := range values {
+			if nested, ok := value.(*dynamic.Message); ok {
+				addUnknownFieldTypes(types, path+".", nested)
+			}
+		}
+	}
+}
+
+func hasFieldNumber(descriptor *builder.MessageBuilder, fieldNum int32) bool {
+	for _, child := range descriptor.GetChildren() {
+		switch child := child.(type) {
+		case *builder.FieldBuilder:
+			if child.GetNumber() == fieldNum {
+				return true
+			}
+		case *builder.OneOfBuilder:
+			for _, choice := range child.GetChildren() {
+				if field, ok := choice.(*builder.FieldBuilder); ok && field.GetNumber() == fieldNum {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
 
-		// probably is an embedded message
-		descriptor := builder.NewMessage(fieldName)
-		err = u.enrichMessage(descriptor, dyn)
+// messageBuilder finds the builder for a message type in the file being built so that it is enriched in place.
+// Messages from other files are copied into a new builder.
+func messageBuilder(file *builder.FileBuilder, messageType *desc.MessageDescriptor) (*builder.MessageBuilder, error) {
+	if file == nil || file.GetName() != messageType.GetFile().GetName() {
+		return builder.FromMessage(messageType)
+	}
+	name := strings.TrimPrefix(messageType.GetFullyQualifiedName(), messageType.GetFile().GetPackage()+".")
+	parts := strings.Split(name, ".")
+	message := file.GetMessage(parts[0])
+	for _, part := range parts[1:] {
+		if message == nil {
+			break
+		}
+		message = message.GetNestedMessage(part)
+	}
+	if message == nil {
+		return builder.FromMessage(messageType)
+	}
+	return message, nil
+}
+
+// enrichedField creates a copy of a message (or map of messages) field using the enriched message type
+func enrichedField(fieldDescriptor *desc.FieldDescriptor, messageType *builder.MessageBuilder) (*builder.FieldBuilder, error) {
+	if !fieldDescriptor.IsMap() {
+		field, err := builder.FromField(fieldDescriptor)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to detect unknown field %s", fieldName)
+			return nil, err
 		}
+		return field.SetType(builder.FieldTypeMessage(messageType)), nil
+	}
 
-		if file != nil {
-			// TODO: why would file ever be nil?
-			err = file.TryAddMessage(descriptor)
-			if err != nil {
-				return nil, errors.Wrapf(err, "failed to add nested message %s", fieldName)
-			}
+	keyType := builder.FieldTypeScalar(fieldDescriptor.GetMapKeyType().GetType())
+	field := builder.NewMapField(fieldDescriptor.GetName(), keyType, builder.FieldTypeMessage(messageType))
+	if err := field.TrySetNumber(fieldDescriptor.GetNumber()); err != nil {
+		return nil, err
+	}
+	field.SetJsonName(fieldDescriptor.GetJSONName())
+	field.Options = fieldDescriptor.GetFieldOptions()
+	return field, nil
+}
+
+// Maps are encoded on the wire as repeated messages with the key in field 1 and the value in field 2.
+// mapEntry checks whether a repeated unknown field looks like a map and, if so, returns the key and value fields.
+func (f *observedField) mapEntry() (key, value *observedField, ok bool) {
+	// a single entry is indistinguishable from a message with two fields
+	if !f.repeated || f.duplicateKeys || f.encoding != proto.WireBytes || f.bytes.notMessage || f.message == nil {
+		return nil, nil, false
+	}
+	for fieldNum, field := range f.message.fields {
+		if (fieldNum != 1 && fieldNum != 2) || field.repeated || field.conflicting {
+			return nil, nil, false
 		}
-		return builder.FieldTypeMessage(descriptor), nil
+	}
 
+	// map keys can only be integers or strings
+	key = f.message.fields[1]
+	switch {
+	case key == nil:
+		return nil, nil, false
+	case key.encoding == proto.WireVarint:
+	case key.encoding == proto.WireBytes && !key.bytes.notString:
 	default:
-		return nil, errors.Errorf("Unsupported wire type id %v", field.Encoding)
+		return nil, nil, false
+	}
+	return key, f.message.fields[2], true
+}
+
+// decodeUnknownMessage decodes a message without a descriptor so that all of its fields are unknown
+func decodeUnknownMessage(contents []byte) (*dynamic.Message, error) {
+	dyn, err := dynamic.AsDynamicMessage(&empty.Empty{})
+	if err != nil {
+		panic(err)
+	}
+	err = proto.Unmarshal(contents, dyn)
+	return dyn, err
+}
+
+func (u *unknownFieldResolver) detectUnknownFieldType(file *builder.FileBuilder, fieldName string, field *observedField) (*builder.FieldType, typeGuess, error) {
+	guess := field.guessType()
+	if guess.fieldType != dpb.FieldDescriptorProto_TYPE_MESSAGE {
+		return builder.FieldTypeScalar(guess.fieldType), guess, nil
+	}
+
+	// probably is an embedded message
+	descriptor := builder.NewMessage(fieldName)
+	if file != nil {
+		// add the message to the file first so that messages nested within it are added too
+		err := file.TryAddMessage(descriptor)
+		if err != nil {
+			return nil, guess, errors.Wrapf(err, "failed to add nested message %s", fieldName)
+		}
+	}
+	err := u.addUnknownFields(descriptor, field.message)
+	if err != nil {
+		return nil, guess, errors.Wrapf(err, "failed to detect unknown field %s", fieldName)
 	}
+	return builder.FieldTypeMessage(descriptor), guess, nil
 }
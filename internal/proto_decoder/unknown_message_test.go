@@ -0,0 +1,190 @@
+package proto_decoder
+
+import (
+	"encoding/json"
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"testing"
+)
+
+// the full schema used to encode messages and a partial one used to decode them
+const testUnknownFieldsProto = `syntax = "proto3";
+package unknown;
+
+message Outer {
+    map<string, Inner> items = 1;
+    map<string, int64> counts = 2;
+    map<int64, string> names = 3;
+    Inner inner = 4;
+}
+
+message Inner {
+    string name = 1;
+    int64 count = 2;
+}
+
+message PartialOuter {
+    map<string, PartialInner> items = 1;
+    PartialInner inner = 4;
+}
+
+message PartialInner {
+    string name = 1;
+}
+`
+
+type staticResolver struct {
+	descriptor *desc.MessageDescriptor
+}
+
+func (s staticResolver) resolveEncoded(string, *internal.Message) (*desc.MessageDescriptor, error) {
+	return s.descriptor, nil
+}
+
+func (s staticResolver) resolveDecoded(string, *internal.Message) (*desc.MessageDescriptor, error) {
+	return s.descriptor, nil
+}
+
+func loadTestMessages(t *testing.T) *desc.FileDescriptor {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"unknown.proto": testUnknownFieldsProto}),
+	}
+	files, err := parser.ParseFiles("unknown.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files[0]
+}
+
+func TestDecodeMapsWithUnknownFields(t *testing.T) {
+	file := loadTestMessages(t)
+	outer := dynamic.NewMessage(file.FindMessage("unknown.Outer"))
+	err := outer.UnmarshalJSON([]byte(`{
+		"items": {"first": {"name": "a", "count": 1}, "second": {"name": "b", "count": 2}},
+		"counts": {"x": 1, "y": 2},
+		"names": {"1": "one", "2": "two"},
+		"inner": {"name": "c", "count": 3}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := outer.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(logrus.New(), staticResolver{file.FindMessage("unknown.PartialOuter")})
+	decoded, err := decoder.Decode("/unknown.Service/Method", &internal.Message{RawMessage: raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := decoded.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(actual, &result); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		// unknown fields inside known map values
+		"items": map[string]interface{}{
+			"first":  map[string]interface{}{"name": "a", "2": "1"},
+			"second": map[string]interface{}{"name": "b", "2": "2"},
+		},
+		"inner": map[string]interface{}{"name": "c", "2": "3"},
+		// unknown fields which look like maps
+		"2": map[string]interface{}{"x": "1", "y": "2"},
+		"3": map[string]interface{}{"1": "one", "2": "two"},
+	}
+	expectedJSON, _ := json.Marshal(expected)
+	actualJSON, _ := json.Marshal(result)
+	if string(expectedJSON) != string(actualJSON) {
+		t.Errorf("expected %s, got %s", expectedJSON, actualJSON)
+	}
+
+	// the guessed types are reported by the path to each unknown field
+	expectedTypes := map[string]string{
+		"items.2": "int64 (70% confidence): integer values",
+		"inner.2": "int64 (70% confidence): integer values",
+		"2":       "map of int64 (70% confidence): integer values",
+		"3":       "map of string (82% confidence): printable UTF-8",
+	}
+	if types := UnknownFieldTypes(decoded); !reflect.DeepEqual(types, expectedTypes) {
+		t.Errorf("expected types %v, got %v", expectedTypes, types)
+	}
+}
+
+const testMergeProto = `syntax = "proto3";
+package merge;
+
+message Message {
+    repeated Element elements = 1;
+    repeated string tags = 2;
+}
+
+message Element {
+    string name = 1;
+    Detail detail = 2;
+    int64 count = 3;
+}
+
+message Detail {
+    int64 id = 1;
+}
+`
+
+func TestMergeUnknownFieldsAcrossMessages(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"merge.proto": testMergeProto}),
+	}
+	files, err := parser.ParseFiles("merge.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(message string) []byte {
+		dyn := dynamic.NewMessage(files[0].FindMessage("merge.Message"))
+		if err := dyn.UnmarshalJSON([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := dyn.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	// decode without any schema so that every field is unknown
+	decoder := NewDecoder(logrus.New())
+	decode := func(raw []byte) string {
+		decoded, err := decoder.Decode("/merge.Service/Method", &internal.Message{RawMessage: raw, MessageOrigin: internal.ClientMessage})
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := decoded.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(actual)
+	}
+
+	// the detail is only present in the second element and tags are only repeated in the second message
+	first := encode(`{"elements": [{"name": "a", "count": 1}, {"name": "b", "detail": {"id": 2}}], "tags": ["x"]}`)
+	second := encode(`{"tags": ["y", "z"]}`)
+
+	expected := `{"1":[{"1":"a","3":"1"},{"1":"b","2":{"1":"2"}}],"2":"x"}`
+	if actual := decode(first); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+	decode(second)
+	// now tags are known to be repeated
+	expected = `{"1":[{"1":"a","3":"1"},{"1":"b","2":{"1":"2"}}],"2":["x"]}`
+	if actual := decode(first); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
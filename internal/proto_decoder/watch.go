@@ -0,0 +1,64 @@
+package proto_decoder
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Watch polls the files the resolvers were loaded from and reloads them whenever they change.
+// If onReload is non-nil then it's called after each reload.
+func Watch(resolvers []MessageResolver, interval time.Duration, onReload func()) {
+	for _, resolver := range resolvers {
+		if d, ok := resolver.(*descriptorResolver); ok && d.load != nil {
+			// changes made as soon as this returns must be noticed so check the current versions first
+			go d.watch(statFiles(d.sourceFiles()), interval, onReload)
+		}
+	}
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func (d *descriptorResolver) watch(versions map[string]fileVersion, interval time.Duration, onReload func()) {
+	for range time.Tick(interval) {
+		latest := statFiles(d.sourceFiles())
+		if reflect.DeepEqual(versions, latest) {
+			continue
+		}
+		versions = latest
+		log.Print("Reloading changed proto definitions")
+		if err := d.reload(); err != nil {
+			log.Printf("ERROR - Failed to reload proto definitions (the previous definitions are still used): %v", err)
+			continue
+		}
+		if onReload != nil {
+			onReload()
+		}
+	}
+}
+
+// reload replaces the definitions known to the resolver with the latest version of its files
+func (d *descriptorResolver) reload() error {
+	files, err := d.load()
+	if err != nil {
+		return err
+	}
+	d.setFiles(files)
+	return nil
+}
+
+func statFiles(files []string) map[string]fileVersion {
+	versions := map[string]fileVersion{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		versions[file] = fileVersion{info.ModTime(), info.Size()}
+	}
+	return versions
+}
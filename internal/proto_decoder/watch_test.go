@@ -0,0 +1,149 @@
+package proto_decoder
+
+import (
+	"github.com/bradleyjkemp/grpc-tools/internal"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testReloadProto = `syntax = "proto2";
+package reload;
+
+service Service {
+    rpc Call(Request) returns (Request);
+}
+
+message Request {
+    optional string name = 1;%s
+    extensions 100 to 200;
+}
+`
+
+const testReloadExtensionProto = `syntax = "proto2";
+package reload.more;
+
+import "reload.proto";
+
+extend reload.Request {
+    optional string tag = 100;
+}
+`
+
+func TestWatchReloadsProtos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeProto := func(name, contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeProto("reload.proto", strings.Replace(testReloadProto, "%s", "", 1))
+
+	resolvers, err := NewResolvers(ProtoSources{Roots: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := NewDecoder(logrus.New(), resolvers...)
+	// name = "a", count = 5, tag = "t"
+	message := &internal.Message{RawMessage: []byte{10, 1, 'a', 16, 5, 162, 6, 1, 't'}, MessageOrigin: internal.ClientMessage}
+	decode := func() string {
+		decoded, err := decoder.Decode("/reload.Service/Call", message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := decoded.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(actual)
+	}
+	if actual := decode(); strings.Contains(actual, "count") {
+		t.Fatalf("unexpected count field before reloading: %s", actual)
+	}
+
+	// written before watching so that both new files are loaded by a single reload
+	writeProto("more.proto", testReloadExtensionProto)
+	reloaded := make(chan struct{}, 1)
+	Watch(resolvers, 10*time.Millisecond, func() {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	})
+	writeProto("reload.proto", strings.Replace(testReloadProto, "%s", "\n    optional int64 count = 2;", 1))
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("protos weren't reloaded")
+	}
+
+	// fields that were unknown before reloading are decoded using their new definitions
+	expected := `{"name":"a","count":"5","[reload.more.tag]":"t"}`
+	if actual := decode(); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestWatchForgetsRemovedExtensions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeProto := func(name, contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeProto("reload.proto", strings.Replace(testReloadProto, "%s", "", 1))
+	writeProto("more.proto", testReloadExtensionProto)
+
+	resolvers, err := NewResolvers(ProtoSources{Roots: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := NewDecoder(logrus.New(), resolvers...)
+	// name = "a", tag = "t"
+	message := &internal.Message{RawMessage: []byte{10, 1, 'a', 162, 6, 1, 't'}, MessageOrigin: internal.ClientMessage}
+	decode := func() string {
+		decoded, err := decoder.Decode("/reload.Service/Call", message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := decoded.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(actual)
+	}
+	if actual := decode(); !strings.Contains(actual, "[reload.more.tag]") {
+		t.Fatalf("expected tag extension before reloading: %s", actual)
+	}
+
+	reloaded := make(chan struct{}, 1)
+	Watch(resolvers, 10*time.Millisecond, func() {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	})
+	writeProto("more.proto", strings.Replace(testReloadExtensionProto, "optional string tag = 100;", "optional string other = 101;", 1))
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("protos weren't reloaded")
+	}
+
+	// the extension no longer exists so must not be used to decode the field
+	if actual := decode(); strings.Contains(actual, "[reload.more.tag]") {
+		t.Errorf("removed extension still used: %s", actual)
+	}
+}
@@ -0,0 +1,134 @@
+package proto_descriptor
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"strings"
+)
+
+// the numbers of the features field in each options message
+// (golang/protobuf v1.3 doesn't know about editions so features are unrecognized fields)
+const (
+	fileFeatures    = 50
+	messageFeatures = 12
+	fieldFeatures   = 21
+	enumFeatures    = 7
+)
+
+// featureValues are the names of the FeatureSet fields and their values
+var featureValues = map[uint64][]string{
+	1: {"field_presence", "EXPLICIT", "IMPLICIT", "LEGACY_REQUIRED"},
+	2: {"enum_type", "OPEN", "CLOSED"},
+	3: {"repeated_field_encoding", "PACKED", "EXPANDED"},
+	4: {"utf8_validation", "", "VERIFY", "NONE"},
+	5: {"message_encoding", "LENGTH_PREFIXED", "DELIMITED"},
+	6: {"json_format", "ALLOW", "LEGACY_BEST_EFFORT"},
+}
+
+// editionsWarning explains that a file using editions is loaded as proto2, naming the features that are ignored
+func editionsWarning(path string, file *descriptor.FileDescriptorProto) string {
+	warning := fmt.Sprintf("WARNING - %s in %s uses protobuf editions which are loaded as proto2 so features are ignored", file.GetName(), path)
+	if features := editionsFeatures(file); len(features) > 0 {
+		warning += ": " + strings.Join(features, ", ")
+	}
+	return warning
+}
+
+// editionsFeatures lists the features set in a file (e.g. "features.message_encoding = DELIMITED on test.Outer.field")
+func editionsFeatures(file *descriptor.FileDescriptorProto) []string {
+	var features []string
+	add := func(element string, options []byte, featuresField uint64) {
+		for _, feature := range decodeFeatures(options, featuresField) {
+			features = append(features, fmt.Sprintf("%s on %s", feature, element))
+		}
+	}
+	addFields := func(parent string, fields []*descriptor.FieldDescriptorProto) {
+		for _, field := range fields {
+			if field.Options != nil {
+				add(parent+"."+field.GetName(), field.Options.XXX_unrecognized, fieldFeatures)
+			}
+		}
+	}
+	addEnums := func(parent string, enums []*descriptor.EnumDescriptorProto) {
+		for _, enum := range enums {
+			if enum.Options != nil {
+				add(parent+"."+enum.GetName(), enum.Options.XXX_unrecognized, enumFeatures)
+			}
+		}
+	}
+	var addMessages func(parent string, messages []*descriptor.DescriptorProto)
+	addMessages = func(parent string, messages []*descriptor.DescriptorProto) {
+		for _, message := range messages {
+			name := parent + "." + message.GetName()
+			if message.Options != nil {
+				add(name, message.Options.XXX_unrecognized, messageFeatures)
+			}
+			addFields(name, message.Field)
+			addFields(name, message.Extension)
+			addEnums(name, message.EnumType)
+			addMessages(name, message.NestedType)
+		}
+	}
+
+	if file.Options != nil {
+		add(file.GetName(), file.Options.XXX_unrecognized, fileFeatures)
+	}
+	addFields(file.GetPackage(), file.Extension)
+	addEnums(file.GetPackage(), file.EnumType)
+	addMessages(file.GetPackage(), file.MessageType)
+	return features
+}
+
+// decodeFeatures finds the features set in the encoded fields of an options message
+func decodeFeatures(options []byte, featuresField uint64) []string {
+	var features []string
+	decodeFields(options, func(number, _ uint64, featureSet []byte) {
+		if number != featuresField || featureSet == nil {
+			return
+		}
+		decodeFields(featureSet, func(number, value uint64, _ []byte) {
+			values, ok := featureValues[number]
+			if !ok || value == 0 || value >= uint64(len(values)) || values[value] == "" {
+				features = append(features, fmt.Sprintf("features.%d = %d", number, value))
+				return
+			}
+			features = append(features, fmt.Sprintf("features.%s = %s", values[0], values[value]))
+		})
+	})
+	return features
+}
+
+// decodeFields calls f with each varint or length delimited field of an encoded message
+// (bytes is nil for varints). Any other fields are skipped.
+func decodeFields(encoded []byte, f func(number, varint uint64, bytes []byte)) {
+	buffer := proto.NewBuffer(encoded)
+	for {
+		tag, err := buffer.DecodeVarint()
+		if err != nil {
+			// the end of the message
+			return
+		}
+		var varint uint64
+		var bytes []byte
+		switch tag & 7 {
+		case proto.WireVarint:
+			varint, err = buffer.DecodeVarint()
+		case proto.WireFixed64:
+			_, err = buffer.DecodeFixed64()
+		case proto.WireBytes:
+			bytes, err = buffer.DecodeRawBytes(false)
+		case proto.WireFixed32:
+			_, err = buffer.DecodeFixed32()
+		default:
+			// groups aren't used by options so the rest can't be decoded
+			return
+		}
+		if err != nil {
+			return
+		}
+		if tag&7 == proto.WireVarint || tag&7 == proto.WireBytes {
+			f(tag>>3, varint, bytes)
+		}
+	}
+}
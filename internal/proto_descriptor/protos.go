@@ -2,74 +2,188 @@ package proto_descriptor
 
 import (
 	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/protoparse"
+	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 )
 
-func LoadProtoDescriptors(descriptorPaths ...string) (map[string]*desc.MethodDescriptor, error) {
+// matches the edition declaration of files using protobuf editions (which protoparse can't parse)
+var editionDeclaration = regexp.MustCompile(`(?m)^\s*edition\s*=`)
+
+// LoadProtoDescriptors loads descriptor set files (e.g. written by protoc --descriptor_set_out --include_imports).
+// Paths that aren't files are looked up in the descriptors compiled into this binary.
+func LoadProtoDescriptors(descriptorPaths ...string) ([]*desc.FileDescriptor, error) {
 	descriptors := []*desc.FileDescriptor{}
 	for _, path := range descriptorPaths {
-		descriptor, err := desc.LoadFileDescriptor(path)
+		contents, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			file, err := desc.LoadFileDescriptor(path)
+			if err != nil {
+				return nil, err
+			}
+			descriptors = append(descriptors, file)
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
-		descriptors = append(descriptors, descriptor)
+
+		descriptorSet := &descriptor.FileDescriptorSet{}
+		if err := proto.Unmarshal(contents, descriptorSet); err != nil {
+			return nil, fmt.Errorf("failed to decode descriptor set %s: %v", path, err)
+		}
+		for _, file := range descriptorSet.File {
+			if file.GetSyntax() == "editions" {
+				log.Print(editionsWarning(path, file))
+			}
+		}
+		files, err := desc.CreateFileDescriptorsFromSet(descriptorSet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load descriptor set %s: %v", path, err)
+		}
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			descriptors = append(descriptors, files[name])
+		}
 	}
 
-	return convertDescriptorsToMap(descriptors), nil
+	return descriptors, nil
+}
+
+// LoadOptions configures how LoadProtoDirectories parses .proto files
+type LoadOptions struct {
+	// ImportPaths are searched for imported files that aren't in any of the roots.
+	// Files compiled into grpc-tools (e.g. google/protobuf/*.proto) can always be imported.
+	ImportPaths []string
+	// Strict makes any file that can't be parsed an error rather than being skipped
+	Strict bool
+}
+
+// LoadReport summarises which files LoadProtoDirectories loaded
+type LoadReport struct {
+	Loaded   []string
+	Failed   map[string]error
+	Services []string
+}
+
+func (r *LoadReport) String() string {
+	report := &strings.Builder{}
+	fmt.Fprintf(report, "Loaded %d of %d .proto files", len(r.Loaded), len(r.Loaded)+len(r.Failed))
+	failed := make([]string, 0, len(r.Failed))
+	for path := range r.Failed {
+		failed = append(failed, path)
+	}
+	sort.Strings(failed)
+	for _, path := range failed {
+		fmt.Fprintf(report, "\n  skipped %s: %v", path, r.Failed[path])
+	}
+	fmt.Fprintf(report, "\nFound %d services: %s", len(r.Services), strings.Join(r.Services, ", "))
+	return report.String()
 }
 
 // recursively walks through all files in the given directories and
-// finds .proto files that contains service definitions
-func LoadProtoDirectories(roots ...string) (map[string]*desc.MethodDescriptor, error) {
-	var servicesFiles []*desc.FileDescriptor
+// finds .proto files that contains service definitions.
+// Files without services are also returned (if they can be parsed) as they may define
+// the message types used in google.protobuf.Any fields.
+func LoadProtoDirectories(options LoadOptions, roots ...string) ([]*desc.FileDescriptor, *LoadReport, error) {
+	var servicesFiles, otherFiles []*desc.FileDescriptor
+	report := &LoadReport{Failed: map[string]error{}}
 
 	parser := protoparse.Parser{
-		ImportPaths:      roots,
+		ImportPaths:      append(append([]string{}, roots...), options.ImportPaths...),
 		InferImportPaths: true, // attempt to be clever
+		LookupImport:     desc.LoadFileDescriptor,
 	}
 
 	// scan all roots for .proto files containing gRPC service definitions
 	for _, root := range roots {
 		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-			if filepath.Ext(path) == ".proto" {
-				relpath, err := filepath.Rel(root, path)
-				if err != nil {
-					return err
-				}
-				descs, err := parser.ParseFilesButDoNotLink(path)
-				if err != nil {
-					// oh well we won't worry though
-					fmt.Fprintf(os.Stderr, "Skipping %s due to parse error %s", path, err)
-					return nil
-				}
-				if len(descs[0].Service) > 0 {
-					// this file is interesting so
-					fileDesc, err := parser.ParseFiles(relpath)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "Skipping %s due to parse error %s", path, err)
-						return nil
-					}
-					servicesFiles = append(servicesFiles, fileDesc[0])
+			if err != nil {
+				return err
+			}
+			if filepath.Ext(path) != ".proto" {
+				return nil
+			}
+			relpath, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			fileDesc, err := parseFile(parser, path, relpath)
+			if err != nil {
+				if options.Strict {
+					return fmt.Errorf("failed to parse %s: %v", path, err)
 				}
+				report.Failed[path] = err
+				return nil
+			}
+			report.Loaded = append(report.Loaded, path)
+			if len(fileDesc.GetServices()) == 0 {
+				// only needed for the message types it defines
+				otherFiles = append(otherFiles, fileDesc)
+				return nil
+			}
+			servicesFiles = append(servicesFiles, fileDesc)
+			for _, service := range fileDesc.GetServices() {
+				report.Services = append(report.Services, service.GetFullyQualifiedName())
 			}
 			return nil
 		})
 		if err != nil {
-			return nil, err
+			return nil, report, err
 		}
 	}
 
 	if len(servicesFiles) == 0 {
-		return nil, fmt.Errorf("no service definitions found")
+		return nil, report, fmt.Errorf("no service definitions found")
+	}
+
+	return append(servicesFiles, otherFiles...), report, nil
+}
+
+// ProtoFiles lists all the .proto files in the given directories
+func ProtoFiles(dirs ...string) []string {
+	var files []string
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && filepath.Ext(path) == ".proto" {
+				files = append(files, path)
+			}
+			return nil
+		})
 	}
+	return files
+}
 
-	return convertDescriptorsToMap(servicesFiles), nil
+func parseFile(parser protoparse.Parser, path, relpath string) (*desc.FileDescriptor, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// protoparse panics when parsing editions so they have to be detected first
+	if editionDeclaration.Match(contents) {
+		return nil, fmt.Errorf("protobuf editions can't be parsed (compile it into a descriptor set with protoc and use --proto_descriptors instead)")
+	}
+	fileDescs, err := parser.ParseFiles(relpath)
+	if err != nil {
+		return nil, err
+	}
+	return fileDescs[0], nil
 }
 
-func convertDescriptorsToMap(descs []*desc.FileDescriptor) map[string]*desc.MethodDescriptor {
+// MethodDescriptors finds all the methods of the services defined in the given files
+func MethodDescriptors(descs []*desc.FileDescriptor) map[string]*desc.MethodDescriptor {
 	methods := map[string]*desc.MethodDescriptor{}
 	for _, desc := range descs {
 		for _, service := range desc.GetServices() {
@@ -85,3 +199,65 @@ func convertDescriptorsToMap(descs []*desc.FileDescriptor) map[string]*desc.Meth
 
 	return methods
 }
+
+// MessageTypes finds all the message types defined in the given files and the files they import
+func MessageTypes(descs []*desc.FileDescriptor) map[string]*desc.MessageDescriptor {
+	messageTypes := map[string]*desc.MessageDescriptor{}
+	checked := map[string]bool{}
+	var addFile func(file *desc.FileDescriptor)
+	addFile = func(file *desc.FileDescriptor) {
+		if checked[file.GetName()] {
+			return
+		}
+		checked[file.GetName()] = true
+		for _, message := range file.GetMessageTypes() {
+			addMessage(messageTypes, message)
+		}
+		for _, dependency := range file.GetDependencies() {
+			addFile(dependency)
+		}
+	}
+	for _, desc := range descs {
+		addFile(desc)
+	}
+	return messageTypes
+}
+
+func addMessage(messageTypes map[string]*desc.MessageDescriptor, message *desc.MessageDescriptor) {
+	messageTypes[message.GetFullyQualifiedName()] = message
+	for _, nested := range message.GetNestedMessageTypes() {
+		addMessage(messageTypes, nested)
+	}
+}
+
+// Extensions finds all the extensions defined in the given files and the files they import
+func Extensions(descs []*desc.FileDescriptor) []*desc.FieldDescriptor {
+	var extensions []*desc.FieldDescriptor
+	checked := map[string]bool{}
+	var addFile func(file *desc.FileDescriptor)
+	addFile = func(file *desc.FileDescriptor) {
+		if checked[file.GetName()] {
+			return
+		}
+		checked[file.GetName()] = true
+		extensions = append(extensions, file.GetExtensions()...)
+		for _, message := range file.GetMessageTypes() {
+			extensions = append(extensions, nestedExtensions(message)...)
+		}
+		for _, dependency := range file.GetDependencies() {
+			addFile(dependency)
+		}
+	}
+	for _, desc := range descs {
+		addFile(desc)
+	}
+	return extensions
+}
+
+func nestedExtensions(message *desc.MessageDescriptor) []*desc.FieldDescriptor {
+	extensions := message.GetNestedExtensions()
+	for _, nested := range message.GetNestedMessageTypes() {
+		extensions = append(extensions, nestedExtensions(nested)...)
+	}
+	return extensions
+}
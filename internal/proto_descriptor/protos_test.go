@@ -0,0 +1,181 @@
+package proto_descriptor
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/dynamic"
+	_ "google.golang.org/grpc/status" // compiles in google/rpc/status.proto like the grpc-tools binaries
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testProtos = map[string]string{
+	"roots/service.proto": `syntax = "proto3";
+package test;
+
+import "shared/types.proto";
+import "google/protobuf/timestamp.proto";
+import "google/rpc/status.proto";
+
+service Service {
+    rpc Call(shared.Request) returns (google.rpc.Status);
+}
+
+message Event {
+    google.protobuf.Timestamp time = 1;
+}
+`,
+	"roots/broken.proto": `syntax = "proto3";
+package test;
+
+message Broken {
+`,
+	"roots/editions.proto": `edition = "2023";
+package test;
+`,
+	"imports/shared/types.proto": `syntax = "proto3";
+package shared;
+
+message Request {}
+`,
+}
+
+func TestLoadProtoDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, contents := range testProtos {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roots := filepath.Join(dir, "roots")
+	options := LoadOptions{ImportPaths: []string{filepath.Join(dir, "imports")}}
+
+	files, report, err := LoadProtoDirectories(options, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := MethodDescriptors(files)["/test.Service/Call"]; !ok {
+		t.Errorf("method not found in %v", files)
+	}
+	if !reflect.DeepEqual(report.Loaded, []string{filepath.Join(roots, "service.proto")}) {
+		t.Errorf("unexpected loaded files %v", report.Loaded)
+	}
+	if !reflect.DeepEqual(report.Services, []string{"test.Service"}) {
+		t.Errorf("unexpected services %v", report.Services)
+	}
+	if len(report.Failed) != 2 {
+		t.Errorf("expected broken and editions files to fail, got %v", report.Failed)
+	}
+	if err := report.Failed[filepath.Join(roots, "editions.proto")]; err == nil || !strings.Contains(err.Error(), "editions") {
+		t.Errorf("expected editions error, got %v", err)
+	}
+
+	options.Strict = true
+	if _, _, err := LoadProtoDirectories(options, roots); err == nil {
+		t.Error("expected strict mode to fail")
+	}
+}
+
+// editionsDescriptorSet is the descriptor set protoc writes for:
+//
+//	edition = "2023";
+//	package test;
+//	service Editions { rpc Call(Outer) returns (Outer); }
+//	message Inner { int64 id = 1; }
+//	message Outer { Inner delimited = 1 [features.message_encoding = DELIMITED]; int64 count = 2; }
+func editionsDescriptorSet() *descriptor.FileDescriptorSet {
+	// golang/protobuf v1.3 doesn't know about editions so these fields are added as unrecognized fields
+	edition2023 := []byte{14<<3 | proto.WireVarint, 0xe8, 0x07}
+	delimitedEncoding := []byte{21<<3 | proto.WireBytes, 0x01, 2, 5<<3 | proto.WireVarint, 2}
+	return &descriptor.FileDescriptorSet{File: []*descriptor.FileDescriptorProto{{
+		Name:    proto.String("editions.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("editions"),
+		Service: []*descriptor.ServiceDescriptorProto{{
+			Name: proto.String("Editions"),
+			Method: []*descriptor.MethodDescriptorProto{{
+				Name:       proto.String("Call"),
+				InputType:  proto.String(".test.Outer"),
+				OutputType: proto.String(".test.Outer"),
+			}},
+		}},
+		MessageType: []*descriptor.DescriptorProto{
+			{
+				Name: proto.String("Inner"),
+				Field: []*descriptor.FieldDescriptorProto{
+					{Name: proto.String("id"), Number: proto.Int32(1), Label: descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptor.FieldDescriptorProto_TYPE_INT64.Enum(), JsonName: proto.String("id")},
+				},
+			},
+			{
+				Name: proto.String("Outer"),
+				Field: []*descriptor.FieldDescriptorProto{
+					{Name: proto.String("delimited"), Number: proto.Int32(1), Label: descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Inner"), JsonName: proto.String("delimited"), Options: &descriptor.FieldOptions{XXX_unrecognized: delimitedEncoding}},
+					{Name: proto.String("count"), Number: proto.Int32(2), Label: descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptor.FieldDescriptorProto_TYPE_INT64.Enum(), JsonName: proto.String("count")},
+				},
+			},
+		},
+		XXX_unrecognized: edition2023,
+	}}}
+}
+
+func TestLoadProtoDescriptors_Editions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "descriptors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	contents, err := proto.Marshal(editionsDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "editions.pb")
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := LoadProtoDescriptors(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	method := MethodDescriptors(files)["/test.Editions/Call"]
+	if method == nil {
+		t.Fatalf("expected method to be loaded, got %v", MethodDescriptors(files))
+	}
+
+	warning := editionsWarning(path, editionsDescriptorSet().File[0])
+	if !strings.Contains(warning, "features.message_encoding = DELIMITED on test.Outer.delimited") {
+		t.Errorf("expected warning to name the delimited field, got %s", warning)
+	}
+
+	// {delimited: {id: 5}, count: 7} with the delimited field encoded as a group
+	raw := []byte{1<<3 | proto.WireStartGroup, 1<<3 | proto.WireVarint, 5, 1<<3 | proto.WireEndGroup, 2<<3 | proto.WireVarint, 7}
+	message := dynamic.NewMessage(method.GetInputType())
+	if err := message.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	if inner, ok := message.GetFieldByName("delimited").(*dynamic.Message); !ok || inner.GetFieldByName("id") != int64(5) {
+		t.Errorf("expected delimited field to be decoded, got %v", message)
+	}
+
+	// editions are treated as proto2 so features (e.g. delimited encoding) are ignored when encoding
+	encoded, err := message.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lengthPrefixed := []byte{1<<3 | proto.WireBytes, 2, 1<<3 | proto.WireVarint, 5, 2<<3 | proto.WireVarint, 7}
+	if !reflect.DeepEqual(encoded, lengthPrefixed) {
+		t.Errorf("expected delimited field to be length prefixed, got %v", encoded)
+	}
+}
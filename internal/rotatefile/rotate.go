@@ -0,0 +1,253 @@
+package rotatefile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const timestampFormat = "2006-01-02T15-04-05.000"
+
+type Options struct {
+	// MaxSize is the size in bytes after which the file is rotated (0 to disable)
+	MaxSize int64
+	// Interval is how often the file is rotated (0 to disable)
+	Interval time.Duration
+	// Compression of rotated files, one of "" (none) or "gzip"
+	Compression string
+	// MaxBackups is the number of rotated files to keep (0 to keep all)
+	MaxBackups int
+	// MaxAge is how long rotated files are kept for (0 to keep forever)
+	MaxAge time.Duration
+}
+
+// File is an io.Writer that writes to a file which is rotated by size and/or time.
+// Each call to Write is written to a single file so, as long as callers write
+// whole records at a time, records are never split across files.
+// Rotated files are renamed to include a timestamp e.g. dump-2019-06-24T19-19-46.644.json
+type File struct {
+	sync.Mutex
+	path    string
+	options Options
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	// closed when the file is closed to stop rotating it by time
+	closed chan struct{}
+}
+
+func New(path string, options Options) (*File, error) {
+	switch options.Compression {
+	case "", "none":
+		options.Compression = ""
+	case "gzip":
+	default:
+		return nil, fmt.Errorf("unknown compression %s", options.Compression)
+	}
+
+	f := &File{
+		path:    path,
+		options: options,
+		closed:  make(chan struct{}),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	if options.Interval > 0 {
+		go f.rotateEvery(options.Interval)
+	}
+	return f, nil
+}
+
+// RotateOnSignal rotates the file every time the process receives SIGHUP
+func (f *File) RotateOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for range sigs {
+			if err := f.Rotate(); err != nil {
+				log.Println("Failed to rotate file:", err)
+			}
+		}
+	}()
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.options.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.options.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	select {
+	case <-f.closed:
+	default:
+		close(f.closed)
+	}
+	return f.file.Close()
+}
+
+func (f *File) Rotate() error {
+	f.Lock()
+	defer f.Unlock()
+	return f.rotate()
+}
+
+func (f *File) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Rotate(); err != nil {
+				log.Println("Failed to rotate file:", err)
+			}
+		case <-f.closed:
+			return
+		}
+	}
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+// must be called with the lock held
+func (f *File) rotate() error {
+	if f.size == 0 {
+		// nothing to rotate
+		return nil
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(f.path)
+	rotatedPath := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), f.openedAt.Format(timestampFormat), ext)
+	if err := os.Rename(f.path, rotatedPath); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	// compressing and cleaning up can be slow so don't block writes
+	go func() {
+		if f.options.Compression == "gzip" {
+			if err := compress(rotatedPath); err != nil {
+				log.Println("Failed to compress rotated file:", err)
+			}
+		}
+		if err := f.removeOldFiles(); err != nil {
+			log.Println("Failed to remove old rotated files:", err)
+		}
+	}()
+	return nil
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// rotatedFiles lists previously rotated files from oldest to newest
+func (f *File) rotatedFiles() ([]string, error) {
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(f.path, ext) + "-"
+	matches, err := filepath.Glob(prefix + "*" + ext + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	var rotated []string
+	for _, match := range matches {
+		timestamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(match, prefix), ".gz"), ext)
+		if _, err := time.Parse(timestampFormat, timestamp); err == nil {
+			rotated = append(rotated, match)
+		}
+	}
+	// the timestamp format sorts lexically
+	sort.Strings(rotated)
+	return rotated, nil
+}
+
+func (f *File) removeOldFiles() error {
+	rotated, err := f.rotatedFiles()
+	if err != nil {
+		return err
+	}
+
+	var remove []string
+	if f.options.MaxBackups > 0 && len(rotated) > f.options.MaxBackups {
+		remove = rotated[:len(rotated)-f.options.MaxBackups]
+		rotated = rotated[len(rotated)-f.options.MaxBackups:]
+	}
+	if f.options.MaxAge > 0 {
+		cutoff := time.Now().Add(-f.options.MaxAge)
+		for _, path := range rotated {
+			info, err := os.Stat(path)
+			if err == nil && info.ModTime().Before(cutoff) {
+				remove = append(remove, path)
+			}
+		}
+	}
+
+	for _, path := range remove {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package rotatefile
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFile_RotatesBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotatefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dump.json")
+	f, err := New(path, Options{
+		MaxSize:     10,
+		Compression: "gzip",
+		MaxBackups:  2,
+	})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, record := range []string{"record-1\n", "record-2\n", "record-3\n", "record-4\n"} {
+		if _, err := f.Write([]byte(record)); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		// rotated file names have millisecond precision
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// compression and cleanup happen in the background
+	var rotated []string
+	for i := 0; i < 100; i++ {
+		rotated, err = f.rotatedFiles()
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if len(rotated) == 2 && strings.HasSuffix(rotated[0], ".gz") && strings.HasSuffix(rotated[1], ".gz") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("expected 2 rotated files but got %v", rotated)
+	}
+
+	// the newest rotated file should contain the third record
+	gzFile, err := os.Open(rotated[1])
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer gzFile.Close()
+	gz, err := gzip.NewReader(gzFile)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	contents, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if string(contents) != "record-3\n" {
+		t.Fatalf("unexpected rotated file contents %q", contents)
+	}
+
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if string(current) != "record-4\n" {
+		t.Fatalf("unexpected current file contents %q", current)
+	}
+}
+
+func TestNew_UnsupportedCompression(t *testing.T) {
+	if _, err := New(filepath.Join(os.TempDir(), "unused"), Options{Compression: "lz4"}); err == nil {
+		t.Fatal("expected error for unknown compression")
+	}
+}
@@ -0,0 +1,72 @@
+package tlsmux
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// ConnectionStates tracks the TLS state of the open connections accepted by the TLS listener.
+// HTTP/2 requests are served over h2c once the TLS has been unwrapped so the http.Request
+// doesn't include the TLS state. Instead it can be looked up using the request's remote address.
+type ConnectionStates struct {
+	sync.Mutex
+	conns map[string]*tls.Conn
+}
+
+// Get returns the TLS state of the connection from the given remote address
+func (c *ConnectionStates) Get(remoteAddr string) (tls.ConnectionState, bool) {
+	c.Lock()
+	conn, ok := c.conns[remoteAddr]
+	c.Unlock()
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return conn.ConnectionState(), true
+}
+
+func (c *ConnectionStates) add(conn *tls.Conn) {
+	c.Lock()
+	defer c.Unlock()
+	c.conns[conn.RemoteAddr().String()] = conn
+}
+
+func (c *ConnectionStates) remove(conn *tls.Conn) {
+	c.Lock()
+	defer c.Unlock()
+	if c.conns[conn.RemoteAddr().String()] == conn {
+		delete(c.conns, conn.RemoteAddr().String())
+	}
+}
+
+type trackingListener struct {
+	net.Listener
+	states *ConnectionStates
+}
+
+func (l trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return conn, nil
+	}
+	l.states.add(tlsConn)
+	return &trackedConn{Conn: tlsConn, tlsConn: tlsConn, states: l.states}, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	tlsConn *tls.Conn
+	states  *ConnectionStates
+	close   sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.close.Do(func() {
+		c.states.remove(c.tlsConn)
+	})
+	return c.Conn.Close()
+}
@@ -40,7 +40,9 @@ func (c *tlsMuxListener) Close() error {
 	return err
 }
 
-func New(logger logrus.FieldLogger, listener net.Listener, cert *x509.Certificate, tlsCert tls.Certificate) (net.Listener, net.Listener) {
+// New splits the listener into a listener for non-TLS connections and a listener for TLS connections (which are
+// unwrapped using tlsCert). The TLS state of connections from the TLS listener can be looked up in the returned ConnectionStates.
+func New(logger logrus.FieldLogger, listener net.Listener, cert *x509.Certificate, tlsCert tls.Certificate) (net.Listener, net.Listener, *ConnectionStates) {
 	var nonTlsConns = make(chan net.Conn, 128) // TODO decide on good buffer sizes for these channels
 	var nonTlsErrs = make(chan error, 128)
 	var tlsConns = make(chan net.Conn, 128)
@@ -82,18 +84,22 @@ func New(logger logrus.FieldLogger, listener net.Listener, cert *x509.Certificat
 		},
 		false,
 	}
+	states := &ConnectionStates{conns: map[string]*tls.Conn{}}
 	tlsListener := nonHTTPBouncer{
 		logger,
-		tls.NewListener(&tlsMuxListener{
-			Listener: listener,
-			close:    closer,
-			conns:    tlsConns,
-		}, &tls.Config{
-			Certificates: []tls.Certificate{tlsCert},
-		}),
+		trackingListener{
+			tls.NewListener(&tlsMuxListener{
+				Listener: listener,
+				close:    closer,
+				conns:    tlsConns,
+			}, &tls.Config{
+				Certificates: []tls.Certificate{tlsCert},
+			}),
+			states,
+		},
 		true,
 	}
-	return nonTlsListener, tlsListener
+	return nonTlsListener, tlsListener, states
 }
 
 func handleTlsConn(logger logrus.FieldLogger, conn net.Conn, cert *x509.Certificate, tlsConns chan net.Conn) {
@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// Upstream is filled in by ConnPool.GetClientConn with details of the connection used to forward an RPC
+type Upstream struct {
+	// time spent dialling a new connection (zero if an existing connection was reused)
+	Dial time.Duration
+	// address of the server the connection was made to
+	Address string
+}
+
+type upstreamKey struct{}
+
+// WithUpstream returns a context in which ConnPool.GetClientConn will record
+// details of the upstream connection into upstream
+func WithUpstream(ctx context.Context, upstream *Upstream) context.Context {
+	return context.WithValue(ctx, upstreamKey{}, upstream)
+}
+
+func upstreamFromContext(ctx context.Context) *Upstream {
+	if upstream, ok := ctx.Value(upstreamKey{}).(*Upstream); ok {
+		return upstream
+	}
+	// nothing is recording so discard the details
+	return &Upstream{}
+}